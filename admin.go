@@ -136,6 +136,8 @@ func (b *Bot) handleAdminCommand(message *tgbotapi.Message) {
 		b.handleSay(message)
 	case "aistat", "aistats":
 		b.handleAIStats(message)
+	case "reload":
+		b.handleReload(message)
 	default:
 		b.handleUnknownCommand(message)
 	}
@@ -3,7 +3,6 @@ package main
 import (
 	"bytes"
 	"context"
-	"encoding/json"
 	"fmt"
 	"image"
 	"image/jpeg"
@@ -23,12 +22,12 @@ import (
 func (b *Bot) GenerateImage(description string, chatID int64, enableDescription bool) (*tgbotapi.PhotoConfig, error) {
 	log.Printf("[GenerateImage] Генерация img для chatID: %d Описание: %v", chatID, description)
 
-	// Создаем контекст с таймаутом
-	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	// Создаем контекст с таймаутом, отменяется и при остановке бота
+	ctx, cancel := context.WithTimeout(b.workCtx, 60*time.Second)
 	defer cancel()
 
 	// Подготовка URL для запроса
-	url := fmt.Sprintf("%s%s", b.config.AIImageURL, url.QueryEscape(description))
+	url := fmt.Sprintf("%s%s", b.config().AIImageURL, url.QueryEscape(description))
 	log.Printf("[GenerateImage] URL запроса: %s", url)
 
 	// Выполнение HTTP GET запроса с таймаутом
@@ -121,243 +120,88 @@ func (b *Bot) GenerateImage(description string, chatID int64, enableDescription
 	return &photo, nil
 }
 
-func (b *Bot) generateAiRequest(systemPrompt string, prompt string, message *tgbotapi.Message) (string, error) {
+// generateAiRequest отправляет системный и пользовательский промпт через настроенного LLM провайдера.
+// Повторы выполняются по b.config().AiRetry, отмена ctx прерывает и запрос, и ожидание между попытками.
+func (b *Bot) generateAiRequest(ctx context.Context, systemPrompt string, prompt string, message *tgbotapi.Message) (string, error) {
 	// Логируем параметры запроса
-	log.Printf("[generateAiRequest] Начало запроса к AI. ChatID: %d, Model: %s", message.Chat.ID, b.config.AiModelName)
+	log.Printf("[generateAiRequest] Начало запроса к AI. ChatID: %d, Provider: %s, Model: %s", message.Chat.ID, b.llm.Name(), b.config().AiModelName)
 	log.Printf("[generateAiRequest] System prompt: %s", systemPrompt)
 	log.Printf("[generateAiRequest] User prompt[%d]: %v", len(prompt), b.truncateText(prompt, 256))
 
-	request := LocalLLMRequest{
-		Model: b.config.AiModelName,
-		Messages: []LocalLLMMessage{
-			{
-				Role:    "system",
-				Content: systemPrompt,
-			},
-			{
-				Role:    "user",
-				Content: prompt,
-			},
-		},
-		Temperature: 0.7,
-		MaxTokens:   16000,
-	}
+	messages := b.buildAiMessages(systemPrompt, prompt)
+	opts := b.aiOptions()
+
+	var result *LLMResult
+	err := b.config().AiRetry.Do(ctx, "generateAiRequest", func(ctx context.Context) error {
+		attemptCtx, cancel := context.WithTimeout(ctx, AI_REQUEST_TIMEOUT*time.Second)
+		defer cancel()
 
-	jsonData, err := json.Marshal(request)
+		var err error
+		result, err = b.llm.Complete(attemptCtx, messages, opts)
+		return err
+	})
 	if err != nil {
-		return "", fmt.Errorf("ошибка маршалинга запроса: %v", err)
+		return "", err
 	}
 
-	// Retry logic with exponential backoff
-	const maxRetries = 3
-	baseDelay := 60000 * time.Millisecond
-	for attempt := 0; attempt < maxRetries; attempt++ {
-		// Логируем отправку запроса
-		log.Printf("[generateAiRequest] Попытка %d/%d. Отправка запроса к %s", attempt+1, maxRetries, b.config.LocalLLMUrl)
-
-		resp, err := b.httpClient.Post(b.config.LocalLLMUrl, "application/json", bytes.NewBuffer(jsonData))
-		if err != nil {
-			log.Printf("[generateAiRequest] Ошибка HTTP запроса (попытка %d): %v", attempt+1, err)
-			if attempt == maxRetries-1 {
-				return "", fmt.Errorf("ошибка HTTP запроса после %d попыток: %v", maxRetries, err)
-			}
-			time.Sleep(baseDelay * time.Duration(2<<uint(attempt)))
-			continue
-		}
-
-		// Создаем ограниченный Reader для предотвращения утечки памяти
-		limitReader := &io.LimitedReader{
-			R: resp.Body,
-			N: 10 * 1024 * 1024, // Ограничиваем чтение 10MB
-		}
-
-		var response LocalLLMResponse
-		if err := json.NewDecoder(limitReader).Decode(&response); err != nil {
-			resp.Body.Close()
-			log.Printf("[generateAiRequest] Ошибка декодирования ответа (попытка %d): %v", attempt+1, err)
-			if attempt == maxRetries-1 {
-				return "", fmt.Errorf("ошибка декодирования ответа после %d попыток: %v", maxRetries, err)
-			}
-			time.Sleep(baseDelay * time.Duration(2<<uint(attempt)))
-			continue
-		}
-
-		resp.Body.Close()
-
-		if resp.StatusCode != http.StatusOK {
-			// Читаем тело ответа для 400 ошибки
-			if resp.StatusCode == http.StatusBadRequest {
-				body, err := io.ReadAll(resp.Body)
-				if err == nil {
-					log.Printf("[generateAiRequest] Тело ответа при 400 ошибке (попытка %d): %s", attempt+1, string(body))
-				}
-			}
-			resp.Body.Close()
-			log.Printf("[generateAiRequest] Неверный статус код (попытка %d): %d", attempt+1, resp.StatusCode)
-			if attempt == maxRetries-1 {
-				return "", fmt.Errorf("неверный статус код после %d попыток: %d", maxRetries, resp.StatusCode)
-			}
-			time.Sleep(baseDelay * time.Duration(2<<uint(attempt)))
-			continue
-		}
-
-		if len(response.Choices) == 0 {
-			log.Printf("[generateAiRequest] Пустой ответ от LLM (попытка %d)", attempt+1)
-			if attempt == maxRetries-1 {
-				return "", fmt.Errorf("пустой ответ от LLM после %d попыток", maxRetries)
-			}
-			time.Sleep(baseDelay * time.Duration(2<<uint(attempt)))
-			continue
-		}
+	// Успешный ответ
+	log.Printf("[generateAiRequest] Успешный ответ получен")
 
-		// Успешный ответ
-		log.Printf("[generateAiRequest] Успешный ответ получен (попытка %d)", attempt+1)
-		summary := response.Choices[0].Message.Content
-		if idx := strings.Index(summary, "--"); idx != -1 {
-			summary = summary[:idx]
-		}
+	// После получения ответа от AI сохраняем информацию о токенах
+	b.saveBilling(message, result)
 
-		// После получения ответа от AI сохраняем информацию о токенах
-		if response.Usage.TotalTokens > 0 {
-			record := db.BillingRecord{
-				UserID:           message.From.ID,
-				ChatID:           message.Chat.ID,
-				Timestamp:        time.Now().Unix(),
-				Model:            response.Model,
-				PromptTokens:     response.Usage.PromptTokens,
-				CompletionTokens: response.Usage.CompletionTokens,
-				TotalTokens:      response.Usage.TotalTokens,
-				Cost:             calculateCost(response.Model, response.Usage.TotalTokens),
-			}
-
-			if err := b.db.SaveBillingRecord(record); err != nil {
-				log.Printf("Ошибка биллинга: %v", err)
-			}
-		}
+	return cleanAiResponse(result.Content), nil
+}
 
-		return strings.TrimSpace(summary), nil
+// buildAiMessages формирует диалог из системного и пользовательского промпта
+func (b *Bot) buildAiMessages(systemPrompt, prompt string) []LLMMessage {
+	return []LLMMessage{
+		{
+			Role:    "system",
+			Content: systemPrompt,
+		},
+		{
+			Role:    "user",
+			Content: prompt,
+		},
 	}
-
-	return "", fmt.Errorf("все %d попытки завершились неудачей", maxRetries)
 }
 
-// generateAiRequest улучшенная версия с проверкой размера ответа
-func (b *Bot) generateAiRequestV2(systemPrompt string, prompt string, message *tgbotapi.Message) (string, error) {
-	// Логируем параметры запроса
-	log.Printf("[generateAiRequest] Начало запроса к AI. ChatID: %d, Model: %s", message.Chat.ID, b.config.AiModelName)
-	log.Printf("[generateAiRequest] System prompt: %s", systemPrompt)
-	log.Printf("[generateAiRequest] User prompt[%d]: %v", len(prompt), b.truncateText(prompt, 256))
-
-	request := LocalLLMRequest{
-		Model: b.config.AiModelName,
-		Messages: []LocalLLMMessage{
-			{
-				Role:    "system",
-				Content: systemPrompt,
-			},
-			{
-				Role:    "user",
-				Content: prompt,
-			},
-		},
+// aiOptions параметры генерации по умолчанию
+func (b *Bot) aiOptions() LLMOptions {
+	return LLMOptions{
+		Model:       b.config().AiModelName,
 		Temperature: 0.7,
 		MaxTokens:   16000,
 	}
+}
 
-	jsonData, err := json.Marshal(request)
-	if err != nil {
-		return "", fmt.Errorf("ошибка маршалинга запроса: %v", err)
+// cleanAiResponse обрезает служебный хвост ответа модели
+func cleanAiResponse(content string) string {
+	if idx := strings.Index(content, "--"); idx != -1 {
+		content = content[:idx]
 	}
+	return strings.TrimSpace(content)
+}
 
-	// Retry logic with exponential backoff
-	const maxRetries = 3
-	baseDelay := 60000 * time.Millisecond
-	for attempt := 0; attempt < maxRetries; attempt++ {
-		// Логируем отправку запроса
-		log.Printf("[generateAiRequest] Попытка %d/%d. Отправка запроса к %s", attempt+1, maxRetries, b.config.LocalLLMUrl)
-
-		resp, err := b.httpClient.Post(b.config.LocalLLMUrl, "application/json", bytes.NewBuffer(jsonData))
-		if err != nil {
-			log.Printf("[generateAiRequest] Ошибка HTTP запроса (попытка %d): %v", attempt+1, err)
-			if attempt == maxRetries-1 {
-				return "", fmt.Errorf("ошибка HTTP запроса после %d попыток: %v", maxRetries, err)
-			}
-			time.Sleep(baseDelay * time.Duration(2<<uint(attempt)))
-			continue
-		}
-
-		// Создаем ограниченный Reader для предотвращения утечки памяти
-		limitReader := &io.LimitedReader{
-			R: resp.Body,
-			N: 10 * 1024 * 1024, // Ограничиваем чтение 10MB
-		}
-
-		var response LocalLLMResponse
-		if err := json.NewDecoder(limitReader).Decode(&response); err != nil {
-			resp.Body.Close()
-			log.Printf("[generateAiRequest] Ошибка декодирования ответа (попытка %d): %v", attempt+1, err)
-			if attempt == maxRetries-1 {
-				return "", fmt.Errorf("ошибка декодирования ответа после %d попыток: %v", maxRetries, err)
-			}
-			time.Sleep(baseDelay * time.Duration(2<<uint(attempt)))
-			continue
-		}
-
-		resp.Body.Close()
-
-		if resp.StatusCode != http.StatusOK {
-			// Читаем тело ответа для 400 ошибки
-			if resp.StatusCode == http.StatusBadRequest {
-				body, err := io.ReadAll(resp.Body)
-				if err == nil {
-					log.Printf("[generateAiRequest] Тело ответа при 400 ошибке (попытка %d): %s", attempt+1, string(body))
-				}
-			}
-			resp.Body.Close()
-			log.Printf("[generateAiRequest] Неверный статус код (попытка %d): %d", attempt+1, resp.StatusCode)
-			if attempt == maxRetries-1 {
-				return "", fmt.Errorf("неверный статус код после %d попыток: %d", maxRetries, resp.StatusCode)
-			}
-			time.Sleep(baseDelay * time.Duration(2<<uint(attempt)))
-			continue
-		}
-
-		if len(response.Choices) == 0 {
-			log.Printf("[generateAiRequest] Пустой ответ от LLM (попытка %d)", attempt+1)
-			if attempt == maxRetries-1 {
-				return "", fmt.Errorf("пустой ответ от LLM после %d попыток", maxRetries)
-			}
-			time.Sleep(baseDelay * time.Duration(2<<uint(attempt)))
-			continue
-		}
-
-		// Успешный ответ
-		log.Printf("[generateAiRequest] Успешный ответ получен (попытка %d)", attempt+1)
-		summary := response.Choices[0].Message.Content
-		if idx := strings.Index(summary, "--"); idx != -1 {
-			summary = summary[:idx]
-		}
-
-		// После получения ответа от AI сохраняем информацию о токенах
-		if response.Usage.TotalTokens > 0 {
-			record := db.BillingRecord{
-				UserID:           message.From.ID,
-				ChatID:           message.Chat.ID,
-				Timestamp:        time.Now().Unix(),
-				Model:            response.Model,
-				PromptTokens:     response.Usage.PromptTokens,
-				CompletionTokens: response.Usage.CompletionTokens,
-				TotalTokens:      response.Usage.TotalTokens,
-				Cost:             calculateCost(response.Model, response.Usage.TotalTokens),
-			}
-
-			if err := b.db.SaveBillingRecord(record); err != nil {
-				log.Printf("Ошибка биллинга: %v", err)
-			}
-		}
+// saveBilling сохраняет информацию о токенах запроса
+func (b *Bot) saveBilling(message *tgbotapi.Message, result *LLMResult) {
+	if result.Usage.TotalTokens <= 0 {
+		return
+	}
 
-		return strings.TrimSpace(summary), nil
+	record := db.BillingRecord{
+		UserID:           message.From.ID,
+		ChatID:           message.Chat.ID,
+		Timestamp:        time.Now().Unix(),
+		Model:            result.Model,
+		PromptTokens:     result.Usage.PromptTokens,
+		CompletionTokens: result.Usage.CompletionTokens,
+		TotalTokens:      result.Usage.TotalTokens,
+		Cost:             calculateCost(result.Model, result.Usage.TotalTokens),
 	}
 
-	return "", fmt.Errorf("все %d попытки завершились неудачей", maxRetries)
+	if err := b.db.SaveBillingRecord(record); err != nil {
+		log.Printf("Ошибка биллинга: %v", err)
+	}
 }
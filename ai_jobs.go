@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// aiJobs реестр выполняющихся генераций по чатам для команды /cancel
+type aiJobs struct {
+	mu     sync.Mutex
+	nextID int
+	jobs   map[int64]map[int]context.CancelFunc
+}
+
+func newAiJobs() *aiJobs {
+	return &aiJobs{jobs: make(map[int64]map[int]context.CancelFunc)}
+}
+
+// start регистрирует генерацию в чате. done нужно вызвать по завершении.
+func (j *aiJobs) start(parent context.Context, chatID int64) (ctx context.Context, done func()) {
+	ctx, cancel := context.WithCancel(parent)
+
+	j.mu.Lock()
+	j.nextID++
+	id := j.nextID
+	if j.jobs[chatID] == nil {
+		j.jobs[chatID] = make(map[int]context.CancelFunc)
+	}
+	j.jobs[chatID][id] = cancel
+	j.mu.Unlock()
+
+	return ctx, func() {
+		j.mu.Lock()
+		delete(j.jobs[chatID], id)
+		if len(j.jobs[chatID]) == 0 {
+			delete(j.jobs, chatID)
+		}
+		j.mu.Unlock()
+		cancel()
+	}
+}
+
+// cancel отменяет все генерации в чате и возвращает их количество
+func (j *aiJobs) cancel(chatID int64) int {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	count := 0
+	for _, cancel := range j.jobs[chatID] {
+		cancel()
+		count++
+	}
+	return count
+}
+
+// isCancelCommand команда /cancel в чате, где бот работает
+func (b *Bot) isCancelCommand(update tgbotapi.Update) bool {
+	message := update.Message
+	if message == nil || message.Chat == nil || message.From == nil || !message.IsCommand() {
+		return false
+	}
+	switch message.Command() {
+	case "cancel", "стоп":
+		return b.isChatAllowed(message.Chat.ID)
+	}
+	return false
+}
+
+// dispatchUpdate передает обновление в очередь чата. /cancel выполняется сразу:
+// генерации идут в обработчике чата, и в очереди команда дождалась бы конца той, что должна остановить.
+func (b *Bot) dispatchUpdate(update tgbotapi.Update) error {
+	if b.isCancelCommand(update) {
+		log.Printf("[dispatchUpdate] /cancel от %s в чате %d", getUserName(update.Message.From), update.Message.Chat.ID)
+		b.handleCancel(update.Message)
+		return nil
+	}
+	return b.dispatcher.Dispatch(update)
+}
+
+// handleCancel обрабатывает команду /cancel
+func (b *Bot) handleCancel(message *tgbotapi.Message) {
+	if count := b.aiJobs.cancel(message.Chat.ID); count > 0 {
+		b.sendMessage(message.Chat.ID, "⏹ Останавливаю генерацию...")
+		return
+	}
+	b.sendMessage(message.Chat.ID, "Сейчас ничего не генерируется.")
+}
+
+// replyAiError сообщает об ошибке генерации; отмену через /cancel ошибкой не считаем
+func (b *Bot) replyAiError(chatID int64, err error, text string) {
+	if errors.Is(err, context.Canceled) {
+		b.sendMessage(chatID, "⏹ Генерация отменена.")
+		return
+	}
+	b.sendMessage(chatID, text)
+}
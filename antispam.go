@@ -0,0 +1,350 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"log"
+	"strings"
+	"time"
+
+	"facilitatorbot/db"
+	"facilitatorbot/module"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const SPAM_REPORT_TEXT_LEN = 1000 // сколько текста спама показывать в отчете
+
+// SPAM_ACTIONS меры антиспама и их названия для отчетов
+var SPAM_ACTIONS = map[string]string{
+	"warn":   "предупреждение",
+	"delete": "удаление сообщения",
+	"mute":   "ограничение",
+	"ban":    "бан",
+}
+
+// Источники решения антиспама
+const (
+	SPAM_SOURCE_RULES = "rules"
+	SPAM_SOURCE_LLM   = "llm"
+	SPAM_SOURCE_FLOOD = "flood"
+	SPAM_SOURCE_RATE  = db.INCIDENT_SOURCE_RATE // слишком частые сообщения: свои меры, без бана
+)
+
+// SPAM_SOURCES названия источников для отчетов и статистики
+var SPAM_SOURCES = map[string]string{
+	SPAM_SOURCE_RULES: "правила",
+	SPAM_SOURCE_LLM:   "ИИ",
+	SPAM_SOURCE_FLOOD: "флуд",
+	SPAM_SOURCE_RATE:  "частота",
+}
+
+// spamFinding почему сообщение признано спамом
+type spamFinding struct {
+	Source     string
+	Reason     string
+	Categories []string          // по ним считается точность правил
+	Raiders    []module.FloodHit // для рейда: пропущенные до порога сообщения других участников
+}
+
+// spamAction мера за нарушение с номером strike (с 1): по порядку из actions, дальше последняя
+func spamAction(actions []string, strike int) string {
+	if len(actions) == 0 {
+		return "warn"
+	}
+	return actions[min(max(strike, 1), len(actions))-1]
+}
+
+// checkSpam проверяет сообщение на флуд, текст или подпись - правилами чата, сомнительные - моделью,
+// и применяет меры. Администраторы и белый список не наказываются. Возвращает true, если сообщение признано спамом.
+func (b *Bot) checkSpam(message *tgbotapi.Message) bool {
+	// От имени чата пишут анонимные администраторы и привязанный канал, а посты канала
+	// Telegram пересылает в обсуждение сам: From у них служебный (GroupAnonymousBot, Telegram),
+	// и мера досталась бы не автору
+	if message.SenderChat != nil || message.IsAutomaticForward {
+		return false
+	}
+
+	input := spamInput(message)
+	chatID := message.Chat.ID
+	cfg := b.chatConfig(chatID)
+
+	whitelisted, err := b.db.IsSpamWhitelisted(chatID, message.From.ID)
+	if err != nil {
+		log.Printf("[checkSpam] %v", err)
+	}
+	if whitelisted {
+		return false
+	}
+
+	// Частоту считаем и по фото и голосовым без подписи
+	finding, isFlood := b.checkFlood(message, input.Text, cfg)
+	if !isFlood {
+		if input.Text == "" {
+			return false
+		}
+		score := b.spamRules.Get(b.config(), chatID).Score(input)
+		finding = spamFinding{Source: SPAM_SOURCE_RULES, Reason: score.Reason(), Categories: score.Categories()}
+		if score.Total < cfg.SpamThreshold {
+			// Ниже порога решает модель, если сообщение сомнительное или от нового участника
+			verdict, isSpam := b.checkSpamLLM(message, input.Text, score, cfg)
+			if !isSpam {
+				return false
+			}
+			finding = spamFinding{Source: SPAM_SOURCE_LLM, Reason: verdict.Reason(score), Categories: []string{verdict.Category}}
+		}
+	}
+
+	// Права проверяем только для подозрительных сообщений, это запрос к Telegram.
+	// Если Telegram не ответил, администратора можно принять за спамера - меры не применяем.
+	isAdmin, err := b.IsUserAdmin(chatID, message.From.ID)
+	if err != nil {
+		log.Printf("[checkSpam] Права %s не проверены, сообщение похоже на спам (%s), меры не применены: %v",
+			getUserName(message.From), finding.Reason, err)
+		return false
+	}
+	if isAdmin {
+		log.Printf("[checkSpam] Сообщение администратора %s похоже на спам (%s), пропускаем", getUserName(message.From), finding.Reason)
+		return false
+	}
+
+	b.handleSpamMessage(message, input.Text, finding)
+	b.punishRaiders(message, finding)
+	return true
+}
+
+// punishRaiders применяет меры к участникам рейда, чьи сообщения пришли до того, как рейд был замечен
+func (b *Bot) punishRaiders(message *tgbotapi.Message, finding spamFinding) {
+	chatID := message.Chat.ID
+	for _, hit := range finding.Raiders {
+		whitelisted, err := b.db.IsSpamWhitelisted(chatID, hit.UserID)
+		if err != nil {
+			log.Printf("[punishRaiders] %v", err)
+		}
+		if whitelisted {
+			continue
+		}
+		isAdmin, err := b.IsUserAdmin(chatID, hit.UserID)
+		if err != nil {
+			log.Printf("[punishRaiders] Права участника рейда %d не проверены, меры не применены: %v", hit.UserID, err)
+			continue
+		}
+		if isAdmin {
+			continue
+		}
+
+		// Имя и текст участника - из истории, сообщение сохранено до проверки
+		raider := &tgbotapi.Message{MessageID: hit.MessageID, From: &tgbotapi.User{ID: hit.UserID}, Chat: message.Chat}
+		stored, err := b.db.GetMessage(chatID, hit.MessageID)
+		if err != nil {
+			log.Printf("[punishRaiders] %v", err)
+		}
+		if stored != nil {
+			raider.From.UserName, raider.From.FirstName, raider.From.LastName = stored.Username, stored.UserFirstName, stored.UserLastName
+			raider.Text = stored.Text
+		}
+		b.handleSpamMessage(raider, raider.Text, finding)
+	}
+}
+
+// checkFlood ищет повторы, рейды новых участников, рассылки по чатам и слишком частые сообщения.
+// Возвращает причину для инцидента и true, если флуд найден.
+func (b *Bot) checkFlood(message *tgbotapi.Message, text string, cfg Config) (spamFinding, bool) {
+	if !cfg.FloodEnabled {
+		return spamFinding{}, false
+	}
+
+	floodMessage := module.FloodMessage{ChatID: message.Chat.ID, UserID: message.From.ID, MessageID: message.MessageID, Text: text, Time: time.Now()}
+	if text != "" && cfg.FloodRaidUsers > 0 {
+		isNew, err := b.db.IsNewUserInChat(message.Chat.ID, message.From.ID)
+		if err != nil {
+			log.Printf("[checkFlood] %v", err)
+		}
+		floodMessage.NewUser = isNew
+	}
+
+	verdict := b.flood.Check(floodMessage, module.FloodLimits{
+		Window:     cfg.FloodWindow,
+		MinLength:  cfg.FloodMinLength,
+		Repeats:    cfg.FloodRepeats,
+		RaidUsers:  cfg.FloodRaidUsers,
+		Chats:      cfg.FloodChats,
+		RateLimit:  cfg.FloodRateLimit,
+		RateWindow: cfg.FloodRateWindow,
+	})
+	if verdict.Kind == "" {
+		return spamFinding{}, false
+	}
+	source := SPAM_SOURCE_FLOOD
+	if verdict.Kind == module.FLOOD_RATE {
+		source = SPAM_SOURCE_RATE
+	}
+	return spamFinding{
+		Source:     source,
+		Reason:     verdict.Reason(),
+		Categories: []string{module.FLOOD_KINDS[verdict.Kind]},
+		Raiders:    verdict.Earlier,
+	}, true
+}
+
+// handleSpamMessage выбирает меру по числу прошлых нарушений, применяет ее,
+// сохраняет инцидент и отправляет отчет администраторам.
+// Слишком частые сообщения - не спам: нарушения считаются отдельно, меры из flood_rate_actions.
+func (b *Bot) handleSpamMessage(message *tgbotapi.Message, text string, finding spamFinding) {
+	chatID := message.Chat.ID
+	userID := message.From.ID
+	cfg := b.chatConfig(chatID)
+	now := time.Now()
+
+	rate := finding.Source == SPAM_SOURCE_RATE
+	actions, muteDuration := cfg.SpamActions, cfg.SpamMuteDuration
+	if rate {
+		actions, muteDuration = cfg.FloodRateActions, cfg.FloodMuteDuration
+	}
+	previous, err := b.db.CountUserIncidents(chatID, userID, now.Add(-cfg.SpamStrikeWindow).Unix(), rate)
+	if err != nil {
+		log.Printf("[handleSpamMessage] %v", err)
+	}
+
+	incident := db.SpamIncident{
+		ChatID:     chatID,
+		UserID:     userID,
+		MessageID:  message.MessageID,
+		Text:       text,
+		Reason:     finding.Reason,
+		Source:     finding.Source,
+		Categories: finding.Categories,
+		Strike:     previous + 1,
+		Action:     spamAction(actions, previous+1),
+		Timestamp:  now.Unix(),
+		Username:   message.From.UserName,
+		FirstName:  message.From.FirstName,
+		LastName:   message.From.LastName,
+		ChatTitle:  getChatTitle(message),
+	}
+	deleteErr, err := b.applySpamAction(message, incident.Action, finding, muteDuration)
+	if deleteErr != nil {
+		log.Printf("[handleSpamMessage] Сообщение %s не удалено: %v", getUserName(message.From), deleteErr)
+		incident.DeleteError = deleteErr.Error()
+	}
+	if err != nil {
+		log.Printf("[handleSpamMessage] Мера %s к %s не применена: %v", incident.Action, getUserName(message.From), err)
+		incident.ActionError = err.Error()
+	}
+	log.Printf("[handleSpamMessage] Спам от %s в чате %d: %s, нарушение %d, мера %s",
+		getUserName(message.From), chatID, finding.Reason, incident.Strike, incident.Action)
+
+	if incident.ID, err = b.db.SaveIncident(incident); err != nil {
+		log.Printf("[handleSpamMessage] %v", err)
+	}
+	// Сообщение сохранено в историю до проверки; удаленный спам не должен попадать в сводки и поиск
+	if incident.MessageDeleted() {
+		if err := b.db.DeleteMessage(chatID, message.MessageID); err != nil {
+			log.Printf("[handleSpamMessage] %v", err)
+		}
+	}
+	b.reportSpam(incident, cfg.SpamReportChatID)
+}
+
+// applySpamAction применяет меру к автору сообщения и сообщает об этом в чат.
+// Каждая мера строже предыдущей: delete, mute и ban удаляют сообщение.
+// Возвращает ошибку удаления и ошибку меры: mute и ban применяются, даже если сообщение удалить не удалось.
+func (b *Bot) applySpamAction(message *tgbotapi.Message, action string, finding spamFinding, muteDuration time.Duration) (deleteErr, err error) {
+	chatID := message.Chat.ID
+	user := userLink(message.From)
+	reason := html.EscapeString(finding.Reason)
+	warning, violation, repeated := "сообщение похоже на спам", "похоже на спам", "повторный спам"
+	if finding.Source == SPAM_SOURCE_RATE {
+		warning, violation, repeated = "вы пишете слишком часто", "слишком частые сообщения", "повторный флуд"
+	}
+
+	if action == "warn" {
+		b.sendHTML(chatID, message.MessageID, fmt.Sprintf("🚫 %s, %s (%s).\nПовторные нарушения приведут к ограничениям.",
+			user, warning, reason))
+		return nil, nil
+	}
+
+	// Удалить не выйдет без прав на удаление или если автор уже удалил сообщение
+	if _, err := b.tgBot.Request(tgbotapi.NewDeleteMessage(chatID, message.MessageID)); err != nil {
+		deleteErr = fmt.Errorf("ошибка удаления сообщения: %v", err)
+		if action == "delete" {
+			return deleteErr, deleteErr
+		}
+	}
+
+	member := tgbotapi.ChatMemberConfig{ChatID: chatID, UserID: message.From.ID}
+	var notice string
+	switch action {
+	case "delete":
+		notice = fmt.Sprintf("🚫 Сообщение %s удалено: %s (%s).\nПовторные нарушения приведут к ограничениям.", user, violation, reason)
+	case "mute":
+		restrict := tgbotapi.RestrictChatMemberConfig{
+			ChatMemberConfig: member,
+			UntilDate:        time.Now().Add(muteDuration).Unix(),
+			Permissions:      &tgbotapi.ChatPermissions{},
+		}
+		if _, err := b.tgBot.Request(restrict); err != nil {
+			return deleteErr, fmt.Errorf("ошибка ограничения пользователя: %v", err)
+		}
+		notice = fmt.Sprintf("🔇 %s не может писать %s: %s (%s).", user, formatMuteDuration(muteDuration), repeated, reason)
+	case "ban":
+		if _, err := b.tgBot.Request(tgbotapi.BanChatMemberConfig{ChatMemberConfig: member}); err != nil {
+			return deleteErr, fmt.Errorf("ошибка блокировки пользователя: %v", err)
+		}
+		notice = fmt.Sprintf("⛔ %s заблокирован: %s (%s).", user, repeated, reason)
+	}
+	b.sendHTML(chatID, 0, notice)
+	return deleteErr, nil
+}
+
+// reportSpam отправляет отчет об инциденте с кнопками проверки в чат отчетов,
+// а если он не задан - в личку администраторам
+func (b *Bot) reportSpam(incident db.SpamIncident, reportChatID int64) {
+	if reportChatID != 0 {
+		b.sendIncidentReport(reportChatID, 0, incident)
+		return
+	}
+
+	admins, err := b.tgBot.GetChatAdministrators(tgbotapi.ChatAdministratorsConfig{
+		ChatConfig: tgbotapi.ChatConfig{ChatID: incident.ChatID},
+	})
+	if err != nil {
+		log.Printf("[reportSpam] Ошибка получения администраторов: %v", err)
+		return
+	}
+	for _, admin := range admins {
+		if admin.User == nil || admin.User.IsBot {
+			continue
+		}
+		// Администратор, не начинавший диалог с ботом, отчет не получит - это видно в логе
+		b.sendIncidentReport(admin.User.ID, 0, incident)
+	}
+}
+
+// userLink ссылка на пользователя по ID, работает и без username
+func userLink(user *tgbotapi.User) string {
+	name := strings.TrimSpace(user.FirstName + " " + user.LastName)
+	if name == "" {
+		name = user.UserName
+	}
+	if name == "" {
+		name = fmt.Sprintf("id %d", user.ID)
+	}
+	link := fmt.Sprintf(`<a href="tg://user?id=%d">%s</a>`, user.ID, html.EscapeString(name))
+	if user.UserName != "" {
+		link += " (@" + html.EscapeString(user.UserName) + ")"
+	}
+	return link
+}
+
+// formatMuteDuration срок ограничения для сообщения в чат
+func formatMuteDuration(d time.Duration) string {
+	switch {
+	case d%(24*time.Hour) == 0:
+		return fmt.Sprintf("%d дн.", d/(24*time.Hour))
+	case d%time.Hour == 0:
+		return fmt.Sprintf("%d ч.", d/time.Hour)
+	default:
+		return fmt.Sprintf("%d мин.", d/time.Minute)
+	}
+}
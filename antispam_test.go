@@ -0,0 +1,69 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// testPhoto фото с подписью caption от userID в тестовой группе
+func testPhoto(messageID int, userID int64, caption string) *tgbotapi.Message {
+	message := testMessage(messageID, userID, "")
+	message.Date = int(time.Now().Unix())
+	message.Caption = caption
+	message.Photo = []tgbotapi.PhotoSize{{FileID: "photo", FileUniqueID: "photo", Width: 640, Height: 480, FileSize: 1000}}
+	return message
+}
+
+func TestPhotoDescribedOnlyAfterAntispam(t *testing.T) {
+	config := defaultConfig()
+	config.AntispamEnabled = true
+	config.VisionEnabled = true
+	config.SpamLLMEnabled = false
+	config.CaptchaEnabled = false
+	bot, stub := newTestBot(t, NewFakeLLMProvider(), config)
+
+	// Спам: до модели vision дело не доходит, файл даже не запрашивается
+	bot.processAllMessage(testPhoto(1, 2, "Крипто доход только сегодня, пиши https://bit.ly/x +79991234567"))
+	if n := len(stub.sent("getFile")); n != 0 {
+		t.Fatalf("для спама запрошено %d файлов, ожидалось 0", n)
+	}
+
+	// Обычное фото описывается
+	bot.processAllMessage(testPhoto(2, 3, "Наш кот"))
+	if n := len(stub.sent("getFile")); n != 1 {
+		t.Errorf("для обычного фото запрошено %d файлов, ожидался 1", n)
+	}
+}
+
+func TestCheckSpamSkipsChatSenders(t *testing.T) {
+	config := defaultConfig()
+	config.AntispamEnabled = true
+	config.SpamLLMEnabled = false
+	bot, stub := newTestBot(t, NewFakeLLMProvider(), config)
+
+	const spam = "Крипто доход только сегодня, пиши https://bit.ly/x +79991234567"
+
+	// Анонимный администратор: From - GroupAnonymousBot, SenderChat - сама группа
+	anonymous := testMessage(1, 1087968824, spam)
+	anonymous.SenderChat = &tgbotapi.Chat{ID: TEST_CHAT_ID, Type: "supergroup", Title: "Test"}
+	// Пост привязанного канала, автоматически пересланный в обсуждение
+	channelPost := testMessage(2, 777000, spam)
+	channelPost.SenderChat = &tgbotapi.Chat{ID: -1009876543210, Type: "channel", Title: "Channel"}
+	channelPost.IsAutomaticForward = true
+
+	for _, message := range []*tgbotapi.Message{anonymous, channelPost} {
+		if bot.checkSpam(message) {
+			t.Errorf("сообщение %d от чата признано спамом", message.MessageID)
+		}
+	}
+	if n := len(stub.sent("getChatMember")); n != 0 {
+		t.Errorf("права проверялись %d раз, ожидалось 0", n)
+	}
+
+	// Тот же текст от обычного участника - спам
+	if !bot.checkSpam(testMessage(3, 2, spam)) {
+		t.Errorf("спам от участника не распознан")
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// citationRe ссылки модели на сообщения истории: [#12] или [#3, #7]
+var citationRe = regexp.MustCompile(`\s?\[#\d+(?:\s*,\s*#?\d+)*\]`)
+var citationNumRe = regexp.MustCompile(`\d+`)
+
+// messageLink ссылка на сообщение супергруппы вида t.me/c/<chat>/<msg>.
+// У обычных групп и личных чатов ссылок на сообщения нет, тогда пусто.
+func messageLink(chatID int64, messageID int) string {
+	id := strconv.FormatInt(chatID, 10)
+	if messageID == 0 || !strings.HasPrefix(id, "-100") {
+		return ""
+	}
+	return fmt.Sprintf("https://t.me/c/%s/%d", strings.TrimPrefix(id, "-100"), messageID)
+}
+
+// renderCitations заменяет [#N] в Telegram HTML ссылками на сообщения из links (номер
+// в промпте -> URL). Номера, которых нет в links (выдуманные моделью или сообщения
+// без ссылки), удаляются. Источники нумеруются по порядку появления.
+func renderCitations(text string, links map[int]string) string {
+	labels := make(map[int]int)
+	var out strings.Builder
+	last := 0
+	for _, loc := range citationRe.FindAllStringIndex(text, -1) {
+		out.WriteString(text[last:loc[0]])
+		last = loc[1]
+
+		var refs []string
+		seen := make(map[int]bool)
+		for _, num := range citationNumRe.FindAllString(text[loc[0]:loc[1]], -1) {
+			index, _ := strconv.Atoi(num)
+			url, ok := links[index]
+			if !ok || seen[index] {
+				continue
+			}
+			seen[index] = true
+			if labels[index] == 0 {
+				labels[index] = len(labels) + 1
+			}
+			refs = append(refs, fmt.Sprintf(`<a href="%s">[%d]</a>`, html.EscapeString(url), labels[index]))
+		}
+		if len(refs) > 0 {
+			out.WriteString(" " + strings.Join(refs, ""))
+		}
+	}
+	out.WriteString(text[last:])
+	return out.String()
+}
+
+// stripCitations убирает [#N] из текста, например для описания картинки
+func stripCitations(text string) string {
+	return citationRe.ReplaceAllString(text, "")
+}
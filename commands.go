@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"math/rand"
@@ -13,33 +14,32 @@ import (
 	"facilitatorbot/db"
 )
 
-func (b *Bot) handleAllMessages(message *tgbotapi.Message) {
+// handleAllMessages проверки для всех сообщений: капча, антиспам, благодарности.
+// Возвращает false, если сообщение дальше обрабатывать не нужно.
+func (b *Bot) handleAllMessages(message *tgbotapi.Message) bool {
+	chatConfig := b.chatConfig(message.Chat.ID)
+
 	// ==============Проверяем капчу
-	// Обработка события входа пользователя в чат
-	//
-	// {
-	// 	log.Printf("Новый пользователь %s (%d) в чате %d", message.From.FirstName, message.From.ID, message.Chat.ID)
-	// 	chatID := message.Chat.ID
-	// 	userID := message.From.ID
-	// 	// Проверяем, является ли пользователь новым в этом чате
-	// 	isNewUser, err := b.db.IsNewUserInChat(chatID, userID)
-	// 	if err != nil {
-	// 		log.Printf("Ошибка проверки нового пользователя: %v", err)
-	// 		return
-	// 	}
-	// 	// Если пользователь новый - проверяем капчу
-	// 	if isNewUser {
-	// 		shouldProcess, isCaptchaResponse := b.checkCaptchaRequirement(chatID, userID, message.Text)
-	// 		if !shouldProcess {
-	// 			return
-	// 		}
-	// 		if isCaptchaResponse {
-	// 			// Это ответ на капчу - обрабатываем отдельно
-	// 			b.handleCaptchaResponse(chatID, userID, message.Text)
-	// 			return
-	// 		}
-	// 	}
-	// }
+	if chatConfig.CaptchaEnabled {
+		chatID := message.Chat.ID
+		userID := message.From.ID
+		// Проверяем, является ли пользователь новым в этом чате
+		isNewUser, err := b.db.IsNewUserInChat(chatID, userID)
+		if err != nil {
+			log.Printf("Ошибка проверки нового пользователя: %v", err)
+		} else if isNewUser {
+			// Если пользователь новый - проверяем капчу
+			shouldProcess, isCaptchaResponse := b.checkCaptchaRequirement(chatID, userID, message.Text)
+			if isCaptchaResponse {
+				// Это ответ на капчу - обрабатываем отдельно
+				b.handleCaptchaResponse(chatID, userID, message.Text)
+				return false
+			}
+			if !shouldProcess {
+				return false
+			}
+		}
+	}
 
 	// Обработка события выхода пользователя из чата
 	if message.LeftChatMember != nil {
@@ -47,18 +47,16 @@ func (b *Bot) handleAllMessages(message *tgbotapi.Message) {
 
 	}
 
-	// // ==============Проверка на спам перед обработкой команды
-	// userAdmin, _ := b.IsUserAdmin(message.Chat.ID, message.From.ID)
-	// if !userAdmin {
-	// 	isSpam, reason, _ := module.IsSpam(message.Text)
-	// 	if isSpam {
-	// 		b.handleSpamMessage(message, reason)
-	// 		return
-	// 	}
-	// }
+	// ==============Проверка на спам перед обработкой команды
+	if chatConfig.AntispamEnabled && b.checkSpam(message) {
+		return false
+	}
 
 	// ==============Проверяем, содержит ли сообщение "спасибо" или "спс"
-	b.checkForThanks(message)
+	if chatConfig.ThanksEnabled {
+		b.checkForThanks(message)
+	}
+	return true
 }
 
 // Проверка необходимости капчи
@@ -169,7 +167,21 @@ func (b *Bot) handleCommand(message *tgbotapi.Message) {
 		b.handleTopic(message)
 	case "clear", "забудь":
 		b.handleClear(message)
-	case "say", "сказать":
+	case "cancel", "стоп":
+		b.handleCancel(message)
+	case "digest", "дайджест":
+		b.handleDigest(message)
+	case "settings", "настройки":
+		b.handleSettings(message)
+	case "search", "поиск":
+		b.handleSearch(message)
+	case "find", "найти":
+		b.handleFind(message)
+	case "spamrule", "антиспам":
+		b.handleSpamRule(message)
+	case "incidents", "инциденты":
+		b.handleIncidents(message)
+	case "say", "сказать", "reload":
 		b.handleAdminCommand(message)
 		return
 	case "img":
@@ -242,22 +254,20 @@ func (b *Bot) handleAISummary(message *tgbotapi.Message, count int) {
 		return
 	}
 
-	if count == 0 {
-		count = LIMIT_MSG
+	chatConfig := b.chatConfig(chatID)
+	if count == 0 || count > chatConfig.SummaryLimit {
+		count = chatConfig.SummaryLimit
 	}
 
-	args := strings.Fields(message.CommandArguments())
-	if len(args) > 0 {
-		if num, err := strconv.Atoi(args[0]); err == nil && num > 0 {
-			count = num
-			if count > LIMIT_MSG {
-				count = LIMIT_MSG
-				b.sendMessage(message.Chat.ID, fmt.Sprintf("Я помню только %d сообщений...", LIMIT_MSG))
-			}
-		}
+	window, err := b.parseSummaryWindow(message, count, time.Now())
+	if err != nil {
+		b.sendMessage(chatID, fmt.Sprintf("%v\n%s", err, SUMMARY_USAGE))
+		return
 	}
 
-	messages, err := b.db.GetRecentMessages(chatID, count)
+	// В теме форума сводка только по этой теме, в общей теме (General) - только по ней
+	threadID := b.messageThreadID(message)
+	messages, err := b.db.GetMessagesRange(chatID, threadID, window.From.Unix(), window.To.Unix(), window.Count)
 	if err != nil {
 		log.Printf("[handleSummary] Ошибка получения сообщений: %v", err)
 		b.sendMessage(chatID, "Не удалось получить историю сообщений.")
@@ -265,62 +275,79 @@ func (b *Bot) handleAISummary(message *tgbotapi.Message, count int) {
 	}
 
 	if len(messages) == 0 {
-		message := fmt.Sprintf("Последние %v часов, я похоже спал =)", db.CHECK_HOURS*-1)
-		log.Println(message)
-		b.sendMessage(chatID, message)
+		text := fmt.Sprintf("Последние %v часов, я похоже спал =)", db.CHECK_HOURS*-1)
+		if window.Label != "" {
+			text = "Сообщений " + window.Label + " нет, я похоже спал =)"
+		}
+		log.Println(text)
+		b.sendToTopic(message, text)
 		return
 	}
 
-	// Форматируем историю сообщений
-	var messagesText strings.Builder
-	for _, msg := range messages {
-		msgTime := time.Unix(msg.Timestamp, 0)
-		// Создаем часовой пояс GMT+3
-		gmt3 := time.FixedZone("GMT+3", 3*60*60)
-		// Переводим время сообщения в часовой пояс GMT+3
-		msgTimeGMT3 := msgTime.In(gmt3)
-
-		// Форматируем и добавляем сообщение в буфер
-		messagesText.WriteString(fmt.Sprintf("[%s] %s(%v): %s\n",
-			msgTimeGMT3.Format("15:04"),
-			msg.UserFirstName,
-			msg.Username,
-			msg.Text))
+	var labels []string
+	if window.Label != "" {
+		labels = append(labels, window.Label)
+	}
+	if threadID != 0 {
+		labels = append(labels, "в этой теме")
+	}
+	header := getRandomSummaryTitle()
+	if len(labels) > 0 {
+		header += " (" + strings.Join(labels, ", ") + ")"
+	}
+	if window.Capped || (window.Label != "" && len(messages) == window.Count) {
+		b.sendMessage(chatID, fmt.Sprintf("Я помню только %d сообщений...", window.Count))
+	}
 
-		// // Логируем сообщение
-		// log.Printf("[%s] %s(%v): %s",
-		// 	msgTimeGMT3.Format("15:04"),
-		// 	msg.UserFirstName,
-		// 	msg.Username,
-		// 	msg.Text)
+	// Создание сводки с помощью локальной LLM (с потоковым выводом)
+	ctx, done := b.aiJobs.start(b.workCtx, chatID)
+	defer done()
+	if err := b.summarizeMessages(ctx, message, messages, header); err != nil {
+		log.Printf("[handleSummary] Ошибка генерации сводки: %v", err)
+		b.replyAiError(chatID, err, "Не удалось сгенерировать сводку обсуждений.")
 	}
+}
 
-	// Создание сводки с помощью локальной LLM
-	summary, err := b.generateAiRequest(b.config.SystemPrompt, fmt.Sprintf(b.config.SummaryPrompt, messagesText.String()), message)
+// summarizeMessages отправляет в чат сводку messages с заголовком header и, если включено, картинку к ней
+func (b *Bot) summarizeMessages(ctx context.Context, message *tgbotapi.Message, messages []db.DBMessage, header string) error {
+	chatID := message.Chat.ID
+	chatConfig := b.chatConfig(chatID)
+
+	// Длинная история суммируется по частям, чтобы не выйти за контекст модели
+	prompt, err := b.summaryPrompt(ctx, chatConfig, message, formatSummaryLines(messages, chatConfig.Location()))
 	if err != nil {
-		log.Printf("[handleSummary] Ошибка генерации сводки: %v", err)
-		b.sendMessage(chatID, "Не удалось сгенерировать сводку обсуждений.")
-		return
+		return err
 	}
 
-	b.sendMessage(chatID, getRandomSummaryTitle()+"\n"+summary)
-	b.lastSummary[chatID] = time.Now()
+	// Номера сообщений [#N] в ответе модели превращаются в ссылки на исходные сообщения
+	links := summaryLinks(chatID, messages)
+	render := func(answer string) string { return renderCitations(markdownToHTML(answer), links) }
+	summary, err := b.generateAiRequestStreamHTML(ctx, chatConfig.LanguageSystemPrompt(), prompt, message, header, "", render)
+	if err != nil {
+		return err
+	}
+
+	b.lastSummary.Set(chatID, time.Now())
+
+	if !chatConfig.SummaryImageEnabled {
+		return nil
+	}
 
 	// Генерируем изображение на основе сводки
-	//description := b.config.ImagePrompt + "\n" + summary
-	description := summary
+	//description := b.config().ImagePrompt + "\n" + summary
+	description := stripCitations(summary)
 
 	photo, err := b.GenerateImage(description, chatID, false)
 	if err != nil {
-		// Если не удалось сгенерировать изображение, отправляем текст
+		// Сводка уже отправлена, без картинки
 		log.Printf("[handleSummary] Ошибка генерации изображения: %v", err)
-		return
+		return nil
 	}
 
 	// Отправляем изображение с кратким описанием
 	photo.Caption = ""
 	b.tgBot.Send(photo)
-	b.lastSummary[chatID] = time.Now()
+	return nil
 }
 
 // handleClear обрабатывает команду /clear
@@ -328,94 +355,6 @@ func (b *Bot) handleClear(message *tgbotapi.Message) {
 	b.db.DeleteUserContext(message.Chat.ID, message.From.ID)
 }
 
-// Обработка спам-сообщений
-func (b *Bot) handleSpamMessage(message *tgbotapi.Message, reason string) {
-	// Константы сообщений
-	const (
-		adminWarning = `⚠️ *СПАМ-алерт* в [чате](https://t.me/c/%s/%d) %s
-От: @%s (%s %s)
-Текст сообщения:
-%s`
-		userWarning = `🚫 @%s, сообщение похоже на спам!
-(%s)		
-Повторные нарушения могут привести к ограничениям.`
-	)
-	var err error
-
-	// // Получаем список администраторов чата
-	// admins, err := b.tgBot.GetChatAdministrators(tgbotapi.ChatAdministratorsConfig{
-	// 	ChatConfig: tgbotapi.ChatConfig{ChatID: message.Chat.ID}})
-	// if err != nil {
-	// 	log.Printf("Ошибка получения администраторов: %v", err)
-	// 	return
-	// }
-
-	// // Формируем ссылку на сообщение
-	// chatIDStr := fmt.Sprintf("%d", message.Chat.ID)
-	// if message.Chat.ID < 0 {
-	// 	chatIDStr = fmt.Sprintf("%d", message.Chat.ID*-1)
-	// }
-
-	// // Формируем и отправляем предупреждение админам
-	// warnMsg := fmt.Sprintf(adminWarning,
-	// 	chatIDStr[4:],
-	// 	message.MessageID,
-	// 	message.Chat.Title,
-	// 	message.From.UserName,
-	// 	message.From.FirstName,
-	// 	message.From.LastName,
-	// 	message.Text)
-
-	// for _, admin := range admins {
-	// 	msg := tgbotapi.NewMessage(admin.User.ID, warnMsg)
-	// 	msg.ParseMode = "Markdown"
-	// 	msg.DisableWebPagePreview = true
-	// 	_, err := b.tgBot.Send(msg)
-	// 	if err != nil {
-	// 		log.Printf("Ошибка отправки предупреждения админу %d: %v", admin.User.ID, err)
-	// 	}
-	// }
-
-	// Формируем и отправляем предупреждение пользователю с упоминанием
-	userWarningText := fmt.Sprintf(userWarning, message.From.UserName, reason)
-	userMsg := tgbotapi.NewMessage(message.Chat.ID, userWarningText)
-	userMsg.ReplyToMessageID = message.MessageID
-
-	// Если у пользователя есть username, делаем упоминание
-	if message.From.UserName != "" {
-		userMsg.Entities = []tgbotapi.MessageEntity{
-			{
-				Type:   "mention",
-				Offset: 0,
-				Length: len(message.From.UserName) + 1, // +1 для символа @
-				User:   &tgbotapi.User{ID: message.From.ID},
-			},
-		}
-	}
-
-	_, err = b.tgBot.Send(userMsg)
-	if err != nil {
-		log.Printf("Ошибка отправки предупреждения пользователю: %v", err)
-	}
-
-	// Удаляем спам-сообщение
-	// _, err = b.tgBot.Send(tgbotapi.DeleteMessageConfig{
-	// 	ChatID:    message.Chat.ID,
-	// 	MessageID: message.MessageID,
-	// })
-	// if err != nil {
-	// 	log.Printf("Ошибка удаления сообщения: %v", err)
-	// }
-
-	// Логируем событие в БД
-	go func(msg *tgbotapi.Message) {
-		err := b.db.LogIncident(msg.Chat.ID, msg.From.ID, msg.Text, time.Now().Unix(), reason)
-		if err != nil {
-			log.Printf("Ошибка логирования спама: %v", err)
-		}
-	}(message)
-}
-
 // handleUnknownCommand обрабатывает неизвестные команды
 func (b *Bot) handleUnknownCommand(message *tgbotapi.Message) {
 	// Список случайных ответов
@@ -473,7 +412,7 @@ func (b *Bot) handleGenImage(message *tgbotapi.Message) {
 	// log.Println("[handleGenImage]" + promptImg)
 
 	// Генерируем изображение
-	//photo, err := b.GenerateImage(b.config.ImagePrompt, chatID, false)
+	//photo, err := b.GenerateImage(b.config().ImagePrompt, chatID, false)
 	photo, err := b.GenerateImage(description, chatID, false)
 	if err != nil {
 		log.Printf("Ошибка генерации изображения: %v", err)
@@ -514,15 +453,18 @@ func (b *Bot) handleTopic(message *tgbotapi.Message) {
 	}
 
 	// Создание темы с помощью локальной LLM
-	summary, err := b.generateAiRequest(b.config.SystemPrompt, fmt.Sprintf(b.config.TopicPrompt, messagesText.String()), message)
+	ctx, done := b.aiJobs.start(b.workCtx, chatID)
+	defer done()
+	chatConfig := b.chatConfig(chatID)
+	summary, err := b.generateAiRequest(ctx, chatConfig.LanguageSystemPrompt(), fmt.Sprintf(chatConfig.TopicPrompt, messagesText.String()), message)
 	if err != nil {
 		log.Printf("Ошибка генерации темы: %v", err)
-		b.sendMessage(chatID, "Не удалось сгенерировать тему.")
+		b.replyAiError(chatID, err, "Не удалось сгенерировать тему.")
 		return
 	}
 
-	b.sendMessage(chatID, "Обсудим?\n\n"+summary)
-	b.lastSummary[chatID] = time.Now()
+	b.sendMarkdown(chatID, 0, "Обсудим?\n\n"+summary)
+	b.lastSummary.Set(chatID, time.Now())
 }
 
 // handleAnekdot обрабатывает команду /anekdot
@@ -556,15 +498,18 @@ func (b *Bot) handleAnekdot(message *tgbotapi.Message) {
 	}
 
 	// Создание анекдота с помощью локальной LLM
-	summary, err := b.generateAiRequest(b.config.SystemPrompt, fmt.Sprintf(b.config.AnekdotPrompt, messagesText.String()), message)
+	ctx, done := b.aiJobs.start(b.workCtx, chatID)
+	defer done()
+	chatConfig := b.chatConfig(chatID)
+	summary, err := b.generateAiRequest(ctx, chatConfig.LanguageSystemPrompt(), fmt.Sprintf(chatConfig.AnekdotPrompt, messagesText.String()), message)
 	if err != nil {
 		log.Printf("Ошибка генерации анекдота: %v", err)
-		b.sendMessage(chatID, "Не смог придумать анекдот, попробуй позже.")
+		b.replyAiError(chatID, err, "Не смог придумать анекдот, попробуй позже.")
 		return
 	}
 
-	b.sendMessage(chatID, "📝 Аnekdot:\n\n"+summary)
-	b.lastSummary[chatID] = time.Now()
+	b.sendMarkdown(chatID, 0, "📝 Аnekdot:\n\n"+summary)
+	b.lastSummary.Set(chatID, time.Now())
 }
 
 // handleStats обрабатывает команду /stats
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// TestHandleAISummary команда /summary целиком: история из БД, модель и ответ в чат
+func TestHandleAISummary(t *testing.T) {
+	config := summaryTestConfig()
+	llm := NewFakeLLMProvider("Обсуждали тестовую сводку [#1].")
+	bot, stub := newTestBot(t, llm, config)
+
+	now := time.Now()
+	for i := 1; i <= 5; i++ {
+		message := testMessage(i, int64(i), fmt.Sprintf("сообщение номер %d", i))
+		message.Date = int(now.Add(time.Duration(i-10) * time.Minute).Unix())
+		bot.storeMessage(message, "")
+	}
+
+	command := testMessage(100, 1, "/summary")
+	command.Entities = []tgbotapi.MessageEntity{{Type: "bot_command", Offset: 0, Length: len("/summary")}}
+	bot.handleAISummary(command, 0)
+
+	if llm.CallCount() != 1 {
+		t.Fatalf("история помещается в контекст, ожидался один запрос к модели, было %d", llm.CallCount())
+	}
+	prompt := llm.Calls[0][len(llm.Calls[0])-1].Content
+	for i := 1; i <= 5; i++ {
+		if !strings.Contains(prompt, fmt.Sprintf("сообщение номер %d", i)) {
+			t.Errorf("в промпте нет сообщения %d", i)
+		}
+	}
+
+	var texts []string
+	for _, method := range []string{"sendMessage", "editMessageText"} {
+		for _, request := range stub.sent(method) {
+			texts = append(texts, request.Params["text"])
+		}
+	}
+	if !strings.Contains(strings.Join(texts, "\n"), "Обсуждали тестовую сводку") {
+		t.Errorf("сводка модели не отправлена в чат, отправлено: %q", texts)
+	}
+}
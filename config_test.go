@@ -0,0 +1,47 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGetEnvBool(t *testing.T) {
+	tests := []struct {
+		value      string
+		defaultVal bool
+		want       bool
+	}{
+		{value: "", defaultVal: true, want: true},
+		{value: "true", want: true},
+		{value: "TRUE", want: true},
+		{value: "1", want: true},
+		{value: "yes", want: true},
+		{value: "On", want: true},
+		{value: "false", defaultVal: true, want: false},
+		{value: "0", defaultVal: true, want: false},
+		{value: "no", defaultVal: true, want: false},
+		{value: "да", defaultVal: true, want: true}, // нераспознанное значение - по умолчанию
+		{value: "да", want: false},
+	}
+	for _, tt := range tests {
+		t.Setenv("TEST_BOOL", tt.value)
+		if got := getEnvBool("TEST_BOOL", tt.defaultVal); got != tt.want {
+			t.Errorf("getEnvBool(%q, %v) = %v, ожидалось %v", tt.value, tt.defaultVal, got, tt.want)
+		}
+	}
+}
+
+func TestApplyRetryEnv(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 3, BaseDelay: time.Second, Jitter: 0.2}
+
+	t.Setenv("AI_RETRY_JITTER", "0.5")
+	t.Setenv("AI_RETRY_BASE_DELAY", "2s")
+	if got := applyRetryEnv(policy); got.Jitter != 0.5 || got.BaseDelay != 2*time.Second {
+		t.Errorf("jitter %v, base delay %v, ожидалось 0.5 и 2s", got.Jitter, got.BaseDelay)
+	}
+
+	t.Setenv("AI_RETRY_JITTER", "много")
+	if got := applyRetryEnv(policy); got.Jitter != policy.Jitter {
+		t.Errorf("некорректный AI_RETRY_JITTER: jitter %v, ожидалось прежнее %v", got.Jitter, policy.Jitter)
+	}
+}
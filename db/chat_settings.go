@@ -0,0 +1,135 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// ChatSettings настройки чата, измененные через /settings.
+// nil означает, что значение берется из конфигурации.
+type ChatSettings struct {
+	ChatID              int64
+	ThanksEnabled       *bool
+	AntispamEnabled     *bool
+	CaptchaEnabled      *bool
+	SummaryImageEnabled *bool
+	VisionEnabled       *bool
+	VoiceEnabled        *bool
+	VoiceReplyEnabled   *bool
+	SpamLLMEnabled      *bool
+	SummaryLimit        *int
+	Language            *string
+	BotKeywords         []string // пусто - из конфигурации
+	UpdatedBy           int64
+}
+
+// GetChatSettings возвращает настройки чата (пустые, если чат их не менял)
+func (d *DB) GetChatSettings(chatID int64) (ChatSettings, error) {
+	settings := ChatSettings{ChatID: chatID}
+
+	var thanks, antispam, captcha, image, vision, voice, voiceReply, spamLLM, limit sql.NullInt64
+	var language, keywords sql.NullString
+	var updatedBy sql.NullInt64
+	err := d.db.QueryRow(`
+		SELECT thanks_enabled, antispam_enabled, captcha_enabled, summary_image_enabled,
+		       vision_enabled, voice_enabled, voice_reply_enabled, spam_llm_enabled, summary_limit, language, bot_keywords, updated_by
+		FROM chat_settings WHERE chat_id = ?`, chatID).
+		Scan(&thanks, &antispam, &captcha, &image, &vision, &voice, &voiceReply, &spamLLM, &limit, &language, &keywords, &updatedBy)
+	if err == sql.ErrNoRows {
+		return settings, nil
+	}
+	if err != nil {
+		return settings, fmt.Errorf("ошибка получения настроек чата %d: %v", chatID, err)
+	}
+
+	settings.ThanksEnabled = nullBool(thanks)
+	settings.AntispamEnabled = nullBool(antispam)
+	settings.CaptchaEnabled = nullBool(captcha)
+	settings.SummaryImageEnabled = nullBool(image)
+	settings.VisionEnabled = nullBool(vision)
+	settings.VoiceEnabled = nullBool(voice)
+	settings.VoiceReplyEnabled = nullBool(voiceReply)
+	settings.SpamLLMEnabled = nullBool(spamLLM)
+	if limit.Valid {
+		value := int(limit.Int64)
+		settings.SummaryLimit = &value
+	}
+	if language.Valid {
+		settings.Language = &language.String
+	}
+	if keywords.Valid && keywords.String != "" {
+		settings.BotKeywords = strings.Split(keywords.String, ",")
+	}
+	settings.UpdatedBy = updatedBy.Int64
+	return settings, nil
+}
+
+// SaveChatSettings сохраняет настройки чата целиком
+func (d *DB) SaveChatSettings(s ChatSettings) error {
+	var language, keywords any
+	if s.Language != nil {
+		language = *s.Language
+	}
+	if len(s.BotKeywords) > 0 {
+		keywords = strings.Join(s.BotKeywords, ",")
+	}
+	var limit any
+	if s.SummaryLimit != nil {
+		limit = *s.SummaryLimit
+	}
+
+	_, err := d.db.Exec(`
+		INSERT INTO chat_settings (chat_id, thanks_enabled, antispam_enabled, captcha_enabled,
+		                           summary_image_enabled, vision_enabled, voice_enabled, voice_reply_enabled,
+		                           spam_llm_enabled, summary_limit, language, bot_keywords, updated_by, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT(chat_id) DO UPDATE SET
+			thanks_enabled = excluded.thanks_enabled,
+			antispam_enabled = excluded.antispam_enabled,
+			captcha_enabled = excluded.captcha_enabled,
+			summary_image_enabled = excluded.summary_image_enabled,
+			vision_enabled = excluded.vision_enabled,
+			voice_enabled = excluded.voice_enabled,
+			voice_reply_enabled = excluded.voice_reply_enabled,
+			spam_llm_enabled = excluded.spam_llm_enabled,
+			summary_limit = excluded.summary_limit,
+			language = excluded.language,
+			bot_keywords = excluded.bot_keywords,
+			updated_by = excluded.updated_by,
+			updated_at = CURRENT_TIMESTAMP`,
+		s.ChatID, boolParam(s.ThanksEnabled), boolParam(s.AntispamEnabled), boolParam(s.CaptchaEnabled),
+		boolParam(s.SummaryImageEnabled), boolParam(s.VisionEnabled),
+		boolParam(s.VoiceEnabled), boolParam(s.VoiceReplyEnabled), boolParam(s.SpamLLMEnabled), limit, language, keywords, s.UpdatedBy,
+	)
+	if err != nil {
+		return fmt.Errorf("ошибка сохранения настроек чата %d: %v", s.ChatID, err)
+	}
+	return nil
+}
+
+// DeleteChatSettings сбрасывает настройки чата к значениям конфигурации
+func (d *DB) DeleteChatSettings(chatID int64) error {
+	if _, err := d.db.Exec("DELETE FROM chat_settings WHERE chat_id = ?", chatID); err != nil {
+		return fmt.Errorf("ошибка удаления настроек чата %d: %v", chatID, err)
+	}
+	return nil
+}
+
+func nullBool(v sql.NullInt64) *bool {
+	if !v.Valid {
+		return nil
+	}
+	value := v.Int64 != 0
+	return &value
+}
+
+func boolParam(v *bool) any {
+	if v == nil {
+		return nil
+	}
+	if *v {
+		return 1
+	}
+	return 0
+}
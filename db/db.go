@@ -1,15 +1,18 @@
 package db
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"log"
+	"strings"
 	"time"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 )
 
 const CHECK_HOURS = -24
+const ALL_THREADS = -1 // GetMessagesRange по всем темам форума
 
 type DB struct {
 	db                   *sql.DB
@@ -18,8 +21,15 @@ type DB struct {
 }
 
 func NewDB(path string, historyDays, contextRetentionDays int) (*DB, error) {
+	// busy_timeout: чаты обрабатываются параллельно, запись ждет блокировку вместо SQLITE_BUSY
+	dsn := path
+	if strings.Contains(dsn, "?") {
+		dsn += "&_pragma=busy_timeout(5000)"
+	} else {
+		dsn += "?_pragma=busy_timeout(5000)"
+	}
 	//sqlDB, err := sql.Open("sqlite3", path)
-	sqlDB, err := sql.Open("sqlite", path)
+	sqlDB, err := sql.Open("sqlite", dsn)
 	if err != nil {
 		return nil, fmt.Errorf("ошибка открытия базы данных: %v", err)
 	}
@@ -54,6 +64,9 @@ type DBMessage struct {
 	Text          string
 	Timestamp     int64
 	ChatTitle     string
+	MessageID     int // Telegram message_id, 0 у сообщений до миграции
+	ReplyToID     int // на какое сообщение ответ, 0 - не ответ
+	ThreadID      int // тема форума, 0 - общий чат
 }
 
 type BillingRecord struct {
@@ -67,16 +80,6 @@ type BillingRecord struct {
 	Cost             float64
 }
 
-func (d *DB) LogIncident(chatID int64, userID int64, text string, timestamp int64, reason string) error {
-	_, err := d.db.Exec(
-		`INSERT INTO mod_spam_incidents
-		(chat_id, user_id, message_text, reason, created_at)
-		VALUES (?, ?, ?, ?, ?)`,
-		chatID, userID, text, time.Unix(timestamp, 0).Format(time.RFC3339), reason,
-	)
-	return err
-}
-
 func (d *DB) IsNewUserInChat(chatID, userID int64) (bool, error) {
 	query := `
 		SELECT COUNT(*) FROM messages 
@@ -91,6 +94,16 @@ func (d *DB) IsNewUserInChat(chatID, userID int64) (bool, error) {
 	return messageCount < 5, nil
 }
 
+// CountUserMessages сколько сообщений пользователя сохранено в чате
+func (d *DB) CountUserMessages(chatID, userID int64) (int, error) {
+	var count int
+	err := d.db.QueryRow("SELECT COUNT(*) FROM messages WHERE chat_id = ? AND user_id = ?", chatID, userID).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("ошибка подсчета сообщений пользователя: %v", err)
+	}
+	return count, nil
+}
+
 // SaveChat сохраняет информацию о чате в БД
 func (d *DB) SaveChat(chat *tgbotapi.Chat) error {
 	if chat == nil {
@@ -136,12 +149,47 @@ func (d *DB) SaveUser(message *tgbotapi.Message) error {
 	return nil
 }
 
-// SaveMessage сохраняет сообщение в БД
-func (d *DB) SaveMessage(chatID, userID int64, text string, timestamp int64) error {
+// DeleteMessage удаляет сообщение чата из истории вместе с вектором для /search
+// (индекс messages_fts обновляется триггером)
+func (d *DB) DeleteMessage(chatID int64, messageID int) error {
+	if _, err := d.db.Exec(`
+		DELETE FROM message_embeddings
+		WHERE message_id IN (SELECT id FROM messages WHERE chat_id = ? AND message_id = ?)`,
+		chatID, messageID); err != nil {
+		return fmt.Errorf("ошибка удаления эмбеддинга сообщения: %v", err)
+	}
+	if _, err := d.db.Exec("DELETE FROM messages WHERE chat_id = ? AND message_id = ?", chatID, messageID); err != nil {
+		return fmt.Errorf("ошибка удаления сообщения: %v", err)
+	}
+	return nil
+}
+
+// UpdateMessageText заменяет текст сохраненного сообщения чата, вектор для /search
+// удаляется, чтобы индексатор пересчитал его. false - такого сообщения нет.
+func (d *DB) UpdateMessageText(chatID int64, messageID int, text string) (bool, error) {
+	if _, err := d.db.Exec(`
+		DELETE FROM message_embeddings
+		WHERE message_id IN (SELECT id FROM messages WHERE chat_id = ? AND message_id = ?)`,
+		chatID, messageID); err != nil {
+		return false, fmt.Errorf("ошибка удаления эмбеддинга сообщения: %v", err)
+	}
+	result, err := d.db.Exec("UPDATE messages SET text = ? WHERE chat_id = ? AND message_id = ?", text, chatID, messageID)
+	if err != nil {
+		return false, fmt.Errorf("ошибка обновления сообщения: %v", err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("ошибка обновления сообщения: %v", err)
+	}
+	return rows > 0, nil
+}
+
+// SaveMessage сохраняет сообщение в БД, replyToID и threadID 0 - не ответ и не в теме форума
+func (d *DB) SaveMessage(chatID, userID int64, messageID, replyToID, threadID int, text string, timestamp int64) error {
 	_, err := d.db.Exec(`
-		INSERT INTO messages (chat_id, user_id, text, timestamp) 
-		VALUES (?, ?, ?, ?)`,
-		chatID, userID, text, timestamp)
+		INSERT INTO messages (chat_id, user_id, message_id, reply_to_message_id, thread_id, text, timestamp) 
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		chatID, userID, messageID, nullInt(replyToID), nullInt(threadID), text, timestamp)
 
 	return err
 }
@@ -171,32 +219,128 @@ func (d *DB) GetUserByUsername(username string) (*tgbotapi.User, error) {
 	return &user, nil
 }
 
-// GetRecentMessages получает сообщения за последние [limit] часов
+// GetRecentMessages получает последние [limit] сообщений за CHECK_HOURS часов в хронологическом порядке
 func (d *DB) GetRecentMessages(chatID int64, limit int) ([]DBMessage, error) {
-	hoursAgo := time.Now().Add(CHECK_HOURS * time.Hour).Unix()
+	now := time.Now()
+	return d.GetMessagesRange(chatID, 0, now.Add(CHECK_HOURS*time.Hour).Unix(), now.Unix(), limit)
+}
 
-	if limit == 0 {
+// GetMessagesRange возвращает последние [limit] сообщений чата с from по to (unix, включительно)
+// в хронологическом порядке. limit <= 0 - без ограничения. threadID - тема форума, 0 - общая тема
+// (General) или чат без тем, ALL_THREADS - все сообщения чата.
+func (d *DB) GetMessagesRange(chatID int64, threadID int, from, to int64, limit int) ([]DBMessage, error) {
+	if limit <= 0 {
 		limit = -1
 	}
 
 	query := `
-		SELECT m.id, m.chat_id, m.user_id, u.username, u.first_name, u.last_name, m.text, m.timestamp, 
-		       c.title as chat_title
-		FROM messages m
-		LEFT JOIN users u ON m.user_id = u.id
-		LEFT JOIN chats c ON m.chat_id = c.id
-		WHERE m.timestamp >= ? 
-		AND m.chat_id = ?
-		ORDER BY m.timestamp desc
-		LIMIT ?
+		SELECT * FROM (
+			SELECT m.id, m.chat_id, m.user_id, u.username, u.first_name, u.last_name, m.text, m.timestamp,
+			       c.title as chat_title, COALESCE(m.message_id, 0), COALESCE(m.reply_to_message_id, 0),
+			       COALESCE(m.thread_id, 0) as thread_id
+			FROM messages m
+			LEFT JOIN users u ON m.user_id = u.id
+			LEFT JOIN chats c ON m.chat_id = c.id
+			WHERE m.chat_id = ?
+			AND (? = -1 OR COALESCE(m.thread_id, 0) = ?)
+			AND m.timestamp BETWEEN ? AND ?
+			ORDER BY m.timestamp DESC, m.id DESC
+			LIMIT ?
+		) ORDER BY timestamp ASC, id ASC
 	`
 
-	rows, err := d.db.Query(query, hoursAgo, chatID, limit)
+	rows, err := d.db.Query(query, chatID, threadID, threadID, from, to, limit)
 	if err != nil {
 		return nil, fmt.Errorf("ошибка запроса сообщений: %v", err)
 	}
 	defer rows.Close()
 
+	return scanMessages(rows)
+}
+
+// SearchMessagesByWords возвращает до limit сообщений чата, в которых есть слово, начинающееся
+// с одного из words. Ищет по messages_fts: в отличие от LIKE индекс не различает регистр
+// и для кириллицы. Лучшие совпадения (bm25: больше редких слов) первыми, при равенстве - новые.
+func (d *DB) SearchMessagesByWords(chatID int64, words []string, limit int) ([]DBMessage, error) {
+	var terms []string
+	for _, word := range words {
+		if term := ftsQuery(word); term != "" {
+			terms = append(terms, term)
+		}
+	}
+	if len(terms) == 0 {
+		return nil, nil
+	}
+
+	rows, err := d.db.Query(`
+		SELECT m.id, m.chat_id, m.user_id, COALESCE(u.username, ''), COALESCE(u.first_name, ''), COALESCE(u.last_name, ''),
+		       m.text, m.timestamp, COALESCE(c.title, ''), COALESCE(m.message_id, 0), COALESCE(m.reply_to_message_id, 0),
+		       COALESCE(m.thread_id, 0)
+		FROM messages_fts f
+		JOIN messages m ON m.id = f.rowid
+		LEFT JOIN users u ON m.user_id = u.id
+		LEFT JOIN chats c ON m.chat_id = c.id
+		WHERE messages_fts MATCH ? AND m.chat_id = ?
+		ORDER BY bm25(messages_fts), m.timestamp DESC
+		LIMIT ?`, strings.Join(terms, " OR "), chatID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка поиска сообщений: %v", err)
+	}
+	defer rows.Close()
+
+	return scanMessages(rows)
+}
+
+// GetMessageTimestamp возвращает время сообщения чата по его Telegram message_id
+func (d *DB) GetMessageTimestamp(chatID int64, messageID int) (int64, error) {
+	var timestamp int64
+	err := d.db.QueryRow(
+		"SELECT timestamp FROM messages WHERE chat_id = ? AND message_id = ?",
+		chatID, messageID,
+	).Scan(&timestamp)
+	if err == sql.ErrNoRows {
+		return 0, fmt.Errorf("сообщение %d не найдено в истории", messageID)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("ошибка поиска сообщения %d: %v", messageID, err)
+	}
+	return timestamp, nil
+}
+
+// GetMessage возвращает сохраненное сообщение чата по Telegram message_id, nil - такого нет
+func (d *DB) GetMessage(chatID int64, messageID int) (*DBMessage, error) {
+	rows, err := d.db.Query(`
+		SELECT m.id, m.chat_id, m.user_id, COALESCE(u.username, ''), COALESCE(u.first_name, ''), COALESCE(u.last_name, ''),
+		       m.text, m.timestamp, COALESCE(c.title, ''), COALESCE(m.message_id, 0), COALESCE(m.reply_to_message_id, 0),
+		       COALESCE(m.thread_id, 0)
+		FROM messages m
+		LEFT JOIN users u ON m.user_id = u.id
+		LEFT JOIN chats c ON m.chat_id = c.id
+		WHERE m.chat_id = ? AND m.message_id = ?
+		ORDER BY m.id DESC
+		LIMIT 1`, chatID, messageID)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения сообщения %d: %v", messageID, err)
+	}
+	defer rows.Close()
+
+	messages, err := scanMessages(rows)
+	if err != nil || len(messages) == 0 {
+		return nil, err
+	}
+	return &messages[0], nil
+}
+
+// nullInt 0 сохраняется как NULL
+func nullInt(v int) any {
+	if v == 0 {
+		return nil
+	}
+	return v
+}
+
+// scanMessages читает строки запросов сообщений с пользователем и чатом
+func scanMessages(rows *sql.Rows) ([]DBMessage, error) {
 	var messages []DBMessage
 	for rows.Next() {
 		var msg DBMessage
@@ -210,6 +354,9 @@ func (d *DB) GetRecentMessages(chatID int64, limit int) ([]DBMessage, error) {
 			&msg.Text,
 			&msg.Timestamp,
 			&msg.ChatTitle,
+			&msg.MessageID,
+			&msg.ReplyToID,
+			&msg.ThreadID,
 		)
 		if err != nil {
 			return nil, fmt.Errorf("ошибка чтения сообщения: %v", err)
@@ -217,7 +364,7 @@ func (d *DB) GetRecentMessages(chatID int64, limit int) ([]DBMessage, error) {
 		messages = append(messages, msg)
 	}
 
-	if err = rows.Err(); err != nil {
+	if err := rows.Err(); err != nil {
 		return nil, fmt.Errorf("ошибка обработки результатов: %v", err)
 	}
 
@@ -422,10 +569,17 @@ func (d *DB) GetTopUsersByTokenUsage(limit int, days int) ([]struct {
 	return result, nil
 }
 
-// CleanupOldContext удаляет старый контекст общения
-func (d *DB) CleanupOldContext() {
+// CleanupOldContext удаляет старый контекст общения, пока не отменен ctx
+func (d *DB) CleanupOldContext(ctx context.Context) {
+	ticker := time.NewTicker(12 * time.Hour) // Проверяем каждые 12 часов
+	defer ticker.Stop()
 	for {
-		time.Sleep(12 * time.Hour) // Проверяем каждые 12 часов
+		select {
+		case <-ctx.Done():
+			log.Printf("Очистка контекста остановлена")
+			return
+		case <-ticker.C:
+		}
 		// Удаляем контекст старше 7 дней (или другого значения из конфига)
 		threshold := time.Now().Add(-time.Duration(d.ContextRetentionDays) * 24 * time.Hour).Unix()
 		_, err := d.db.Exec("DELETE FROM chat_context WHERE timestamp < ?", threshold)
@@ -435,10 +589,17 @@ func (d *DB) CleanupOldContext() {
 	}
 }
 
-// DeleteOldMessages удаляет сообщения старше указанного количества дней
-func (d *DB) DeleteOldMessages() error {
+// DeleteOldMessages удаляет сообщения старше указанного количества дней, пока не отменен ctx
+func (d *DB) DeleteOldMessages(ctx context.Context) {
+	ticker := time.NewTicker(12 * time.Hour) // Проверяем каждые 12 часов
+	defer ticker.Stop()
 	for {
-		time.Sleep(12 * time.Hour) // Проверяем каждые 12 часов
+		select {
+		case <-ctx.Done():
+			log.Printf("Удаление старых сообщений остановлено")
+			return
+		case <-ticker.C:
+		}
 		threshold := time.Now().Add(-time.Duration(d.HistoryDays) * 24 * time.Hour).Unix()
 		_, err := d.db.Exec(`
 			DELETE FROM messages 
@@ -449,9 +610,15 @@ func (d *DB) DeleteOldMessages() error {
 			log.Printf("ошибка удаления старых сообщений: %v", err)
 		}
 
+		// Векторы удаленных сообщений для /search
+		if _, err := d.db.Exec(`
+			DELETE FROM message_embeddings
+			WHERE message_id NOT IN (SELECT id FROM messages)`); err != nil {
+			log.Printf("ошибка удаления эмбеддингов старых сообщений: %v", err)
+		}
+
 		log.Printf("Удалены сообщения старше %d дней", d.HistoryDays)
 	}
-
 }
 
 // GetUserAIInfo получает информацию о настройках AI пользователя
@@ -0,0 +1,102 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// ChatDigest расписание автоматической сводки чата
+type ChatDigest struct {
+	ChatID      int64
+	Enabled     bool
+	Schedule    string // "daily", "weekly" или "" (только по количеству сообщений)
+	Weekday     int    // день недели для weekly, 0 - воскресенье
+	AtTime      string // местное время чата "15:04"
+	MinMessages int    // сводка после N новых сообщений, 0 - не используется
+	LastRun     int64  // unix время последней сводки
+	UpdatedBy   int64
+}
+
+// GetDigest возвращает расписание чата, nil если оно не настроено
+func (d *DB) GetDigest(chatID int64) (*ChatDigest, error) {
+	var digest ChatDigest
+	var updatedBy sql.NullInt64
+	err := d.db.QueryRow(`
+		SELECT chat_id, enabled, schedule, weekday, at_time, min_messages, last_run, updated_by
+		FROM chat_digests WHERE chat_id = ?`, chatID).
+		Scan(&digest.ChatID, &digest.Enabled, &digest.Schedule, &digest.Weekday, &digest.AtTime,
+			&digest.MinMessages, &digest.LastRun, &updatedBy)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения дайджеста чата %d: %v", chatID, err)
+	}
+	digest.UpdatedBy = updatedBy.Int64
+	return &digest, nil
+}
+
+// GetEnabledDigests возвращает включенные расписания всех чатов
+func (d *DB) GetEnabledDigests() ([]ChatDigest, error) {
+	rows, err := d.db.Query(`
+		SELECT chat_id, enabled, schedule, weekday, at_time, min_messages, last_run, updated_by
+		FROM chat_digests WHERE enabled = 1`)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка запроса дайджестов: %v", err)
+	}
+	defer rows.Close()
+
+	var digests []ChatDigest
+	for rows.Next() {
+		var digest ChatDigest
+		var updatedBy sql.NullInt64
+		if err := rows.Scan(&digest.ChatID, &digest.Enabled, &digest.Schedule, &digest.Weekday, &digest.AtTime,
+			&digest.MinMessages, &digest.LastRun, &updatedBy); err != nil {
+			return nil, fmt.Errorf("ошибка чтения дайджеста: %v", err)
+		}
+		digest.UpdatedBy = updatedBy.Int64
+		digests = append(digests, digest)
+	}
+	return digests, rows.Err()
+}
+
+// SaveDigest создает или заменяет расписание чата
+func (d *DB) SaveDigest(digest ChatDigest) error {
+	_, err := d.db.Exec(`
+		INSERT INTO chat_digests (chat_id, enabled, schedule, weekday, at_time, min_messages, last_run, updated_by, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT(chat_id) DO UPDATE SET
+			enabled = excluded.enabled,
+			schedule = excluded.schedule,
+			weekday = excluded.weekday,
+			at_time = excluded.at_time,
+			min_messages = excluded.min_messages,
+			last_run = excluded.last_run,
+			updated_by = excluded.updated_by,
+			updated_at = CURRENT_TIMESTAMP`,
+		digest.ChatID, digest.Enabled, digest.Schedule, digest.Weekday, digest.AtTime,
+		digest.MinMessages, digest.LastRun, digest.UpdatedBy,
+	)
+	if err != nil {
+		return fmt.Errorf("ошибка сохранения дайджеста чата %d: %v", digest.ChatID, err)
+	}
+	return nil
+}
+
+// SetDigestLastRun запоминает время последней сводки
+func (d *DB) SetDigestLastRun(chatID, timestamp int64) error {
+	if _, err := d.db.Exec("UPDATE chat_digests SET last_run = ? WHERE chat_id = ?", timestamp, chatID); err != nil {
+		return fmt.Errorf("ошибка обновления дайджеста чата %d: %v", chatID, err)
+	}
+	return nil
+}
+
+// CountMessagesSince количество сообщений чата после since (unix)
+func (d *DB) CountMessagesSince(chatID, since int64) (int, error) {
+	var count int
+	err := d.db.QueryRow("SELECT COUNT(*) FROM messages WHERE chat_id = ? AND timestamp > ?", chatID, since).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("ошибка подсчета сообщений: %v", err)
+	}
+	return count, nil
+}
@@ -0,0 +1,141 @@
+package db
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// MessageEmbedding вектор сообщения для семантического поиска
+type MessageEmbedding struct {
+	MessageRowID int // messages.id
+	ChatID       int64
+	Vector       []float32 // пустой - модель отклонила текст, сообщение не индексируется повторно и не ищется
+}
+
+// GetMessagesToEmbed возвращает сообщения без эмбеддинга модели model, сначала новые.
+// Сообщения короче minLen символов и команды боту не индексируются.
+func (d *DB) GetMessagesToEmbed(model string, minLen, limit int) ([]DBMessage, error) {
+	rows, err := d.db.Query(`
+		SELECT m.id, m.chat_id, m.text
+		FROM messages m
+		LEFT JOIN message_embeddings e ON e.message_id = m.id AND e.model = ?
+		WHERE e.message_id IS NULL AND length(m.text) >= ? AND m.text NOT LIKE '/%'
+		ORDER BY m.id DESC
+		LIMIT ?`, model, minLen, limit)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка запроса сообщений для индексации: %v", err)
+	}
+	defer rows.Close()
+
+	var messages []DBMessage
+	for rows.Next() {
+		var msg DBMessage
+		if err := rows.Scan(&msg.ID, &msg.ChatID, &msg.Text); err != nil {
+			return nil, fmt.Errorf("ошибка чтения сообщения: %v", err)
+		}
+		messages = append(messages, msg)
+	}
+	return messages, rows.Err()
+}
+
+// SaveEmbeddings сохраняет векторы сообщений одной транзакцией, вектор другой модели заменяется
+func (d *DB) SaveEmbeddings(model string, embeddings []MessageEmbedding) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return fmt.Errorf("ошибка начала транзакции: %v", err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now().Unix()
+	for _, e := range embeddings {
+		if _, err := tx.Exec(`
+			INSERT OR REPLACE INTO message_embeddings (message_id, chat_id, model, vector, created_at)
+			VALUES (?, ?, ?, ?, ?)`,
+			e.MessageRowID, e.ChatID, model, encodeVector(e.Vector), now); err != nil {
+			return fmt.Errorf("ошибка сохранения эмбеддинга сообщения %d: %v", e.MessageRowID, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// ScanChatEmbeddings передает в fn все непустые векторы чата модели model, не загружая их в память разом
+func (d *DB) ScanChatEmbeddings(chatID int64, model string, fn func(MessageEmbedding)) error {
+	rows, err := d.db.Query(`
+		SELECT message_id, chat_id, vector FROM message_embeddings
+		WHERE chat_id = ? AND model = ? AND length(vector) > 0`, chatID, model)
+	if err != nil {
+		return fmt.Errorf("ошибка запроса эмбеддингов: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e MessageEmbedding
+		var blob []byte
+		if err := rows.Scan(&e.MessageRowID, &e.ChatID, &blob); err != nil {
+			return fmt.Errorf("ошибка чтения эмбеддинга: %v", err)
+		}
+		e.Vector = decodeVector(blob)
+		fn(e)
+	}
+	return rows.Err()
+}
+
+// GetMessagesByRowIDs возвращает сообщения с автором по messages.id в порядке ids
+func (d *DB) GetMessagesByRowIDs(ids []int) ([]DBMessage, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	args := make([]any, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	rows, err := d.db.Query(`
+		SELECT m.id, m.chat_id, m.user_id, COALESCE(u.username, ''), COALESCE(u.first_name, ''), COALESCE(u.last_name, ''),
+		       m.text, m.timestamp, COALESCE(c.title, ''), COALESCE(m.message_id, 0), COALESCE(m.reply_to_message_id, 0),
+		       COALESCE(m.thread_id, 0)
+		FROM messages m
+		LEFT JOIN users u ON m.user_id = u.id
+		LEFT JOIN chats c ON m.chat_id = c.id
+		WHERE m.id IN (?`+strings.Repeat(", ?", len(ids)-1)+`)`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка запроса сообщений: %v", err)
+	}
+	defer rows.Close()
+
+	found, err := scanMessages(rows)
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[int]DBMessage, len(found))
+	for _, msg := range found {
+		byID[msg.ID] = msg
+	}
+	messages := make([]DBMessage, 0, len(ids))
+	for _, id := range ids {
+		if msg, ok := byID[id]; ok {
+			messages = append(messages, msg)
+		}
+	}
+	return messages, nil
+}
+
+// encodeVector float32 little-endian
+func encodeVector(v []float32) []byte {
+	buf := make([]byte, 4*len(v))
+	for i, f := range v {
+		binary.LittleEndian.PutUint32(buf[4*i:], math.Float32bits(f))
+	}
+	return buf
+}
+
+func decodeVector(buf []byte) []float32 {
+	v := make([]float32, len(buf)/4)
+	for i := range v {
+		v[i] = math.Float32frombits(binary.LittleEndian.Uint32(buf[4*i:]))
+	}
+	return v
+}
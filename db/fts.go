@@ -0,0 +1,100 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// SNIPPET_START и SNIPPET_END отмечают найденные слова в Snippet
+const SNIPPET_START = "\x01"
+const SNIPPET_END = "\x02"
+
+// MessageSearch параметры полнотекстового поиска по сообщениям чата
+type MessageSearch struct {
+	ChatID   int64
+	Query    string // слова через пробел, ищутся все, по префиксу
+	Username string // только сообщения пользователя, пусто - всех
+	From     int64  // unix время, 0 - без ограничения
+	To       int64  // unix время, 0 - без ограничения
+	Limit    int
+	Offset   int
+}
+
+// FoundMessage найденное сообщение с фрагментом текста вокруг совпадений
+type FoundMessage struct {
+	DBMessage
+	Snippet string // совпадения обрамлены SNIPPET_START и SNIPPET_END
+}
+
+// ftsQuery превращает пользовательский запрос в запрос FTS5: каждое слово в кавычках
+// (чтобы операторы и знаки не ломали синтаксис) и с поиском по префиксу
+func ftsQuery(text string) string {
+	var terms []string
+	for _, word := range strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		terms = append(terms, `"`+word+`"*`)
+	}
+	return strings.Join(terms, " ")
+}
+
+// FindMessages ищет сообщения по индексу messages_fts, лучшие совпадения первыми (bm25).
+// Команды боту не ищутся.
+// Возвращает страницу результатов и общее количество найденных.
+func (d *DB) FindMessages(s MessageSearch) ([]FoundMessage, int, error) {
+	match := ftsQuery(s.Query)
+	if match == "" {
+		return nil, 0, nil
+	}
+	to := s.To
+	if to == 0 {
+		to = 1<<63 - 1
+	}
+
+	filter := `
+		FROM messages_fts f
+		JOIN messages m ON m.id = f.rowid
+		LEFT JOIN users u ON m.user_id = u.id
+		LEFT JOIN chats c ON m.chat_id = c.id
+		WHERE messages_fts MATCH ?
+		AND m.chat_id = ?
+		AND m.text NOT LIKE '/%'
+		AND (? = '' OR u.username = ? COLLATE NOCASE)
+		AND m.timestamp BETWEEN ? AND ?`
+	args := []any{match, s.ChatID, s.Username, s.Username, s.From, to}
+
+	var total int
+	if err := d.db.QueryRow("SELECT COUNT(*) "+filter, args...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("ошибка поиска сообщений: %v", err)
+	}
+	if total == 0 {
+		return nil, 0, nil
+	}
+
+	rows, err := d.db.Query(`
+		SELECT m.id, m.chat_id, m.user_id, COALESCE(u.username, ''), COALESCE(u.first_name, ''), COALESCE(u.last_name, ''),
+		       m.text, m.timestamp, COALESCE(c.title, ''), COALESCE(m.message_id, 0), COALESCE(m.reply_to_message_id, 0),
+		       COALESCE(m.thread_id, 0), snippet(messages_fts, 0, ?, ?, '…', 16)`+filter+`
+		ORDER BY bm25(messages_fts), m.timestamp DESC
+		LIMIT ? OFFSET ?`,
+		append(append([]any{SNIPPET_START, SNIPPET_END}, args...), s.Limit, s.Offset)...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("ошибка поиска сообщений: %v", err)
+	}
+	defer rows.Close()
+
+	var found []FoundMessage
+	for rows.Next() {
+		var msg FoundMessage
+		if err := rows.Scan(&msg.ID, &msg.ChatID, &msg.UserID, &msg.Username, &msg.UserFirstName, &msg.UserLastName,
+			&msg.Text, &msg.Timestamp, &msg.ChatTitle, &msg.MessageID, &msg.ReplyToID, &msg.ThreadID, &msg.Snippet); err != nil {
+			return nil, 0, fmt.Errorf("ошибка чтения сообщения: %v", err)
+		}
+		found = append(found, msg)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("ошибка обработки результатов: %v", err)
+	}
+	return found, total, nil
+}
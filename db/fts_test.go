@@ -0,0 +1,74 @@
+package db
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+func TestFtsQuery(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{text: "зимние шины", want: `"зимние"* "шины"*`},
+		{text: `"кавычки" внутри`, want: `"кавычки"* "внутри"*`},
+		{text: "шины NEAR диски", want: `"шины"* "NEAR"* "диски"*`},
+		{text: "-минус +плюс", want: `"минус"* "плюс"*`},
+		{text: "звез*да", want: `"звез"* "да"*`},
+		{text: "a OR b AND NOT c", want: `"a"* "OR"* "b"* "AND"* "NOT"* "c"*`},
+		{text: "col:value (скобки)", want: `"col"* "value"* "скобки"*`},
+		{text: `" * - ^ :`, want: ""},
+		{text: "", want: ""},
+	}
+	for _, tt := range tests {
+		if got := ftsQuery(tt.text); got != tt.want {
+			t.Errorf("ftsQuery(%q) = %q, ожидалось %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+// TestFindMessagesOperators операторы FTS5 в запросе ищутся как обычные слова и не ломают синтаксис
+func TestFindMessagesOperators(t *testing.T) {
+	const CHAT_ID = -100500
+	d, err := NewDB(filepath.Join(t.TempDir(), "test.db"), 30, 30)
+	if err != nil {
+		t.Fatalf("NewDB: %v", err)
+	}
+	t.Cleanup(func() { d.Close() })
+	if err := d.Init(); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+
+	now := time.Now().Unix()
+	for i, text := range []string{"Шины NEAR дома", "скидка -50% на шины", "звезда* \"в кавычках\"", "/find шины"} {
+		if err := d.SaveMessage(CHAT_ID, 1, i+1, 0, 0, text, now); err != nil {
+			t.Fatalf("SaveMessage: %v", err)
+		}
+	}
+
+	tests := []struct {
+		query string
+		want  int
+	}{
+		{query: "шины", want: 2}, // команда боту не ищется
+		{query: "ШИНЫ near", want: 1},
+		{query: "-50%", want: 1},
+		{query: `"кавычках`, want: 1},
+		{query: "звезда*", want: 1},
+		{query: "NOT", want: 0},
+		{query: `" - *`, want: 0},
+	}
+	for _, tt := range tests {
+		found, total, err := d.FindMessages(MessageSearch{ChatID: CHAT_ID, Query: tt.query, Limit: 10})
+		if err != nil {
+			t.Errorf("FindMessages(%q): %v", tt.query, err)
+			continue
+		}
+		if total != tt.want || len(found) != tt.want {
+			t.Errorf("FindMessages(%q): найдено %d (всего %d), ожидалось %d", tt.query, len(found), total, tt.want)
+		}
+	}
+}
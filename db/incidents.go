@@ -0,0 +1,242 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Решения администраторов по инцидентам
+const (
+	INCIDENT_CONFIRMED      = "confirmed"      // спам подтвержден
+	INCIDENT_FALSE_POSITIVE = "false_positive" // ложное срабатывание, меры отменены
+)
+
+// INCIDENT_SOURCE_RATE источник инцидентов за слишком частые сообщения: их нарушения считаются отдельно от спама
+const INCIDENT_SOURCE_RATE = "rate"
+
+// SpamIncident решение антиспама по одному сообщению
+type SpamIncident struct {
+	ID          int64
+	ChatID      int64
+	UserID      int64
+	MessageID   int
+	Text        string
+	Reason      string
+	Source      string   // что сработало: rules, llm, flood, rate
+	Categories  []string // категории правил, вердикт модели или вид флуда - для оценки качества
+	Strike      int      // номер нарушения пользователя в чате
+	Action      string   // примененная мера: warn, delete, mute, ban
+	ActionError string   // пусто - мера применена
+	DeleteError string   // пусто - сообщение удалено (для мер кроме warn)
+	Timestamp   int64
+	Review      string // пусто - администраторы не проверяли
+	ReviewedBy  int64
+	ReviewedAt  int64
+
+	// Из таблиц users и chats, только при чтении
+	Username  string
+	FirstName string
+	LastName  string
+	ChatTitle string
+}
+
+// IncidentStats итоги проверки инцидентов по источнику и категории
+type IncidentStats struct {
+	Source        string
+	Category      string
+	Total         int
+	Confirmed     int
+	FalsePositive int
+}
+
+const incidentColumns = `
+	i.id, i.chat_id, i.user_id, COALESCE(i.message_id, 0), COALESCE(i.message_text, ''), COALESCE(i.reason, ''),
+	COALESCE(i.source, ''), COALESCE(i.categories, ''), COALESCE(i.strike, 0), COALESCE(i.action, ''),
+	COALESCE(i.action_error, ''), COALESCE(i.delete_error, ''), COALESCE(i.timestamp, 0), COALESCE(i.review, ''), COALESCE(i.reviewed_by, 0),
+	COALESCE(i.reviewed_at, 0), COALESCE(u.username, ''), COALESCE(u.first_name, ''), COALESCE(u.last_name, ''),
+	COALESCE(c.title, '')
+	FROM mod_spam_incidents i
+	LEFT JOIN users u ON u.id = i.user_id
+	LEFT JOIN chats c ON c.id = i.chat_id`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanIncident(row rowScanner) (SpamIncident, error) {
+	var i SpamIncident
+	var categories string
+	err := row.Scan(&i.ID, &i.ChatID, &i.UserID, &i.MessageID, &i.Text, &i.Reason,
+		&i.Source, &categories, &i.Strike, &i.Action,
+		&i.ActionError, &i.DeleteError, &i.Timestamp, &i.Review, &i.ReviewedBy,
+		&i.ReviewedAt, &i.Username, &i.FirstName, &i.LastName,
+		&i.ChatTitle)
+	if err != nil {
+		return i, err
+	}
+	if categories != "" {
+		json.Unmarshal([]byte(categories), &i.Categories)
+	}
+	return i, nil
+}
+
+// MessageDeleted удалено ли сообщение инцидента антиспамом
+func (i SpamIncident) MessageDeleted() bool {
+	return i.Action != "warn" && i.DeleteError == ""
+}
+
+// SaveIncident сохраняет инцидент и возвращает его ID
+func (d *DB) SaveIncident(i SpamIncident) (int64, error) {
+	var actionError, deleteError any
+	if i.ActionError != "" {
+		actionError = i.ActionError
+	}
+	if i.DeleteError != "" {
+		deleteError = i.DeleteError
+	}
+	categories, err := json.Marshal(i.Categories)
+	if err != nil {
+		return 0, fmt.Errorf("ошибка сохранения инцидента: %v", err)
+	}
+	result, err := d.db.Exec(`
+		INSERT INTO mod_spam_incidents
+			(chat_id, user_id, message_id, message_text, reason, source, categories, strike, action, action_error, delete_error, timestamp, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		i.ChatID, i.UserID, i.MessageID, i.Text, i.Reason, i.Source, string(categories), i.Strike, i.Action, actionError, deleteError,
+		i.Timestamp, time.Unix(i.Timestamp, 0).Format(time.RFC3339),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("ошибка сохранения инцидента: %v", err)
+	}
+	return result.LastInsertId()
+}
+
+// GetIncident возвращает инцидент по ID, nil - такого нет
+func (d *DB) GetIncident(id int64) (*SpamIncident, error) {
+	incident, err := scanIncident(d.db.QueryRow("SELECT "+incidentColumns+" WHERE i.id = ?", id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения инцидента %d: %v", id, err)
+	}
+	return &incident, nil
+}
+
+// GetIncidents возвращает инциденты чата, новые первыми, и их общее количество
+func (d *DB) GetIncidents(chatID int64, limit, offset int) ([]SpamIncident, int, error) {
+	var total int
+	if err := d.db.QueryRow("SELECT COUNT(*) FROM mod_spam_incidents WHERE chat_id = ?", chatID).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("ошибка подсчета инцидентов: %v", err)
+	}
+
+	rows, err := d.db.Query("SELECT "+incidentColumns+`
+		WHERE i.chat_id = ?
+		ORDER BY i.id DESC
+		LIMIT ? OFFSET ?`, chatID, limit, offset)
+	if err != nil {
+		return nil, 0, fmt.Errorf("ошибка получения инцидентов: %v", err)
+	}
+	defer rows.Close()
+
+	var incidents []SpamIncident
+	for rows.Next() {
+		incident, err := scanIncident(rows)
+		if err != nil {
+			return nil, 0, fmt.Errorf("ошибка чтения инцидента: %v", err)
+		}
+		incidents = append(incidents, incident)
+	}
+	return incidents, total, rows.Err()
+}
+
+// ReviewIncident сохраняет решение администратора.
+// false - инцидент уже проверен (или его нет), повторное решение не записывается.
+func (d *DB) ReviewIncident(id int64, review string, reviewedBy int64) (bool, error) {
+	result, err := d.db.Exec(`
+		UPDATE mod_spam_incidents SET review = ?, reviewed_by = ?, reviewed_at = ?
+		WHERE id = ? AND review IS NULL`,
+		review, reviewedBy, time.Now().Unix(), id)
+	if err != nil {
+		return false, fmt.Errorf("ошибка сохранения решения по инциденту %d: %v", id, err)
+	}
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
+
+// CountUserIncidents сколько инцидентов у пользователя в чате начиная с since (unix):
+// с rate - только за частые сообщения, без него - все остальные. Ложные срабатывания не считаются.
+func (d *DB) CountUserIncidents(chatID, userID int64, since int64, rate bool) (int, error) {
+	var count int
+	err := d.db.QueryRow(`
+		SELECT COUNT(*) FROM mod_spam_incidents
+		WHERE chat_id = ? AND user_id = ? AND timestamp >= ?
+		  AND (COALESCE(source, '') = ?) = ?
+		  AND (review IS NULL OR review != ?)`,
+		chatID, userID, since, INCIDENT_SOURCE_RATE, rate, INCIDENT_FALSE_POSITIVE).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("ошибка подсчета инцидентов: %v", err)
+	}
+	return count, nil
+}
+
+// GetIncidentStats итоги проверки инцидентов чата с since (unix) по источникам и категориям,
+// самые частые первыми. Инцидент с несколькими категориями учитывается в каждой.
+func (d *DB) GetIncidentStats(chatID int64, since int64) ([]IncidentStats, error) {
+	rows, err := d.db.Query(`
+		SELECT COALESCE(source, ''), COALESCE(categories, ''), COALESCE(review, '')
+		FROM mod_spam_incidents WHERE chat_id = ? AND timestamp >= ?`, chatID, since)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения статистики инцидентов: %v", err)
+	}
+	defer rows.Close()
+
+	type statsKey struct{ source, category string }
+	stats := make(map[statsKey]*IncidentStats)
+	for rows.Next() {
+		var source, categoriesJSON, review string
+		if err := rows.Scan(&source, &categoriesJSON, &review); err != nil {
+			return nil, fmt.Errorf("ошибка чтения статистики инцидентов: %v", err)
+		}
+		var categories []string
+		if categoriesJSON != "" {
+			json.Unmarshal([]byte(categoriesJSON), &categories)
+		}
+		if len(categories) == 0 {
+			categories = []string{""}
+		}
+		for _, category := range categories {
+			key := statsKey{source, category}
+			s, ok := stats[key]
+			if !ok {
+				s = &IncidentStats{Source: source, Category: category}
+				stats[key] = s
+			}
+			s.Total++
+			switch review {
+			case INCIDENT_CONFIRMED:
+				s.Confirmed++
+			case INCIDENT_FALSE_POSITIVE:
+				s.FalsePositive++
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("ошибка чтения статистики инцидентов: %v", err)
+	}
+
+	result := make([]IncidentStats, 0, len(stats))
+	for _, s := range stats {
+		result = append(result, *s)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Total != result[j].Total {
+			return result[i].Total > result[j].Total
+		}
+		return result[i].Source+result[i].Category < result[j].Source+result[j].Category
+	})
+	return result, nil
+}
@@ -180,6 +180,194 @@ func RunMigrations(db *sql.DB) error {
         CREATE INDEX IF NOT EXISTS idx_captchas_active ON captchas(chat_id, user_id, answered_at);
     `,
 		},
+		// настройки чатов (/settings), NULL - значение из конфигурации
+		{
+			name: "add_chat_settings_table",
+			sql: `
+                CREATE TABLE IF NOT EXISTS chat_settings (
+                    chat_id INTEGER PRIMARY KEY,
+                    thanks_enabled INTEGER NULL,
+                    antispam_enabled INTEGER NULL,
+                    captcha_enabled INTEGER NULL,
+                    summary_image_enabled INTEGER NULL,
+                    summary_limit INTEGER NULL,
+                    language TEXT NULL,
+                    bot_keywords TEXT NULL,
+                    updated_by INTEGER,
+                    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+                    FOREIGN KEY (chat_id) REFERENCES chats(id)
+                );
+            `,
+		},
+		// автоматические дайджесты (/digest)
+		{
+			name: "add_chat_digests_table",
+			sql: `
+                CREATE TABLE IF NOT EXISTS chat_digests (
+                    chat_id INTEGER PRIMARY KEY,
+                    enabled INTEGER NOT NULL DEFAULT 1,
+                    schedule TEXT NOT NULL DEFAULT '',
+                    weekday INTEGER NOT NULL DEFAULT 0,
+                    at_time TEXT NOT NULL DEFAULT '',
+                    min_messages INTEGER NOT NULL DEFAULT 0,
+                    last_run INTEGER NOT NULL DEFAULT 0,
+                    updated_by INTEGER,
+                    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+                    FOREIGN KEY (chat_id) REFERENCES chats(id)
+                );
+            `,
+		},
+		// Telegram message_id для ссылок на сообщения (/summary since <ссылка>)
+		{
+			name: "add_messages_message_id",
+			sql: `
+                ALTER TABLE messages ADD COLUMN message_id INTEGER;
+                CREATE INDEX IF NOT EXISTS idx_messages_chat_message ON messages(chat_id, message_id);
+                CREATE INDEX IF NOT EXISTS idx_messages_chat_timestamp ON messages(chat_id, timestamp);
+            `,
+		},
+		// Ветки ответов и темы форума для сводок
+		{
+			name: "add_messages_reply_thread",
+			sql: `
+                ALTER TABLE messages ADD COLUMN reply_to_message_id INTEGER;
+                ALTER TABLE messages ADD COLUMN thread_id INTEGER;
+                CREATE INDEX IF NOT EXISTS idx_messages_chat_thread_timestamp ON messages(chat_id, thread_id, timestamp);
+            `,
+		},
+		// Векторы сообщений для /search, message_id - messages.id
+		{
+			name: "add_message_embeddings_table",
+			sql: `
+                CREATE TABLE IF NOT EXISTS message_embeddings (
+                    message_id INTEGER PRIMARY KEY,
+                    chat_id INTEGER NOT NULL,
+                    model TEXT NOT NULL,
+                    vector BLOB NOT NULL,
+                    created_at INTEGER NOT NULL,
+                    FOREIGN KEY (message_id) REFERENCES messages(id)
+                );
+                CREATE INDEX IF NOT EXISTS idx_message_embeddings_chat_model ON message_embeddings(chat_id, model);
+            `,
+		},
+		// Полнотекстовый индекс сообщений для поиска по словам, синхронизируется триггерами
+		{
+			name: "add_messages_fts",
+			sql: `
+                CREATE VIRTUAL TABLE IF NOT EXISTS messages_fts USING fts5(
+                    text,
+                    content='messages',
+                    content_rowid='id',
+                    tokenize='unicode61 remove_diacritics 2'
+                );
+
+                CREATE TRIGGER IF NOT EXISTS messages_fts_insert AFTER INSERT ON messages BEGIN
+                    INSERT INTO messages_fts(rowid, text) VALUES (new.id, new.text);
+                END;
+                CREATE TRIGGER IF NOT EXISTS messages_fts_delete AFTER DELETE ON messages BEGIN
+                    INSERT INTO messages_fts(messages_fts, rowid, text) VALUES ('delete', old.id, old.text);
+                END;
+                CREATE TRIGGER IF NOT EXISTS messages_fts_update AFTER UPDATE OF text ON messages BEGIN
+                    INSERT INTO messages_fts(messages_fts, rowid, text) VALUES ('delete', old.id, old.text);
+                    INSERT INTO messages_fts(rowid, text) VALUES (new.id, new.text);
+                END;
+
+                INSERT INTO messages_fts(messages_fts) VALUES ('rebuild');
+            `,
+		},
+		// Описание фото моделью, включается в /settings
+		{
+			name: "add_chat_settings_vision",
+			sql: `
+                ALTER TABLE chat_settings ADD COLUMN vision_enabled INTEGER NULL;
+            `,
+		},
+		// Расшифровка голосовых, включается в /settings
+		{
+			name: "add_chat_settings_voice",
+			sql: `
+                ALTER TABLE chat_settings ADD COLUMN voice_enabled INTEGER NULL;
+                ALTER TABLE chat_settings ADD COLUMN voice_reply_enabled INTEGER NULL;
+            `,
+		},
+		// Инциденты антиспама: миграция выше записана под именем add_thanks_module и не выполнялась
+		{
+			name: "add_spam_incidents_table",
+			sql: `
+                CREATE TABLE IF NOT EXISTS mod_spam_incidents (
+                    id INTEGER PRIMARY KEY AUTOINCREMENT,
+                    chat_id INTEGER,
+                    user_id INTEGER,
+                    message_text TEXT,
+                    reason TEXT,
+                    created_at TIMESTAMP,
+                    FOREIGN KEY(chat_id) REFERENCES chats(id),
+                    FOREIGN KEY(user_id) REFERENCES users(id)
+                );
+                ALTER TABLE mod_spam_incidents ADD COLUMN message_id INTEGER;
+                ALTER TABLE mod_spam_incidents ADD COLUMN timestamp INTEGER;
+                ALTER TABLE mod_spam_incidents ADD COLUMN strike INTEGER;
+                ALTER TABLE mod_spam_incidents ADD COLUMN action TEXT;
+                ALTER TABLE mod_spam_incidents ADD COLUMN action_error TEXT;
+                ALTER TABLE mod_spam_incidents ADD COLUMN delete_error TEXT;
+                CREATE INDEX IF NOT EXISTS idx_spam_incidents_chat_user ON mod_spam_incidents(chat_id, user_id, timestamp);
+            `,
+		},
+		// Правила антиспама, добавленные администраторами через /spamrule
+		{
+			name: "add_spam_rules_table",
+			sql: `
+                CREATE TABLE IF NOT EXISTS mod_spam_rules (
+                    id INTEGER PRIMARY KEY AUTOINCREMENT,
+                    chat_id INTEGER NOT NULL,
+                    kind TEXT NOT NULL,
+                    pattern TEXT NOT NULL,
+                    weight REAL NOT NULL,
+                    category TEXT NOT NULL,
+                    created_by INTEGER,
+                    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+                );
+                CREATE INDEX IF NOT EXISTS idx_spam_rules_chat ON mod_spam_rules(chat_id);
+            `,
+		},
+		// Проверка сомнительных сообщений моделью, включается в /settings
+		{
+			name: "add_chat_settings_spam_llm",
+			sql: `
+                ALTER TABLE chat_settings ADD COLUMN spam_llm_enabled INTEGER NULL;
+            `,
+		},
+		// Источник решения антиспама: нарушения за частые сообщения считаются отдельно
+		{
+			name: "add_spam_incidents_source",
+			sql: `
+                ALTER TABLE mod_spam_incidents ADD COLUMN source TEXT;
+            `,
+		},
+		// Проверка инцидентов администраторами: решение и категории для оценки правил
+		{
+			name: "add_spam_incidents_review",
+			sql: `
+                ALTER TABLE mod_spam_incidents ADD COLUMN categories TEXT;
+                ALTER TABLE mod_spam_incidents ADD COLUMN review TEXT;
+                ALTER TABLE mod_spam_incidents ADD COLUMN reviewed_by INTEGER;
+                ALTER TABLE mod_spam_incidents ADD COLUMN reviewed_at INTEGER;
+                CREATE INDEX IF NOT EXISTS idx_spam_incidents_chat_time ON mod_spam_incidents(chat_id, timestamp);
+            `,
+		},
+		// Пользователи, которых антиспам не проверяет
+		{
+			name: "add_spam_whitelist_table",
+			sql: `
+                CREATE TABLE IF NOT EXISTS mod_spam_whitelist (
+                    chat_id INTEGER NOT NULL,
+                    user_id INTEGER NOT NULL,
+                    added_by INTEGER,
+                    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+                    PRIMARY KEY (chat_id, user_id)
+                );
+            `,
+		},
 	}
 
 	// Создаем таблицу для отслеживания выполненных миграций
@@ -0,0 +1,57 @@
+package db
+
+import "fmt"
+
+// SpamRule правило антиспама чата из /spamrule
+type SpamRule struct {
+	ID        int64
+	ChatID    int64
+	Kind      string
+	Pattern   string
+	Weight    float64
+	Category  string
+	CreatedBy int64
+}
+
+// AddSpamRule сохраняет правило и возвращает его ID
+func (d *DB) AddSpamRule(r SpamRule) (int64, error) {
+	result, err := d.db.Exec(`
+		INSERT INTO mod_spam_rules (chat_id, kind, pattern, weight, category, created_by)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		r.ChatID, r.Kind, r.Pattern, r.Weight, r.Category, r.CreatedBy)
+	if err != nil {
+		return 0, fmt.Errorf("ошибка сохранения правила антиспама: %v", err)
+	}
+	return result.LastInsertId()
+}
+
+// GetSpamRules возвращает правила чата в порядке добавления
+func (d *DB) GetSpamRules(chatID int64) ([]SpamRule, error) {
+	rows, err := d.db.Query(`
+		SELECT id, chat_id, kind, pattern, weight, category, COALESCE(created_by, 0)
+		FROM mod_spam_rules WHERE chat_id = ? ORDER BY id`, chatID)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения правил антиспама: %v", err)
+	}
+	defer rows.Close()
+
+	var rules []SpamRule
+	for rows.Next() {
+		var r SpamRule
+		if err := rows.Scan(&r.ID, &r.ChatID, &r.Kind, &r.Pattern, &r.Weight, &r.Category, &r.CreatedBy); err != nil {
+			return nil, fmt.Errorf("ошибка чтения правила антиспама: %v", err)
+		}
+		rules = append(rules, r)
+	}
+	return rules, rows.Err()
+}
+
+// DeleteSpamRule удаляет правило чата, false - такого правила в чате нет
+func (d *DB) DeleteSpamRule(chatID, id int64) (bool, error) {
+	result, err := d.db.Exec("DELETE FROM mod_spam_rules WHERE chat_id = ? AND id = ?", chatID, id)
+	if err != nil {
+		return false, fmt.Errorf("ошибка удаления правила антиспама: %v", err)
+	}
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
@@ -0,0 +1,67 @@
+package db
+
+import "fmt"
+
+// AddSpamWhitelist исключает пользователя из проверки антиспамом в чате
+func (d *DB) AddSpamWhitelist(chatID, userID, addedBy int64) error {
+	_, err := d.db.Exec(`
+		INSERT INTO mod_spam_whitelist (chat_id, user_id, added_by) VALUES (?, ?, ?)
+		ON CONFLICT(chat_id, user_id) DO NOTHING`,
+		chatID, userID, addedBy)
+	if err != nil {
+		return fmt.Errorf("ошибка добавления в белый список: %v", err)
+	}
+	return nil
+}
+
+// RemoveSpamWhitelist возвращает пользователя под проверку, false - его не было в списке
+func (d *DB) RemoveSpamWhitelist(chatID, userID int64) (bool, error) {
+	result, err := d.db.Exec("DELETE FROM mod_spam_whitelist WHERE chat_id = ? AND user_id = ?", chatID, userID)
+	if err != nil {
+		return false, fmt.Errorf("ошибка удаления из белого списка: %v", err)
+	}
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
+
+// IsSpamWhitelisted есть ли пользователь в белом списке чата
+func (d *DB) IsSpamWhitelisted(chatID, userID int64) (bool, error) {
+	var count int
+	err := d.db.QueryRow("SELECT COUNT(*) FROM mod_spam_whitelist WHERE chat_id = ? AND user_id = ?", chatID, userID).Scan(&count)
+	if err != nil {
+		return false, fmt.Errorf("ошибка проверки белого списка: %v", err)
+	}
+	return count > 0, nil
+}
+
+// WhitelistedUser пользователь из белого списка с именем из таблицы users
+type WhitelistedUser struct {
+	UserID    int64
+	Username  string
+	FirstName string
+	LastName  string
+}
+
+// GetSpamWhitelist белый список чата в порядке добавления
+func (d *DB) GetSpamWhitelist(chatID int64) ([]WhitelistedUser, error) {
+	rows, err := d.db.Query(`
+		SELECT w.user_id, COALESCE(u.username, ''), COALESCE(u.first_name, ''), COALESCE(u.last_name, '')
+		FROM mod_spam_whitelist w
+		LEFT JOIN users u ON u.id = w.user_id
+		WHERE w.chat_id = ?
+		ORDER BY w.created_at, w.user_id`, chatID)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения белого списка: %v", err)
+	}
+	defer rows.Close()
+
+	var users []WhitelistedUser
+	for rows.Next() {
+		var u WhitelistedUser
+		if err := rows.Scan(&u.UserID, &u.Username, &u.FirstName, &u.LastName); err != nil {
+			return nil, fmt.Errorf("ошибка чтения белого списка: %v", err)
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
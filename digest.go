@@ -0,0 +1,300 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"facilitatorbot/db"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const DIGEST_CHECK_INTERVAL = time.Minute // как часто проверять расписание дайджестов
+const DIGEST_MIN_MESSAGES = 5             // если новых сообщений меньше, дайджест по расписанию пропускается
+
+// DIGEST_USAGE подсказка по /digest
+const DIGEST_USAGE = `Использование:
+/digest on 21:00 - ежедневно в указанное время
+/digest weekly mon 10:00 - еженедельно (mon..sun или пн..вс)
+/digest every 300 - после N новых сообщений (0 - выключить)
+/digest off - выключить`
+
+// WEEKDAYS дни недели для /digest weekly
+var WEEKDAYS = map[string]time.Weekday{
+	"mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday, "thu": time.Thursday,
+	"fri": time.Friday, "sat": time.Saturday, "sun": time.Sunday,
+	"пн": time.Monday, "вт": time.Tuesday, "ср": time.Wednesday, "чт": time.Thursday,
+	"пт": time.Friday, "сб": time.Saturday, "вс": time.Sunday,
+}
+
+// parseDigestTime разбирает местное время "21:00"
+func parseDigestTime(value string) (hour, minute int, err error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, 0, fmt.Errorf("время %q должно быть в формате ЧЧ:ММ", value)
+	}
+	return t.Hour(), t.Minute(), nil
+}
+
+// nextDigestRun ближайшее время дайджеста по расписанию строго после after.
+// false, если у чата нет расписания по времени.
+func nextDigestRun(d db.ChatDigest, after time.Time, loc *time.Location) (time.Time, bool) {
+	if d.Schedule == "" {
+		return time.Time{}, false
+	}
+	hour, minute, err := parseDigestTime(d.AtTime)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	local := after.In(loc)
+	next := time.Date(local.Year(), local.Month(), local.Day(), hour, minute, 0, 0, loc)
+	for !next.After(local) || (d.Schedule == "weekly" && next.Weekday() != time.Weekday(d.Weekday)) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next, true
+}
+
+// digestPeriod за какой период собирать сообщения для дайджеста по расписанию
+func digestPeriod(d db.ChatDigest) time.Duration {
+	if d.Schedule == "weekly" {
+		return 7 * 24 * time.Hour
+	}
+	return 24 * time.Hour
+}
+
+// describeDigest описание расписания для пользователя
+func describeDigest(d *db.ChatDigest) string {
+	if d == nil || !d.Enabled {
+		return "Автоматический дайджест выключен"
+	}
+
+	var parts []string
+	switch d.Schedule {
+	case "daily":
+		parts = append(parts, "ежедневно в "+d.AtTime)
+	case "weekly":
+		parts = append(parts, fmt.Sprintf("еженедельно, %s в %s", weekdayName(time.Weekday(d.Weekday)), d.AtTime))
+	}
+	if d.MinMessages > 0 {
+		parts = append(parts, fmt.Sprintf("после %d новых сообщений", d.MinMessages))
+	}
+	if len(parts) == 0 {
+		return "Дайджест включен, но расписание не задано"
+	}
+	return "Автоматический дайджест: " + strings.Join(parts, ", ")
+}
+
+func weekdayName(day time.Weekday) string {
+	return []string{"вс", "пн", "вт", "ср", "чт", "пт", "сб"}[day]
+}
+
+// runDigestScheduler проверяет расписания дайджестов, пока не отменен ctx
+func (b *Bot) runDigestScheduler(ctx context.Context) {
+	ticker := time.NewTicker(DIGEST_CHECK_INTERVAL)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			log.Printf("Планировщик дайджестов остановлен")
+			return
+		case <-ticker.C:
+		}
+
+		digests, err := b.db.GetEnabledDigests()
+		if err != nil {
+			log.Printf("[runDigestScheduler] %v", err)
+			continue
+		}
+		for _, digest := range digests {
+			if ctx.Err() != nil {
+				return
+			}
+			b.checkDigest(digest, time.Now())
+		}
+	}
+}
+
+// checkDigest запускает дайджест чата, если подошло время или набралось сообщений
+func (b *Bot) checkDigest(d db.ChatDigest, now time.Time) {
+	if !b.isChatAllowed(d.ChatID) {
+		return
+	}
+	loc := b.chatConfig(d.ChatID).Location()
+
+	next, scheduled := nextDigestRun(d, time.Unix(d.LastRun, 0), loc)
+	timeDue := scheduled && !now.Before(next)
+
+	// По количеству считаем сообщения после последней сводки, ручной или автоматической
+	since := d.LastRun
+	if last, ok := b.lastSummary.Get(d.ChatID); ok && last.Unix() > since {
+		since = last.Unix()
+	}
+	countDue := false
+	if d.MinMessages > 0 {
+		count, err := b.db.CountMessagesSince(d.ChatID, since)
+		if err != nil {
+			log.Printf("[checkDigest] %v", err)
+			return
+		}
+		countDue = count >= d.MinMessages
+	}
+
+	switch {
+	case timeDue:
+		b.startDigest(d, now.Add(-digestPeriod(d)).Unix(), now, "🗞 Дайджест за "+map[string]string{"daily": "день", "weekly": "неделю"}[d.Schedule])
+	case countDue:
+		b.startDigest(d, since, now, fmt.Sprintf("🗞 Дайджест: %d+ новых сообщений", d.MinMessages))
+	}
+}
+
+// startDigest запоминает запуск и ставит генерацию в очередь чата: дайджест не идет
+// одновременно с другими командами чата, и при остановке бот дожидается его, как и их
+func (b *Bot) startDigest(d db.ChatDigest, since int64, now time.Time, header string) {
+	// Запоминаем запуск сразу, чтобы ошибка генерации не повторялась каждую минуту
+	// и следующая проверка не запустила тот же дайджест еще раз
+	if err := b.db.SetDigestLastRun(d.ChatID, now.Unix()); err != nil {
+		log.Printf("[startDigest] %v", err)
+		return
+	}
+
+	err := b.dispatcher.Run(d.ChatID, func() {
+		b.runDigest(d, since, now, header)
+	})
+	if err != nil {
+		log.Printf("[startDigest] Дайджест чата %d не запущен: %v", d.ChatID, err)
+		// Следующая проверка попробует снова
+		if err := b.db.SetDigestLastRun(d.ChatID, d.LastRun); err != nil {
+			log.Printf("[startDigest] %v", err)
+		}
+	}
+}
+
+// runDigest генерирует сводку сообщений чата после since
+func (b *Bot) runDigest(d db.ChatDigest, since int64, now time.Time, header string) {
+	chatID := d.ChatID
+
+	messages, err := b.db.GetMessagesRange(chatID, db.ALL_THREADS, since+1, now.Unix(), b.chatConfig(chatID).SummaryLimit)
+	if err != nil {
+		log.Printf("[runDigest] Ошибка получения сообщений чата %d: %v", chatID, err)
+		return
+	}
+	if len(messages) < DIGEST_MIN_MESSAGES {
+		log.Printf("[runDigest] Чат %d: %d новых сообщений, дайджест пропущен", chatID, len(messages))
+		return
+	}
+
+	log.Printf("[runDigest] Дайджест чата %d по %d сообщениям", chatID, len(messages))
+
+	// Дайджест не отвечает на сообщение, биллинг записывается на бота
+	message := &tgbotapi.Message{
+		Chat: &tgbotapi.Chat{ID: chatID},
+		From: &b.tgBot.Self,
+	}
+	ctx, done := b.aiJobs.start(b.workCtx, chatID)
+	defer done()
+	if err := b.summarizeMessages(ctx, message, messages, header); err != nil {
+		log.Printf("[runDigest] Ошибка генерации дайджеста чата %d: %v", chatID, err)
+	}
+}
+
+// handleDigest обрабатывает команду /digest
+//
+//	/digest                     - текущее расписание
+//	/digest on 21:00            - ежедневно в 21:00 (время чата)
+//	/digest weekly mon 10:00    - еженедельно
+//	/digest every 300           - дополнительно после 300 новых сообщений (0 - выключить)
+//	/digest off                 - выключить
+func (b *Bot) handleDigest(message *tgbotapi.Message) {
+	chatID := message.Chat.ID
+
+	current, err := b.db.GetDigest(chatID)
+	if err != nil {
+		log.Printf("[handleDigest] %v", err)
+		b.sendMessage(chatID, "Не удалось получить расписание дайджеста")
+		return
+	}
+
+	args := strings.Fields(strings.ToLower(message.CommandArguments()))
+	if len(args) == 0 {
+		b.sendMessage(chatID, describeDigest(current)+"\n\n"+DIGEST_USAGE)
+		return
+	}
+
+	isAdmin, err := b.IsUserAdmin(chatID, message.From.ID)
+	if err != nil {
+		b.sendMessage(chatID, "Ошибка проверки прав администратора")
+		return
+	}
+	if !isAdmin {
+		b.sendMessage(chatID, "Настраивать дайджест могут только администраторы")
+		return
+	}
+
+	digest := db.ChatDigest{ChatID: chatID}
+	if current != nil {
+		digest = *current
+	}
+	digest.UpdatedBy = message.From.ID
+
+	switch {
+	case args[0] == "off" && len(args) == 1:
+		// Сбрасываем и расписание, иначе "/digest every 0" включил бы его снова
+		digest.Enabled = false
+		digest.Schedule = ""
+		digest.MinMessages = 0
+	case (args[0] == "on" || args[0] == "daily") && len(args) == 2:
+		if _, _, err := parseDigestTime(args[1]); err != nil {
+			b.sendMessage(chatID, err.Error())
+			return
+		}
+		digest.Enabled = true
+		digest.Schedule = "daily"
+		digest.AtTime = args[1]
+		digest.LastRun = time.Now().Unix()
+	case args[0] == "weekly" && len(args) == 3:
+		day, ok := WEEKDAYS[args[1]]
+		if !ok {
+			b.sendMessage(chatID, fmt.Sprintf("Неизвестный день недели %q (mon..sun или пн..вс)", args[1]))
+			return
+		}
+		if _, _, err := parseDigestTime(args[2]); err != nil {
+			b.sendMessage(chatID, err.Error())
+			return
+		}
+		digest.Enabled = true
+		digest.Schedule = "weekly"
+		digest.Weekday = int(day)
+		digest.AtTime = args[2]
+		digest.LastRun = time.Now().Unix()
+	case args[0] == "every" && len(args) == 2:
+		count, err := strconv.Atoi(args[1])
+		if err != nil || count < 0 {
+			b.sendMessage(chatID, "Количество сообщений должно быть целым числом (0 - выключить)")
+			return
+		}
+		digest.MinMessages = count
+		digest.Enabled = digest.Schedule != "" || count > 0
+		digest.LastRun = time.Now().Unix()
+	default:
+		b.sendMessage(chatID, DIGEST_USAGE)
+		return
+	}
+
+	if err := b.db.SaveDigest(digest); err != nil {
+		log.Printf("[handleDigest] %v", err)
+		b.sendMessage(chatID, "Не удалось сохранить расписание дайджеста")
+		return
+	}
+	log.Printf("[handleDigest] %s изменил дайджест чата %d: %s", getUserName(message.From), chatID, describeDigest(&digest))
+
+	reply := describeDigest(&digest)
+	if next, ok := nextDigestRun(digest, time.Now(), b.chatConfig(chatID).Location()); ok && digest.Enabled {
+		reply += fmt.Sprintf("\nСледующий: %s (%s)", next.Format("02.01 15:04"), b.chatConfig(chatID).Timezone)
+	}
+	b.sendMessage(chatID, reply)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"facilitatorbot/db"
+)
+
+// TestDigestRunsInChatQueue дайджест по расписанию идет через очередь чата, и остановка дожидается его
+func TestDigestRunsInChatQueue(t *testing.T) {
+	llm := NewFakeLLMProvider("Обсуждали дайджест [#1].")
+	bot, stub := newTestBot(t, llm, summaryTestConfig())
+	bot.dispatcher = NewDispatcher(2, 10, bot.handleUpdate)
+
+	now := time.Now()
+	for i := 1; i <= DIGEST_MIN_MESSAGES; i++ {
+		message := testMessage(i, int64(i), fmt.Sprintf("сообщение для дайджеста %d", i))
+		message.Date = int(now.Add(-time.Duration(i) * time.Minute).Unix())
+		bot.storeMessage(message, "")
+	}
+
+	digest := db.ChatDigest{ChatID: TEST_CHAT_ID, Enabled: true, Schedule: "daily", AtTime: "00:00", LastRun: now.Add(-48 * time.Hour).Unix()}
+	if err := bot.db.SaveDigest(digest); err != nil {
+		t.Fatalf("SaveDigest: %v", err)
+	}
+	bot.checkDigest(digest, now)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := bot.dispatcher.Stop(ctx); err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+	if llm.CallCount() != 1 {
+		t.Fatalf("ожидался один запрос дайджеста, было %d", llm.CallCount())
+	}
+	if len(stub.sent("sendMessage"))+len(stub.sent("editMessageText")) == 0 {
+		t.Error("дайджест не отправлен в чат")
+	}
+
+	saved, err := bot.db.GetDigest(TEST_CHAT_ID)
+	if err != nil || saved == nil || saved.LastRun != now.Unix() {
+		t.Errorf("время запуска не сохранено: %+v, %v", saved, err)
+	}
+
+	// Остановленный диспетчер задачу не принимает, и время запуска возвращается для следующей проверки
+	bot.checkDigest(digest, now.Add(time.Hour))
+	if saved, _ := bot.db.GetDigest(TEST_CHAT_ID); saved == nil || saved.LastRun != digest.LastRun {
+		t.Errorf("после отказа очереди LastRun %+v, ожидалось прежнее %d", saved, digest.LastRun)
+	}
+}
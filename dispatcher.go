@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const DISPATCH_IDLE_TIMEOUT = 2 * time.Minute // воркер чата завершается после простоя
+
+// chatQueue очередь обновлений одного чата
+type chatQueue struct {
+	updates chan queuedItem
+	pending int // отправлено в очередь, но еще не забрано воркером (под Dispatcher.mu)
+}
+
+// queuedItem обновление Telegram или фоновая задача чата (дайджест), job != nil - задача
+type queuedItem struct {
+	update tgbotapi.Update
+	job    func()
+}
+
+// Dispatcher распределяет обновления по чатам: внутри чата обработка строго
+// последовательная, разные чаты обрабатываются параллельно, но не более workers одновременно.
+type Dispatcher struct {
+	handle    func(tgbotapi.Update)
+	queueSize int
+	slots     chan struct{} // ограничение одновременно работающих обработчиков
+
+	mu     sync.Mutex
+	queues map[int64]*chatQueue
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// NewDispatcher создает диспетчер с workers параллельными обработчиками
+// и очередью queueSize обновлений на чат
+func NewDispatcher(workers, queueSize int, handle func(tgbotapi.Update)) *Dispatcher {
+	return &Dispatcher{
+		handle:    handle,
+		queueSize: max(queueSize, 1),
+		slots:     make(chan struct{}, max(workers, 1)),
+		queues:    make(map[int64]*chatQueue),
+	}
+}
+
+// Dispatch ставит обновление в очередь его чата и никогда не блокируется: обновления всех чатов
+// читаются одним циклом, поэтому если очередь чата заполнена, обновление отбрасывается с ошибкой.
+func (d *Dispatcher) Dispatch(update tgbotapi.Update) error {
+	return d.enqueue(updateChatID(update), queuedItem{update: update})
+}
+
+// Run ставит задачу в очередь чата: она выполнится по порядку с его обновлениями,
+// а Stop дождется ее, как и обновлений. Как и Dispatch, не блокируется.
+func (d *Dispatcher) Run(chatID int64, job func()) error {
+	return d.enqueue(chatID, queuedItem{job: job})
+}
+
+func (d *Dispatcher) enqueue(chatID int64, item queuedItem) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return fmt.Errorf("диспетчер остановлен")
+	}
+	q, ok := d.queues[chatID]
+	if !ok {
+		q = &chatQueue{updates: make(chan queuedItem, d.queueSize)}
+		d.queues[chatID] = q
+		d.wg.Add(1)
+		go d.worker(chatID, q)
+	}
+
+	// Отправка под d.mu: Stop не закроет очередь посреди нее
+	select {
+	case q.updates <- item:
+		q.pending++
+		return nil
+	default:
+		return fmt.Errorf("очередь чата %d переполнена (%d)", chatID, d.queueSize)
+	}
+}
+
+// worker последовательно обрабатывает обновления одного чата
+func (d *Dispatcher) worker(chatID int64, q *chatQueue) {
+	defer d.wg.Done()
+
+	idle := time.NewTimer(DISPATCH_IDLE_TIMEOUT)
+	defer idle.Stop()
+
+	for {
+		select {
+		case item, ok := <-q.updates:
+			if !ok {
+				return
+			}
+			d.mu.Lock()
+			q.pending--
+			d.mu.Unlock()
+
+			d.slots <- struct{}{}
+			d.safeHandle(item)
+			<-d.slots
+
+			if !idle.Stop() {
+				select {
+				case <-idle.C:
+				default:
+				}
+			}
+			idle.Reset(DISPATCH_IDLE_TIMEOUT)
+
+		case <-idle.C:
+			d.mu.Lock()
+			if q.pending == 0 && !d.closed {
+				delete(d.queues, chatID)
+				d.mu.Unlock()
+				return
+			}
+			d.mu.Unlock()
+			idle.Reset(DISPATCH_IDLE_TIMEOUT)
+		}
+	}
+}
+
+// safeHandle вызывает обработчик или задачу, не давая панике уронить весь бот
+func (d *Dispatcher) safeHandle(item queuedItem) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("[Dispatcher] Паника при обработке обновления %d: %v\n%s", item.update.UpdateID, r, debug.Stack())
+		}
+	}()
+	if item.job != nil {
+		item.job()
+		return
+	}
+	d.handle(item.update)
+}
+
+// Stop прекращает прием обновлений и ждет обработки уже поставленных в очередь.
+// Возвращает ошибку, если ctx истек раньше, чем очереди опустели.
+// Можно вызвать повторно, чтобы подождать еще.
+func (d *Dispatcher) Stop(ctx context.Context) error {
+	d.mu.Lock()
+	if !d.closed {
+		d.closed = true
+		for _, q := range d.queues {
+			close(q.updates)
+		}
+	}
+	d.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("не дождались завершения обработки: %w", ctx.Err())
+	}
+}
+
+// updateChatID возвращает чат, к которому относится обновление (0 для прочих)
+func updateChatID(update tgbotapi.Update) int64 {
+	switch {
+	case update.Message != nil && update.Message.Chat != nil:
+		return update.Message.Chat.ID
+	case update.CallbackQuery != nil && update.CallbackQuery.Message != nil && update.CallbackQuery.Message.Chat != nil:
+		return update.CallbackQuery.Message.Chat.ID
+	default:
+		return 0
+	}
+}
+
+// summaryTimes время последней сводки по чатам, безопасно для параллельного доступа
+type summaryTimes struct {
+	mu    sync.RWMutex
+	times map[int64]time.Time
+}
+
+func newSummaryTimes() *summaryTimes {
+	return &summaryTimes{times: make(map[int64]time.Time)}
+}
+
+// Set запоминает время сводки в чате
+func (s *summaryTimes) Set(chatID int64, t time.Time) {
+	s.mu.Lock()
+	s.times[chatID] = t
+	s.mu.Unlock()
+}
+
+// Get возвращает время последней сводки в чате
+func (s *summaryTimes) Get(chatID int64) (time.Time, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	t, ok := s.times[chatID]
+	return t, ok
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// TestDispatcherStopDuringDispatch Stop во время отправок не роняет Dispatch,
+// а каждое принятое обновление обрабатывается
+func TestDispatcherStopDuringDispatch(t *testing.T) {
+	var handled atomic.Int64
+	d := NewDispatcher(2, 1, func(tgbotapi.Update) {
+		time.Sleep(time.Millisecond)
+		handled.Add(1)
+	})
+
+	var accepted atomic.Int64
+	var wg sync.WaitGroup
+	for sender := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range 50 {
+				update := tgbotapi.Update{UpdateID: sender*100 + i, Message: testMessage(i, 1, "текст")}
+				update.Message.Chat.ID = int64(sender % 3)
+				if d.Dispatch(update) == nil {
+					accepted.Add(1)
+				}
+			}
+		}()
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := d.Stop(ctx); err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+	wg.Wait()
+
+	if handled.Load() != accepted.Load() {
+		t.Errorf("обработано %d обновлений, принято %d", handled.Load(), accepted.Load())
+	}
+}
+
+// TestDispatcherFullQueueDoesNotBlock переполненная очередь одного чата не задерживает остальные
+func TestDispatcherFullQueueDoesNotBlock(t *testing.T) {
+	const SLOW_CHAT, OTHER_CHAT = 1, 2
+
+	release := make(chan struct{})
+	otherHandled := make(chan struct{})
+	d := NewDispatcher(2, 1, func(update tgbotapi.Update) {
+		switch update.Message.Chat.ID {
+		case SLOW_CHAT:
+			<-release
+		case OTHER_CHAT:
+			close(otherHandled)
+		}
+	})
+	defer func() {
+		close(release)
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		d.Stop(ctx)
+	}()
+
+	update := func(chatID int64, id int) tgbotapi.Update {
+		message := testMessage(id, 1, "текст")
+		message.Chat.ID = chatID
+		return tgbotapi.Update{UpdateID: id, Message: message}
+	}
+
+	// Первое обновление занимает обработчик, второе - очередь, остальные отбрасываются сразу
+	rejected := 0
+	dispatched := make(chan struct{})
+	go func() {
+		defer close(dispatched)
+		for i := range 10 {
+			if d.Dispatch(update(SLOW_CHAT, i)) != nil {
+				rejected++
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}()
+	select {
+	case <-dispatched:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Dispatch заблокировался на переполненной очереди")
+	}
+	if rejected == 0 {
+		t.Error("переполненная очередь должна отбрасывать обновления")
+	}
+
+	if err := d.Dispatch(update(OTHER_CHAT, 100)); err != nil {
+		t.Fatalf("Dispatch другого чата: %v", err)
+	}
+	select {
+	case <-otherHandled:
+	case <-time.After(2 * time.Second):
+		t.Fatal("обновление другого чата не обработано, пока первый чат занят")
+	}
+}
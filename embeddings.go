@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+)
+
+// EmbeddingProvider превращает тексты в векторы для семантического поиска
+type EmbeddingProvider interface {
+	Embed(ctx context.Context, model string, texts []string) ([][]float32, error)
+}
+
+// embeddingsRequest запрос к OpenAI-совместимому /v1/embeddings
+type embeddingsRequest struct {
+	Model string   `json:"model"`
+	Input []string `json:"input"`
+}
+
+// embeddingsResponse ответ /v1/embeddings, index - позиция текста в запросе
+type embeddingsResponse struct {
+	Data []struct {
+		Index     int       `json:"index"`
+		Embedding []float32 `json:"embedding"`
+	} `json:"data"`
+}
+
+// OpenAIEmbedder клиент /v1/embeddings (LM Studio, Ollama, vLLM, OpenAI и т.п.)
+type OpenAIEmbedder struct {
+	url    string
+	client *http.Client
+}
+
+// NewOpenAIEmbedder создает клиента эмбеддингов
+func NewOpenAIEmbedder(url string, client *http.Client) *OpenAIEmbedder {
+	return &OpenAIEmbedder{url: url, client: client}
+}
+
+// Embed возвращает нормализованные векторы texts в том же порядке
+func (e *OpenAIEmbedder) Embed(ctx context.Context, model string, texts []string) ([][]float32, error) {
+	jsonData, err := json.Marshal(embeddingsRequest{Model: model, Input: texts})
+	if err != nil {
+		return nil, fmt.Errorf("ошибка маршалинга запроса: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url, bytes.NewReader(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("ошибка создания HTTP запроса: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка HTTP запроса: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(&io.LimitedReader{R: resp.Body, N: 4096})
+		return nil, newLLMStatusError(resp, string(body))
+	}
+
+	var response embeddingsResponse
+	if err := json.NewDecoder(&io.LimitedReader{R: resp.Body, N: 64 * 1024 * 1024}).Decode(&response); err != nil {
+		return nil, fmt.Errorf("ошибка декодирования ответа: %v", err)
+	}
+	if len(response.Data) != len(texts) {
+		return nil, fmt.Errorf("получено %d векторов на %d текстов", len(response.Data), len(texts))
+	}
+
+	vectors := make([][]float32, len(texts))
+	for _, item := range response.Data {
+		if item.Index < 0 || item.Index >= len(texts) || len(item.Embedding) == 0 {
+			return nil, fmt.Errorf("некорректный вектор с индексом %d", item.Index)
+		}
+		vectors[item.Index] = normalizeVector(item.Embedding)
+	}
+	return vectors, nil
+}
+
+// normalizeVector приводит вектор к единичной длине, тогда косинусная близость - скалярное произведение
+func normalizeVector(v []float32) []float32 {
+	var sum float64
+	for _, f := range v {
+		sum += float64(f) * float64(f)
+	}
+	if sum == 0 {
+		return v
+	}
+	norm := float32(math.Sqrt(sum))
+	for i := range v {
+		v[i] /= norm
+	}
+	return v
+}
+
+// cosineSimilarity близость нормализованных векторов, 0 при разной размерности
+func cosineSimilarity(a, b []float32) float64 {
+	if len(a) != len(b) {
+		return 0
+	}
+	var dot float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+	}
+	return dot
+}
+
+// scoredMessage номер строки messages и близость к запросу
+type scoredMessage struct {
+	rowID int
+	score float64
+}
+
+// topMatches лучшие limit совпадений с близостью не ниже minScore
+type topMatches struct {
+	limit    int
+	minScore float64
+	items    []scoredMessage
+}
+
+func (t *topMatches) add(rowID int, score float64) {
+	if score < t.minScore {
+		return
+	}
+	if len(t.items) == t.limit && score <= t.items[len(t.items)-1].score {
+		return
+	}
+	t.items = append(t.items, scoredMessage{rowID: rowID, score: score})
+	sort.Slice(t.items, func(i, j int) bool { return t.items[i].score > t.items[j].score })
+	if len(t.items) > t.limit {
+		t.items = t.items[:t.limit]
+	}
+}
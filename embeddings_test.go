@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// embeddingStubWords измерения векторов заглушки: координата - сколько раз слово встретилось в тексте
+var embeddingStubWords = []string{"отпуск", "море", "сервер", "база", "кот"}
+
+const EMBEDDING_STUB_REJECTED = "запрещенный текст" // такой текст заглушка отклоняет с 400
+
+// embeddingStub заглушка OpenAI-совместимого /v1/embeddings с предсказуемыми векторами
+type embeddingStub struct {
+	mu       sync.Mutex
+	requests [][]string
+}
+
+func (s *embeddingStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var request embeddingsRequest
+	if r.URL.Path != "/v1/embeddings" || json.NewDecoder(r.Body).Decode(&request) != nil || request.Model == "" {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	s.requests = append(s.requests, request.Input)
+	s.mu.Unlock()
+
+	var response embeddingsResponse
+	for i, text := range request.Input {
+		if strings.Contains(text, EMBEDDING_STUB_REJECTED) {
+			http.Error(w, `{"error":"input rejected"}`, http.StatusBadRequest)
+			return
+		}
+		vector := []float32{0.1} // чтобы вектор текста без ключевых слов не был нулевым
+		for _, word := range embeddingStubWords {
+			vector = append(vector, float32(strings.Count(strings.ToLower(text), word)))
+		}
+		response.Data = append(response.Data, struct {
+			Index     int       `json:"index"`
+			Embedding []float32 `json:"embedding"`
+		}{Index: i, Embedding: vector})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func TestEmbeddingIndexAndSearch(t *testing.T) {
+	bot, _ := newTestBot(t, NewFakeLLMProvider(), summaryTestConfig())
+	stub := &embeddingStub{}
+	server := httptest.NewServer(stub)
+	t.Cleanup(server.Close)
+	bot.embedder = NewOpenAIEmbedder(server.URL+"/v1/embeddings", server.Client())
+
+	texts := []string{
+		"Кто поедет в отпуск на море летом?",
+		"Сервер опять упал, база не отвечает",
+		"Это " + EMBEDDING_STUB_REJECTED + " для модели",
+		"Мой кот спит на клавиатуре весь день",
+		"ок",
+	}
+	for i, text := range texts {
+		bot.storeMessage(testMessage(i+1, int64(i+1), text), "")
+	}
+
+	ctx := context.Background()
+	indexed := 0
+	for range 3 {
+		n, err := bot.indexEmbeddings(ctx)
+		if err != nil {
+			t.Fatalf("indexEmbeddings: %v", err)
+		}
+		if n == 0 {
+			break
+		}
+		indexed += n
+	}
+	// Короткое "ок" не индексируется, отклоненный текст помечается и больше не запрашивается
+	if indexed != 4 {
+		t.Errorf("проиндексировано %d сообщений, ожидалось 4", indexed)
+	}
+	if n, err := bot.indexEmbeddings(ctx); n != 0 || err != nil {
+		t.Errorf("повторная индексация: %d сообщений, ошибка %v", n, err)
+	}
+
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{query: "отпуск на море", want: texts[0]},
+		{query: "что с сервером и базой", want: texts[1]},
+		{query: "кот", want: texts[3]},
+	}
+	for _, tt := range tests {
+		messages, scores, err := bot.searchMessages(ctx, TEST_CHAT_ID, tt.query, 3, 0)
+		if err != nil {
+			t.Fatalf("searchMessages(%q): %v", tt.query, err)
+		}
+		if len(messages) == 0 || messages[0].Text != tt.want {
+			t.Errorf("searchMessages(%q): первым %v, ожидалось %q", tt.query, messages, tt.want)
+			continue
+		}
+		for i := 1; i < len(scores); i++ {
+			if scores[i] > scores[i-1] {
+				t.Errorf("searchMessages(%q): результаты не по убыванию близости: %v", tt.query, scores)
+			}
+		}
+		for _, msg := range messages {
+			if strings.Contains(msg.Text, EMBEDDING_STUB_REJECTED) {
+				t.Errorf("searchMessages(%q): в результатах отклоненный текст", tt.query)
+			}
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"facilitatorbot/db"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const FIND_CALLBACK_PREFIX = "find:"
+const FIND_PAGE_SIZE = 5
+
+// FIND_USAGE подсказка по /find
+const FIND_USAGE = `Использование: /find <слова> [from:@username] [since:2024-05-01 | since:7d] [until:2024-05-31]
+Например: /find зимние шины from:@vasya since:30d`
+
+// parseFindDate разбирает дату "2006-01-02" или "02.01.2006" в часовом поясе чата
+func parseFindDate(value string, loc *time.Location) (time.Time, error) {
+	for _, layout := range []string{"2006-01-02", "02.01.2006", "02.01.06"} {
+		if t, err := time.ParseInLocation(layout, value, loc); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("не понял дату %q (2024-05-01 или 01.05.2024)", value)
+}
+
+// parseFindArgs разбирает аргументы /find: слова запроса и фильтры from:, since:, until:
+func parseFindArgs(args string, chatID int64, loc *time.Location, now time.Time) (db.MessageSearch, error) {
+	search := db.MessageSearch{ChatID: chatID, Limit: FIND_PAGE_SIZE}
+	var words []string
+	for _, arg := range strings.Fields(args) {
+		key, value, found := strings.Cut(arg, ":")
+		if !found || value == "" {
+			words = append(words, arg)
+			continue
+		}
+		switch strings.ToLower(key) {
+		case "from", "от":
+			search.Username = strings.TrimPrefix(value, "@")
+		case "since", "с":
+			if d, ok := parseDurationArg(value); ok {
+				search.From = now.Add(-d).Unix()
+				continue
+			}
+			t, err := parseFindDate(value, loc)
+			if err != nil {
+				return search, err
+			}
+			search.From = t.Unix()
+		case "until", "до":
+			t, err := parseFindDate(value, loc)
+			if err != nil {
+				return search, err
+			}
+			// Дата включительно, до конца дня
+			search.To = t.AddDate(0, 0, 1).Unix() - 1
+		default:
+			words = append(words, arg)
+		}
+	}
+	search.Query = strings.Join(words, " ")
+	if strings.TrimSpace(search.Query) == "" {
+		return search, fmt.Errorf("укажите, что искать")
+	}
+	return search, nil
+}
+
+// findPage текст и кнопки страницы результатов /find, page с 0
+func (b *Bot) findPage(chatID int64, args string, page int) (string, *tgbotapi.InlineKeyboardMarkup, error) {
+	loc := b.chatConfig(chatID).Location()
+	search, err := parseFindArgs(args, chatID, loc, time.Now())
+	if err != nil {
+		return "", nil, err
+	}
+	search.Offset = page * FIND_PAGE_SIZE
+
+	found, total, err := b.db.FindMessages(search)
+	if err != nil {
+		log.Printf("[findPage] %v", err)
+		return "", nil, fmt.Errorf("не удалось выполнить поиск")
+	}
+	if total == 0 {
+		return fmt.Sprintf("🔎 По запросу «%s» ничего не нашлось", html.EscapeString(search.Query)), nil, nil
+	}
+	pages := (total + FIND_PAGE_SIZE - 1) / FIND_PAGE_SIZE
+	if len(found) == 0 {
+		return "", nil, fmt.Errorf("страница %d не найдена", page+1)
+	}
+
+	var text strings.Builder
+	text.WriteString(fmt.Sprintf("🔎 «%s»: найдено %d, страница %d из %d\n", html.EscapeString(search.Query), total, page+1, pages))
+	for i, msg := range found {
+		author := html.EscapeString(msg.UserFirstName)
+		if msg.Username != "" {
+			author += " (@" + html.EscapeString(msg.Username) + ")"
+		}
+		text.WriteString(fmt.Sprintf("\n%d. <b>%s</b>, %s", search.Offset+i+1, author,
+			time.Unix(msg.Timestamp, 0).In(loc).Format("02.01.2006 15:04")))
+		if link := messageLink(chatID, msg.MessageID); link != "" {
+			text.WriteString(fmt.Sprintf(` <a href="%s">→</a>`, html.EscapeString(link)))
+		}
+
+		snippet := html.EscapeString(strings.Join(strings.Fields(msg.Snippet), " "))
+		snippet = strings.NewReplacer(db.SNIPPET_START, "<b>", db.SNIPPET_END, "</b>").Replace(snippet)
+		text.WriteString("\n" + snippet + "\n")
+	}
+
+	if pages == 1 {
+		return text.String(), nil, nil
+	}
+	var row []tgbotapi.InlineKeyboardButton
+	if page > 0 {
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData("◀️ Назад", FIND_CALLBACK_PREFIX+strconv.Itoa(page-1)))
+	}
+	if page+1 < pages {
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData("Вперед ▶️", FIND_CALLBACK_PREFIX+strconv.Itoa(page+1)))
+	}
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(row)
+	return text.String(), &keyboard, nil
+}
+
+// handleFind обрабатывает команду /find. Результаты отправляются ответом на команду:
+// кнопки страниц берут запрос из нее, поэтому состояние поиска не хранится
+func (b *Bot) handleFind(message *tgbotapi.Message) {
+	chatID := message.Chat.ID
+	if !b.isChatAllowed(chatID) {
+		b.sendMessage(chatID, "Извините, у меня нет доступа к истории этого чата.")
+		return
+	}
+	if strings.TrimSpace(message.CommandArguments()) == "" {
+		b.sendMessage(chatID, FIND_USAGE)
+		return
+	}
+
+	text, keyboard, err := b.findPage(chatID, message.CommandArguments(), 0)
+	if err != nil {
+		b.sendMessage(chatID, fmt.Sprintf("%v\n%s", err, FIND_USAGE))
+		return
+	}
+
+	msg := tgbotapi.NewMessage(chatID, text)
+	msg.ParseMode = tgbotapi.ModeHTML
+	msg.DisableWebPagePreview = true
+	msg.ReplyToMessageID = message.MessageID
+	if keyboard != nil {
+		msg.ReplyMarkup = keyboard
+	}
+	if _, err := b.tgBot.Send(msg); err != nil {
+		log.Printf("[handleFind] Ошибка отправки результатов: %v", err)
+	}
+}
+
+// handleFindCallback листает страницы результатов /find
+func (b *Bot) handleFindCallback(query *tgbotapi.CallbackQuery) {
+	chatID := query.Message.Chat.ID
+	page, err := strconv.Atoi(strings.TrimPrefix(query.Data, FIND_CALLBACK_PREFIX))
+	command := query.Message.ReplyToMessage
+	if err != nil || page < 0 || command == nil {
+		b.answerCallback(query, "Поиск устарел, повторите /find")
+		return
+	}
+
+	text, keyboard, err := b.findPage(chatID, command.CommandArguments(), page)
+	if err != nil {
+		b.answerCallback(query, err.Error())
+		return
+	}
+
+	edit := tgbotapi.NewEditMessageText(chatID, query.Message.MessageID, text)
+	edit.ParseMode = tgbotapi.ModeHTML
+	edit.DisableWebPagePreview = true
+	edit.ReplyMarkup = keyboard
+	if _, err := b.tgBot.Request(edit); err != nil {
+		log.Printf("[handleFindCallback] Ошибка обновления результатов: %v", err)
+	}
+	b.answerCallback(query, "")
+}
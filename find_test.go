@@ -0,0 +1,57 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseFindArgs(t *testing.T) {
+	loc := time.FixedZone("MSK", 3*60*60)
+	now := time.Date(2024, 6, 15, 12, 0, 0, 0, loc)
+	day := func(year int, month time.Month, d int) time.Time { return time.Date(year, month, d, 0, 0, 0, 0, loc) }
+
+	tests := []struct {
+		name     string
+		args     string
+		query    string
+		username string
+		from     int64
+		to       int64
+		wantErr  bool
+	}{
+		{name: "только слова", args: "зимние шины", query: "зимние шины"},
+		{name: "автор", args: "шины from:@vasya", query: "шины", username: "vasya"},
+		{name: "автор по-русски", args: "от:vasya шины", query: "шины", username: "vasya"},
+		{name: "since период", args: "шины since:7d", query: "шины", from: now.Add(-7 * 24 * time.Hour).Unix()},
+		{name: "since дата", args: "шины since:2024-05-01", query: "шины", from: day(2024, 5, 1).Unix()},
+		{name: "until включительно", args: "шины until:31.05.2024", query: "шины", to: day(2024, 6, 1).Unix() - 1},
+		{name: "период целиком", args: "с:01.05.24 до:2024-05-31 шины", query: "шины", from: day(2024, 5, 1).Unix(), to: day(2024, 6, 1).Unix() - 1},
+		{name: "двоеточие в слове", args: "http://example.com шины:", query: "http://example.com шины:"},
+		{name: "операторы FTS", args: `"шины" NEAR -диски *`, query: `"шины" NEAR -диски *`},
+		{name: "неверная дата", args: "шины since:вчера", wantErr: true},
+		{name: "until период", args: "шины until:7d", wantErr: true},
+		{name: "только фильтры", args: "from:@vasya since:7d", wantErr: true},
+		{name: "пусто", args: "  ", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			search, err := parseFindArgs(tt.args, TEST_CHAT_ID, loc, now)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("ожидалась ошибка, разобрано %+v", search)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseFindArgs: %v", err)
+			}
+			if search.Query != tt.query || search.Username != tt.username || search.From != tt.from || search.To != tt.to {
+				t.Errorf("разобрано query=%q username=%q from=%d to=%d, ожидалось query=%q username=%q from=%d to=%d",
+					search.Query, search.Username, search.From, search.To, tt.query, tt.username, tt.from, tt.to)
+			}
+			if search.ChatID != TEST_CHAT_ID || search.Limit != FIND_PAGE_SIZE {
+				t.Errorf("чат %d, страница %d", search.ChatID, search.Limit)
+			}
+		})
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"log"
+	"regexp"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const HTML_SPLIT_RESERVE = 64 // запас длины части под закрывающие теги
+
+var (
+	mdCodeBlockRe  = regexp.MustCompile("(?s)```([A-Za-z0-9_+-]*)\\n?(.*?)```")
+	mdInlineCodeRe = regexp.MustCompile("`([^`\\n]+)`")
+	mdLinkRe       = regexp.MustCompile(`\[([^\[\]\n]+)\]\((https?://[^\s)]+)\)`)
+	mdHeadingRe    = regexp.MustCompile(`(?m)^#{1,6}\s+(.+?)\s*#*$`)
+	mdListRe       = regexp.MustCompile(`(?m)^(\s*)[-*+]\s+`)
+	mdBoldRe       = regexp.MustCompile(`\*\*([^*\n]+?)\*\*|__([^_\n]+?)__`)
+	mdStrikeRe     = regexp.MustCompile(`~~([^~\n]+?)~~`)
+	mdItalicStarRe = regexp.MustCompile(`\*([^*\s](?:[^*\n]*?[^*\s])?)\*`)
+	mdItalicUndRe  = regexp.MustCompile(`(^|[^A-Za-z0-9_])_([^_\s](?:[^_\n]*?[^_\s])?)_($|[^A-Za-z0-9_])`)
+	htmlTagRe      = regexp.MustCompile(`<(/?)([a-z]+)[^>]*>`)
+	htmlLinkRe     = regexp.MustCompile(`<a href="([^"]*)">(.*?)</a>`)
+)
+
+// markdownToHTML переводит Markdown ответа модели в Telegram HTML: жирный, курсив,
+// зачеркнутый, код, ссылки, заголовки (жирным) и списки. Остальное экранируется.
+func markdownToHTML(text string) string {
+	// Код не форматируем, прячем под заглушки до конца преобразования
+	var code []string
+	stash := func(s string) string {
+		code = append(code, s)
+		return fmt.Sprintf("\x00%d\x00", len(code)-1)
+	}
+
+	text = mdCodeBlockRe.ReplaceAllStringFunc(text, func(m string) string {
+		parts := mdCodeBlockRe.FindStringSubmatch(m)
+		body := html.EscapeString(strings.TrimRight(parts[2], "\n"))
+		if parts[1] != "" {
+			return stash(fmt.Sprintf(`<pre><code class="language-%s">%s</code></pre>`, parts[1], body))
+		}
+		return stash("<pre>" + body + "</pre>")
+	})
+	text = mdInlineCodeRe.ReplaceAllStringFunc(text, func(m string) string {
+		return stash("<code>" + html.EscapeString(mdInlineCodeRe.FindStringSubmatch(m)[1]) + "</code>")
+	})
+
+	text = html.EscapeString(text)
+
+	text = mdLinkRe.ReplaceAllString(text, `<a href="$2">$1</a>`)
+	text = mdHeadingRe.ReplaceAllString(text, "<b>$1</b>")
+	text = mdListRe.ReplaceAllString(text, "$1• ")
+	text = mdBoldRe.ReplaceAllString(text, "<b>$1$2</b>")
+	text = mdStrikeRe.ReplaceAllString(text, "<s>$1</s>")
+	text = mdItalicStarRe.ReplaceAllString(text, "<i>$1</i>")
+	text = mdItalicUndRe.ReplaceAllString(text, "$1<i>$2</i>$3")
+
+	for i, block := range code {
+		text = strings.Replace(text, fmt.Sprintf("\x00%d\x00", i), block, 1)
+	}
+	return text
+}
+
+// htmlToPlain обычный текст из Telegram HTML для отправки без разметки, ссылки - "текст (url)"
+func htmlToPlain(text string) string {
+	text = htmlLinkRe.ReplaceAllStringFunc(text, func(m string) string {
+		parts := htmlLinkRe.FindStringSubmatch(m)
+		return parts[2] + " (" + parts[1] + ")"
+	})
+	return html.UnescapeString(htmlTagRe.ReplaceAllString(text, ""))
+}
+
+// splitHTMLMessage делит HTML на сообщения не длиннее maxLen символов. Режет по абзацам,
+// затем по строкам и пробелам; незакрытые теги закрываются в конце части и открываются
+// заново в начале следующей.
+func splitHTMLMessage(text string, maxLen int) []string {
+	var parts []string
+	prefix := ""
+	for {
+		text = prefix + strings.TrimSpace(text)
+		runes := []rune(text)
+		if len(runes) <= maxLen {
+			return append(parts, text)
+		}
+
+		limit := max(maxLen-HTML_SPLIT_RESERVE, maxLen/2)
+		head := string(runes[:limit])
+		cut := -1
+		for _, sep := range []string{"\n\n", "\n", " "} {
+			if i := strings.LastIndex(head, sep); i > len(prefix) {
+				cut = i
+				break
+			}
+		}
+		if cut < 0 {
+			cut = len(head)
+		}
+		// Не режем внутри тега или HTML-сущности
+		if i := strings.LastIndexAny(head[:cut], "<&"); i > len(prefix) && i > strings.LastIndexAny(head[:cut], ">;") {
+			cut = i
+		}
+
+		part, open := closeOpenTags(text[:cut])
+		parts = append(parts, strings.TrimSpace(part))
+		text = text[cut:]
+		prefix = strings.Join(open, "")
+	}
+}
+
+// closeOpenTags дописывает закрывающие теги к части и возвращает открытые теги для следующей
+func closeOpenTags(part string) (string, []string) {
+	var open []string
+	var names []string
+	for _, m := range htmlTagRe.FindAllStringSubmatch(part, -1) {
+		if m[1] == "" {
+			open = append(open, m[0])
+			names = append(names, m[2])
+			continue
+		}
+		for i := len(names) - 1; i >= 0; i-- {
+			if names[i] == m[2] {
+				open = append(open[:i], open[i+1:]...)
+				names = append(names[:i], names[i+1:]...)
+				break
+			}
+		}
+	}
+	for i := len(names) - 1; i >= 0; i-- {
+		part += "</" + names[i] + ">"
+	}
+	return part, open
+}
+
+// isEntityError Telegram не смог разобрать разметку сообщения
+func isEntityError(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "can't parse entities")
+}
+
+// sendHTML отправляет Telegram HTML, длинный текст несколькими сообщениями. Часть, разметку
+// которой Telegram не принял, уходит обычным текстом. replyTo 0 - не ответ.
+func (b *Bot) sendHTML(chatID int64, replyTo int, text string) {
+	for _, part := range splitHTMLMessage(text, TG_MAX_MESSAGE_LEN) {
+		msg := tgbotapi.NewMessage(chatID, part)
+		msg.ParseMode = tgbotapi.ModeHTML
+		msg.DisableWebPagePreview = true
+		msg.ReplyToMessageID = replyTo
+		_, err := b.tgBot.Send(msg)
+		if isEntityError(err) {
+			log.Printf("[sendHTML] Telegram не принял разметку, отправляем текстом: %v", err)
+			msg.Text = htmlToPlain(part)
+			msg.ParseMode = ""
+			_, err = b.tgBot.Send(msg)
+		}
+		if err != nil {
+			log.Printf("Ошибка отправки сообщения: %v", err)
+		}
+	}
+}
+
+// sendMarkdown отправляет текст модели в Markdown с преобразованием в Telegram HTML
+func (b *Bot) sendMarkdown(chatID int64, replyTo int, text string) {
+	b.sendHTML(chatID, replyTo, markdownToHTML(text))
+}
+
+// editHTML заменяет текст сообщения на Telegram HTML, при ошибке разметки - обычным текстом
+func (b *Bot) editHTML(chatID int64, messageID int, text string) error {
+	edit := tgbotapi.NewEditMessageText(chatID, messageID, text)
+	edit.ParseMode = tgbotapi.ModeHTML
+	edit.DisableWebPagePreview = true
+	_, err := b.tgBot.Request(edit)
+	if isEntityError(err) {
+		log.Printf("[editHTML] Telegram не принял разметку, отправляем текстом: %v", err)
+		edit.Text = htmlToPlain(text)
+		edit.ParseMode = ""
+		_, err = b.tgBot.Request(edit)
+	}
+	return err
+}
@@ -0,0 +1,536 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"facilitatorbot/db"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const INCIDENT_CALLBACK_PREFIX = "incident:"   // кнопки проверки инцидента: incident:<действие>:<id>
+const INCIDENTS_CALLBACK_PREFIX = "incidents:" // страницы /incidents: incidents:<страница>
+const INCIDENTS_PAGE_SIZE = 5
+const INCIDENT_SNIPPET_LEN = 120                  // сколько текста показывать в списке
+const INCIDENT_STATS_PERIOD = 30 * 24 * time.Hour // за какой срок считать /incidents stats
+
+// INCIDENTS_USAGE подсказка по /incidents
+const INCIDENTS_USAGE = `Использование:
+/incidents - последние инциденты антиспама
+/incidents <id> - инцидент с кнопками проверки
+/incidents stats - точность правил по проверенным инцидентам
+/incidents whitelist - белый список антиспама
+/incidents unwhitelist <user_id> - убрать пользователя из белого списка`
+
+// INCIDENT_REVIEWS решения по инцидентам для отчетов
+var INCIDENT_REVIEWS = map[string]string{
+	db.INCIDENT_CONFIRMED:      "✅ спам подтвержден",
+	db.INCIDENT_FALSE_POSITIVE: "↩️ ложное срабатывание",
+}
+
+// incidentKeyboard кнопки проверки инцидента
+func incidentKeyboard(id int64) tgbotapi.InlineKeyboardMarkup {
+	data := func(action string) string {
+		return fmt.Sprintf("%s%s:%d", INCIDENT_CALLBACK_PREFIX, action, id)
+	}
+	return tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("✅ Спам, бан", data("ban")),
+			tgbotapi.NewInlineKeyboardButtonData("↩️ Не спам", data("undo")),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("🤍 Не спам, в белый список", data("white")),
+		),
+	)
+}
+
+// incidentUser автор сообщения инцидента
+func incidentUser(incident db.SpamIncident) *tgbotapi.User {
+	return &tgbotapi.User{ID: incident.UserID, UserName: incident.Username, FirstName: incident.FirstName, LastName: incident.LastName}
+}
+
+// incidentReport текст отчета об инциденте
+func incidentReport(incident db.SpamIncident) string {
+	title := incident.ChatTitle
+	if title == "" {
+		title = strconv.FormatInt(incident.ChatID, 10)
+	}
+	var report strings.Builder
+	report.WriteString(fmt.Sprintf("🚨 <b>Спам</b> #%d в чате <b>%s</b>\n", incident.ID, html.EscapeString(title)))
+	report.WriteString(fmt.Sprintf("От: %s, id %d\n", userLink(incidentUser(incident)), incident.UserID))
+	report.WriteString(fmt.Sprintf("Причина: %s\n", html.EscapeString(incident.Reason)))
+	report.WriteString(fmt.Sprintf("Нарушение №%d, мера: %s", incident.Strike, SPAM_ACTIONS[incident.Action]))
+	if incident.ActionError != "" {
+		report.WriteString(" (не применена: " + html.EscapeString(incident.ActionError) + ")")
+	}
+	if incident.DeleteError != "" && incident.DeleteError != incident.ActionError {
+		report.WriteString("\nСообщение не удалено: " + html.EscapeString(incident.DeleteError))
+	}
+	if incident.Review != "" {
+		report.WriteString("\nПроверка: " + INCIDENT_REVIEWS[incident.Review])
+	}
+	// Ссылка на удаленное сообщение открывает чат на его месте
+	if link := messageLink(incident.ChatID, incident.MessageID); link != "" {
+		label := "Сообщение"
+		if incident.MessageDeleted() {
+			label = "Место в чате"
+		}
+		report.WriteString(fmt.Sprintf("\n<a href=\"%s\">%s</a>", html.EscapeString(link), label))
+	}
+	text := incident.Text
+	if len([]rune(text)) > SPAM_REPORT_TEXT_LEN {
+		text = truncateRunes(text, SPAM_REPORT_TEXT_LEN) + "…"
+	}
+	report.WriteString("\n<blockquote>" + html.EscapeString(text) + "</blockquote>")
+	return report.String()
+}
+
+// sendIncidentReport отправляет отчет, к непроверенному сохраненному инциденту - с кнопками
+func (b *Bot) sendIncidentReport(chatID int64, replyTo int, incident db.SpamIncident) {
+	if incident.ID == 0 || incident.Review != "" {
+		b.sendHTML(chatID, replyTo, incidentReport(incident))
+		return
+	}
+	msg := tgbotapi.NewMessage(chatID, incidentReport(incident))
+	msg.ParseMode = tgbotapi.ModeHTML
+	msg.DisableWebPagePreview = true
+	msg.ReplyToMessageID = replyTo
+	msg.ReplyMarkup = incidentKeyboard(incident.ID)
+	if _, err := b.tgBot.Send(msg); err != nil {
+		// Администратор, не начинавший диалог с ботом, отчет не получит - это видно в логе
+		log.Printf("[sendIncidentReport] Ошибка отправки отчета в %d: %v", chatID, err)
+	}
+}
+
+// incidentClaims инциденты, по которым сейчас применяется решение
+type incidentClaims struct {
+	mu  sync.Mutex
+	ids map[int64]bool
+}
+
+func newIncidentClaims() *incidentClaims {
+	return &incidentClaims{ids: make(map[int64]bool)}
+}
+
+// claim захватывает инцидент, false - решение по нему уже применяется
+func (c *incidentClaims) claim(id int64) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.ids[id] {
+		return false
+	}
+	c.ids[id] = true
+	return true
+}
+
+// release освобождает инцидент после решения или неудачной попытки
+func (c *incidentClaims) release(id int64) {
+	c.mu.Lock()
+	delete(c.ids, id)
+	c.mu.Unlock()
+}
+
+// handleIncidentCallback обрабатывает кнопки проверки инцидента.
+// Решение записывается один раз и только если мера применена или отменена:
+// из нескольких копий отчета сработает первая нажатая кнопка.
+func (b *Bot) handleIncidentCallback(query *tgbotapi.CallbackQuery) {
+	action, idArg, _ := strings.Cut(strings.TrimPrefix(query.Data, INCIDENT_CALLBACK_PREFIX), ":")
+	id, err := strconv.ParseInt(idArg, 10, 64)
+	if err != nil {
+		b.answerCallback(query, "Неизвестная кнопка")
+		return
+	}
+	incident, err := b.db.GetIncident(id)
+	if err != nil {
+		log.Printf("[handleIncidentCallback] %v", err)
+	}
+	if incident == nil {
+		b.answerCallback(query, "Инцидент не найден")
+		return
+	}
+
+	// Отчет может прийти в отдельный чат или в личку, права проверяем в чате инцидента
+	isAdmin, err := b.IsUserAdmin(incident.ChatID, query.From.ID)
+	if err != nil || !isAdmin {
+		b.answerCallback(query, "Решать по инцидентам могут только администраторы чата")
+		return
+	}
+
+	review := db.INCIDENT_FALSE_POSITIVE
+	switch action {
+	case "ban":
+		review = db.INCIDENT_CONFIRMED
+	case "undo", "white":
+	default:
+		b.answerCallback(query, "Неизвестная кнопка")
+		return
+	}
+
+	// Отчет приходит в несколько чатов, их очереди обрабатываются параллельно: без захвата два
+	// администратора могли бы одновременно забанить и отменить меры. Решение читаем уже под захватом.
+	if !b.incidents.claim(id) {
+		b.answerCallback(query, "По инциденту уже принимается решение")
+		return
+	}
+	defer b.incidents.release(id)
+	if incident, err = b.db.GetIncident(id); err != nil || incident == nil {
+		log.Printf("[handleIncidentCallback] Инцидент #%d не перечитан: %v", id, err)
+		b.answerCallback(query, "Не удалось проверить инцидент, попробуйте еще раз")
+		return
+	}
+
+	if incident.Review != "" {
+		b.removeKeyboard(query.Message.Chat.ID, query.Message.MessageID)
+		b.answerCallback(query, "Уже проверен: "+INCIDENT_REVIEWS[incident.Review])
+		return
+	}
+
+	// Решение сохраняется только после успешной отмены или бана, иначе кнопки остаются для повторной попытки
+	var result string
+	switch action {
+	case "ban":
+		result = "спам подтвержден, пользователь заблокирован"
+		err = b.banSpammer(*incident)
+	case "undo":
+		result = "не спам, меры отменены"
+		err = b.undoSpamAction(*incident)
+	case "white":
+		result = "не спам, меры отменены, пользователь в белом списке"
+		if err = b.db.AddSpamWhitelist(incident.ChatID, incident.UserID, query.From.ID); err == nil {
+			err = b.undoSpamAction(*incident)
+		}
+	}
+	if err != nil {
+		log.Printf("[handleIncidentCallback] Инцидент #%d: %v", id, err)
+		b.sendHTML(query.Message.Chat.ID, query.Message.MessageID,
+			fmt.Sprintf("⚠️ Инцидент #%d: решение не применено, попробуйте еще раз\n%s", id, html.EscapeString(err.Error())))
+		b.answerCallback(query, "Не удалось применить решение")
+		return
+	}
+
+	updated, err := b.db.ReviewIncident(id, review, query.From.ID)
+	if err != nil {
+		log.Printf("[handleIncidentCallback] %v", err)
+		b.answerCallback(query, "Не удалось сохранить решение")
+		return
+	}
+	b.removeKeyboard(query.Message.Chat.ID, query.Message.MessageID)
+	if !updated {
+		// Другой администратор успел решить по другой копии отчета
+		b.answerCallback(query, "Инцидент уже проверен")
+		return
+	}
+	log.Printf("[handleIncidentCallback] Инцидент #%d: %s (%s)", id, result, getUserName(query.From))
+
+	b.sendHTML(query.Message.Chat.ID, query.Message.MessageID, fmt.Sprintf("Инцидент #%d: %s - %s", id, result, userLink(query.From)))
+	b.answerCallback(query, result)
+}
+
+// removeKeyboard убирает кнопки у проверенного отчета
+func (b *Bot) removeKeyboard(chatID int64, messageID int) {
+	edit := tgbotapi.NewEditMessageReplyMarkup(chatID, messageID, tgbotapi.InlineKeyboardMarkup{
+		InlineKeyboard: [][]tgbotapi.InlineKeyboardButton{},
+	})
+	if _, err := b.tgBot.Request(edit); err != nil {
+		log.Printf("[removeKeyboard] %v", err)
+	}
+}
+
+// banSpammer блокирует автора подтвержденного спама, если мера была мягче или не применилась
+func (b *Bot) banSpammer(incident db.SpamIncident) error {
+	if incident.Action == "ban" && incident.ActionError == "" {
+		return nil
+	}
+	// Сообщение могло быть уже удалено антиспамом или автором
+	if _, err := b.tgBot.Request(tgbotapi.NewDeleteMessage(incident.ChatID, incident.MessageID)); err != nil {
+		log.Printf("[banSpammer] Сообщение #%d не удалено: %v", incident.MessageID, err)
+	}
+	member := tgbotapi.ChatMemberConfig{ChatID: incident.ChatID, UserID: incident.UserID}
+	if _, err := b.tgBot.Request(tgbotapi.BanChatMemberConfig{ChatMemberConfig: member}); err != nil {
+		return fmt.Errorf("ошибка блокировки пользователя: %v", err)
+	}
+	return nil
+}
+
+// undoSpamAction отменяет меру ложного срабатывания: снимает ограничения и возвращает удаленный текст в чат
+func (b *Bot) undoSpamAction(incident db.SpamIncident) error {
+	user := userLink(incidentUser(incident))
+	if incident.ActionError != "" {
+		// Мера не применилась, отменять нечего
+		return nil
+	}
+
+	member := tgbotapi.ChatMemberConfig{ChatID: incident.ChatID, UserID: incident.UserID}
+	var notice string
+	switch incident.Action {
+	case "warn":
+		b.sendHTML(incident.ChatID, incident.MessageID, fmt.Sprintf("↩️ Предупреждение %s снято: сообщение не спам.", user))
+		return nil
+	case "mute":
+		restrict := tgbotapi.RestrictChatMemberConfig{
+			ChatMemberConfig: member,
+			Permissions: &tgbotapi.ChatPermissions{
+				CanSendMessages:       true,
+				CanSendMediaMessages:  true,
+				CanSendPolls:          true,
+				CanSendOtherMessages:  true,
+				CanAddWebPagePreviews: true,
+				CanChangeInfo:         true,
+				CanInviteUsers:        true,
+				CanPinMessages:        true,
+			},
+		}
+		if _, err := b.tgBot.Request(restrict); err != nil {
+			return fmt.Errorf("ошибка снятия ограничений: %v", err)
+		}
+		notice = fmt.Sprintf("↩️ Ограничения с %s сняты", user)
+	case "ban":
+		if _, err := b.tgBot.Request(tgbotapi.UnbanChatMemberConfig{ChatMemberConfig: member, OnlyIfBanned: true}); err != nil {
+			return fmt.Errorf("ошибка разблокировки пользователя: %v", err)
+		}
+		notice = fmt.Sprintf("↩️ %s разблокирован и может вернуться в чат", user)
+	default:
+		notice = fmt.Sprintf("↩️ Сообщение %s", user)
+	}
+
+	// Неудаленное сообщение осталось в чате, возвращать текст не нужно
+	if !incident.MessageDeleted() {
+		b.sendHTML(incident.ChatID, 0, notice+".")
+		return nil
+	}
+	if incident.Action != "delete" {
+		notice += ", сообщение"
+	}
+	notice += " удалено антиспамом по ошибке:"
+	text := incident.Text
+	if len([]rune(text)) > SPAM_REPORT_TEXT_LEN {
+		text = truncateRunes(text, SPAM_REPORT_TEXT_LEN) + "…"
+	}
+	if text != "" {
+		notice += "\n<blockquote>" + html.EscapeString(text) + "</blockquote>"
+	}
+	b.sendHTML(incident.ChatID, 0, notice)
+	return nil
+}
+
+// handleIncidents обрабатывает команду /incidents (только для администраторов чата)
+func (b *Bot) handleIncidents(message *tgbotapi.Message) {
+	chatID := message.Chat.ID
+	isAdmin, err := b.IsUserAdmin(chatID, message.From.ID)
+	if err != nil {
+		b.sendMessage(chatID, "Ошибка проверки прав администратора")
+		return
+	}
+	if !isAdmin {
+		b.sendMessage(chatID, "Инциденты антиспама доступны только администраторам")
+		return
+	}
+
+	action, args, _ := strings.Cut(strings.TrimSpace(message.CommandArguments()), " ")
+	args = strings.TrimSpace(args)
+	switch strings.ToLower(action) {
+	case "", "list":
+		b.sendIncidentsPage(message)
+	case "stats":
+		b.sendIncidentStats(message)
+	case "whitelist":
+		b.sendSpamWhitelist(message)
+	case "unwhitelist":
+		userID, err := strconv.ParseInt(args, 10, 64)
+		if err != nil {
+			b.sendMessage(chatID, INCIDENTS_USAGE)
+			return
+		}
+		removed, err := b.db.RemoveSpamWhitelist(chatID, userID)
+		if err != nil {
+			log.Printf("[handleIncidents] %v", err)
+			b.sendMessage(chatID, "Не удалось изменить белый список")
+			return
+		}
+		if !removed {
+			b.sendMessage(chatID, fmt.Sprintf("Пользователя %d нет в белом списке", userID))
+			return
+		}
+		b.sendMessage(chatID, fmt.Sprintf("Пользователь %d снова проверяется антиспамом", userID))
+	default:
+		id, err := strconv.ParseInt(strings.TrimPrefix(action, "#"), 10, 64)
+		if err != nil {
+			b.sendMessage(chatID, INCIDENTS_USAGE)
+			return
+		}
+		incident, err := b.db.GetIncident(id)
+		if err != nil {
+			log.Printf("[handleIncidents] %v", err)
+		}
+		if incident == nil || incident.ChatID != chatID {
+			b.sendMessage(chatID, fmt.Sprintf("Инцидента #%d в этом чате нет", id))
+			return
+		}
+		b.sendIncidentReport(chatID, message.MessageID, *incident)
+	}
+}
+
+// sendIncidentsPage отправляет первую страницу инцидентов чата
+func (b *Bot) sendIncidentsPage(message *tgbotapi.Message) {
+	chatID := message.Chat.ID
+	text, keyboard, err := b.incidentsPage(chatID, 0)
+	if err != nil {
+		b.sendMessage(chatID, err.Error())
+		return
+	}
+
+	msg := tgbotapi.NewMessage(chatID, text)
+	msg.ParseMode = tgbotapi.ModeHTML
+	msg.DisableWebPagePreview = true
+	msg.ReplyToMessageID = message.MessageID
+	if keyboard != nil {
+		msg.ReplyMarkup = keyboard
+	}
+	if _, err := b.tgBot.Send(msg); err != nil {
+		log.Printf("[sendIncidentsPage] Ошибка отправки инцидентов: %v", err)
+	}
+}
+
+// incidentsPage текст и кнопки страницы /incidents, page с 0
+func (b *Bot) incidentsPage(chatID int64, page int) (string, *tgbotapi.InlineKeyboardMarkup, error) {
+	incidents, total, err := b.db.GetIncidents(chatID, INCIDENTS_PAGE_SIZE, page*INCIDENTS_PAGE_SIZE)
+	if err != nil {
+		log.Printf("[incidentsPage] %v", err)
+		return "", nil, fmt.Errorf("не удалось получить инциденты")
+	}
+	if total == 0 {
+		return "🛡 Инцидентов антиспама в этом чате нет", nil, nil
+	}
+	pages := (total + INCIDENTS_PAGE_SIZE - 1) / INCIDENTS_PAGE_SIZE
+	if len(incidents) == 0 {
+		return "", nil, fmt.Errorf("страница %d не найдена", page+1)
+	}
+
+	loc := b.chatConfig(chatID).Location()
+	var text strings.Builder
+	text.WriteString(fmt.Sprintf("🛡 Инциденты антиспама: %d, страница %d из %d\n", total, page+1, pages))
+	for _, incident := range incidents {
+		status := "⏳ не проверен"
+		if incident.Review != "" {
+			status = INCIDENT_REVIEWS[incident.Review]
+		}
+		text.WriteString(fmt.Sprintf("\n<b>#%d</b> %s, %s\n%s, мера: %s, %s\n",
+			incident.ID, time.Unix(incident.Timestamp, 0).In(loc).Format("02.01.2006 15:04"), userLink(incidentUser(incident)),
+			html.EscapeString(incident.Reason), SPAM_ACTIONS[incident.Action], status))
+		snippet := strings.Join(strings.Fields(incident.Text), " ")
+		if len([]rune(snippet)) > INCIDENT_SNIPPET_LEN {
+			snippet = truncateRunes(snippet, INCIDENT_SNIPPET_LEN) + "…"
+		}
+		if snippet != "" {
+			text.WriteString("<i>" + html.EscapeString(snippet) + "</i>\n")
+		}
+	}
+	text.WriteString("\nПроверить: /incidents &lt;id&gt;")
+
+	if pages == 1 {
+		return text.String(), nil, nil
+	}
+	var row []tgbotapi.InlineKeyboardButton
+	if page > 0 {
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData("◀️ Назад", INCIDENTS_CALLBACK_PREFIX+strconv.Itoa(page-1)))
+	}
+	if page+1 < pages {
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData("Вперед ▶️", INCIDENTS_CALLBACK_PREFIX+strconv.Itoa(page+1)))
+	}
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(row)
+	return text.String(), &keyboard, nil
+}
+
+// handleIncidentsCallback листает страницы /incidents
+func (b *Bot) handleIncidentsCallback(query *tgbotapi.CallbackQuery) {
+	chatID := query.Message.Chat.ID
+	page, err := strconv.Atoi(strings.TrimPrefix(query.Data, INCIDENTS_CALLBACK_PREFIX))
+	if err != nil || page < 0 {
+		b.answerCallback(query, "Список устарел, повторите /incidents")
+		return
+	}
+	isAdmin, err := b.IsUserAdmin(chatID, query.From.ID)
+	if err != nil || !isAdmin {
+		b.answerCallback(query, "Инциденты антиспама доступны только администраторам")
+		return
+	}
+
+	text, keyboard, err := b.incidentsPage(chatID, page)
+	if err != nil {
+		b.answerCallback(query, err.Error())
+		return
+	}
+	edit := tgbotapi.NewEditMessageText(chatID, query.Message.MessageID, text)
+	edit.ParseMode = tgbotapi.ModeHTML
+	edit.DisableWebPagePreview = true
+	edit.ReplyMarkup = keyboard
+	if _, err := b.tgBot.Request(edit); err != nil {
+		log.Printf("[handleIncidentsCallback] Ошибка обновления списка: %v", err)
+	}
+	b.answerCallback(query, "")
+}
+
+// sendIncidentStats показывает, как часто срабатывания подтверждаются администраторами
+func (b *Bot) sendIncidentStats(message *tgbotapi.Message) {
+	chatID := message.Chat.ID
+	stats, err := b.db.GetIncidentStats(chatID, time.Now().Add(-INCIDENT_STATS_PERIOD).Unix())
+	if err != nil {
+		log.Printf("[sendIncidentStats] %v", err)
+		b.sendMessage(chatID, "Не удалось получить статистику инцидентов")
+		return
+	}
+	if len(stats) == 0 {
+		b.sendMessage(chatID, fmt.Sprintf("За %d дней инцидентов не было", int(INCIDENT_STATS_PERIOD.Hours()/24)))
+		return
+	}
+
+	var text strings.Builder
+	text.WriteString(fmt.Sprintf("📊 Срабатывания антиспама за %d дней\n", int(INCIDENT_STATS_PERIOD.Hours()/24)))
+	text.WriteString("всего / подтверждено / ложных, точность - доля подтвержденных среди проверенных\n")
+	for _, s := range stats {
+		source := SPAM_SOURCES[s.Source]
+		if source == "" {
+			source = "без источника"
+		}
+		category := s.Category
+		if category == "" {
+			category = "без категории"
+		}
+		text.WriteString(fmt.Sprintf("\n<b>%s</b>: %s - %d / %d / %d", html.EscapeString(source), html.EscapeString(category),
+			s.Total, s.Confirmed, s.FalsePositive))
+		if reviewed := s.Confirmed + s.FalsePositive; reviewed > 0 {
+			text.WriteString(fmt.Sprintf(", точность %d%%", s.Confirmed*100/reviewed))
+		}
+	}
+	b.sendHTML(chatID, message.MessageID, text.String())
+}
+
+// sendSpamWhitelist показывает белый список антиспама чата
+func (b *Bot) sendSpamWhitelist(message *tgbotapi.Message) {
+	chatID := message.Chat.ID
+	users, err := b.db.GetSpamWhitelist(chatID)
+	if err != nil {
+		log.Printf("[sendSpamWhitelist] %v", err)
+		b.sendMessage(chatID, "Не удалось получить белый список")
+		return
+	}
+	if len(users) == 0 {
+		b.sendMessage(chatID, "Белый список антиспама пуст. Добавить можно кнопкой в отчете об инциденте.")
+		return
+	}
+
+	var text strings.Builder
+	text.WriteString("🤍 Белый список антиспама:\n")
+	for _, u := range users {
+		user := &tgbotapi.User{ID: u.UserID, UserName: u.Username, FirstName: u.FirstName, LastName: u.LastName}
+		text.WriteString(fmt.Sprintf("\n%s, id <code>%d</code>", userLink(user), u.UserID))
+	}
+	text.WriteString("\n\nУбрать: /incidents unwhitelist &lt;user_id&gt;")
+	b.sendHTML(chatID, message.MessageID, text.String())
+}
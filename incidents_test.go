@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"facilitatorbot/db"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// incidentCallback нажатие кнопки action отчета об инциденте id администратором adminID
+func incidentCallback(id, adminID int64, action string) *tgbotapi.CallbackQuery {
+	return &tgbotapi.CallbackQuery{
+		ID:      fmt.Sprintf("%s-%d", action, adminID),
+		From:    &tgbotapi.User{ID: adminID, FirstName: "Admin"},
+		Message: &tgbotapi.Message{MessageID: 100, Chat: &tgbotapi.Chat{ID: TEST_CHAT_ID, Type: "supergroup"}},
+		Data:    fmt.Sprintf("%s%s:%d", INCIDENT_CALLBACK_PREFIX, action, id),
+	}
+}
+
+func TestIncidentCallbackClaim(t *testing.T) {
+	const OTHER_ADMIN_ID = 233088195 // второй из allowedAdmins
+
+	bot, stub := newTestBot(t, NewFakeLLMProvider(), defaultConfig())
+	id, err := bot.db.SaveIncident(db.SpamIncident{
+		ChatID: TEST_CHAT_ID, UserID: 2, MessageID: 10, Text: "спам", Reason: "тест", Source: SPAM_SOURCE_RULES,
+		Strike: 1, Action: "mute", Timestamp: time.Now().Unix(),
+	})
+	if err != nil {
+		t.Fatalf("SaveIncident: %v", err)
+	}
+
+	// Бан задерживается, пока второй администратор нажимает "не спам" в другой копии отчета
+	banStarted, banRelease := make(chan struct{}), make(chan struct{})
+	stub.before = func(method string) {
+		if method == "banChatMember" {
+			close(banStarted)
+			<-banRelease
+		}
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		bot.handleIncidentCallback(incidentCallback(id, TEST_ADMIN_ID, "ban"))
+	}()
+	<-banStarted
+
+	bot.handleIncidentCallback(incidentCallback(id, OTHER_ADMIN_ID, "undo"))
+	if n := len(stub.sent("restrictChatMember")); n != 0 {
+		t.Errorf("меры отменены во время бана: %d запросов restrictChatMember", n)
+	}
+	close(banRelease)
+	<-done
+
+	incident, err := bot.db.GetIncident(id)
+	if err != nil || incident == nil {
+		t.Fatalf("GetIncident: %v", err)
+	}
+	if incident.Review != db.INCIDENT_CONFIRMED || incident.ReviewedBy != TEST_ADMIN_ID {
+		t.Errorf("решение %q от %d, ожидалось %q от %d", incident.Review, incident.ReviewedBy, db.INCIDENT_CONFIRMED, TEST_ADMIN_ID)
+	}
+
+	answers := make(map[string]string)
+	for _, request := range stub.sent("answerCallbackQuery") {
+		answers[request.Params["callback_query_id"]] = request.Params["text"]
+	}
+	if answer := answers[fmt.Sprintf("undo-%d", OTHER_ADMIN_ID)]; answer != "По инциденту уже принимается решение" {
+		t.Errorf("ответ второму администратору %q", answer)
+	}
+
+	// Захват снят, повторное нажатие видит сохраненное решение
+	bot.handleIncidentCallback(incidentCallback(id, OTHER_ADMIN_ID, "undo"))
+	if n := len(stub.sent("restrictChatMember")); n != 0 {
+		t.Errorf("меры отменены после бана: %d запросов restrictChatMember", n)
+	}
+	if !bot.incidents.claim(id) {
+		t.Errorf("инцидент остался захвачен")
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+)
+
+const CHARS_PER_TOKEN = 3 // грубая оценка без токенизатора: кириллица занимает больше токенов, чем латиница, берем с запасом
+
+// LLMMessage сообщение диалога, передаваемое в LLM
+type LLMMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// LLMOptions параметры генерации
+type LLMOptions struct {
+	Model       string
+	Temperature float64
+	MaxTokens   int
+}
+
+// LLMUsage информация об использованных токенах
+type LLMUsage struct {
+	PromptTokens     int
+	CompletionTokens int
+	TotalTokens      int
+}
+
+// LLMResult результат генерации
+type LLMResult struct {
+	Content string
+	Model   string
+	Usage   LLMUsage
+}
+
+// LLMProvider бэкенд генерации текста (OpenAI-совместимый, Ollama, фейковый для тестов)
+type LLMProvider interface {
+	// Name возвращает имя провайдера для логов
+	Name() string
+	// Complete выполняет один запрос к модели без повторов
+	Complete(ctx context.Context, messages []LLMMessage, opts LLMOptions) (*LLMResult, error)
+}
+
+// LLMStreamer провайдер, умеющий отдавать ответ по мере генерации.
+// onDelta вызывается на каждый полученный фрагмент текста.
+type LLMStreamer interface {
+	Stream(ctx context.Context, messages []LLMMessage, opts LLMOptions, onDelta func(string)) (*LLMResult, error)
+}
+
+// NewLLMProvider создает провайдера по имени из AI_PROVIDER
+func NewLLMProvider(kind, url string, client *http.Client) (LLMProvider, error) {
+	switch strings.ToLower(strings.TrimSpace(kind)) {
+	case "", "openai":
+		return NewOpenAIProvider(url, client), nil
+	case "ollama":
+		return NewOllamaProvider(url, client), nil
+	case "fake":
+		return NewFakeLLMProvider(), nil
+	default:
+		return nil, fmt.Errorf("неизвестный AI_PROVIDER: %q", kind)
+	}
+}
+
+// estimateTokens грубая оценка количества токенов в тексте
+func estimateTokens(text string) int {
+	return utf8.RuneCountInString(text)/CHARS_PER_TOKEN + 1
+}
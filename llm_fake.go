@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// FakeLLMProvider детерминированный провайдер для тестов и локальной отладки без модели.
+// Возвращает заранее заданные ответы по кругу, а если их нет — эхо последнего сообщения.
+type FakeLLMProvider struct {
+	mu        sync.Mutex
+	Responses []string
+	Calls     [][]LLMMessage // история запросов для проверок в тестах
+}
+
+// NewFakeLLMProvider создает фейкового провайдера с заданными ответами
+func NewFakeLLMProvider(responses ...string) *FakeLLMProvider {
+	return &FakeLLMProvider{Responses: responses}
+}
+
+func (p *FakeLLMProvider) Name() string {
+	return "fake"
+}
+
+// Complete возвращает следующий ответ без обращения к сети
+func (p *FakeLLMProvider) Complete(ctx context.Context, messages []LLMMessage, opts LLMOptions) (*LLMResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.Calls = append(p.Calls, append([]LLMMessage(nil), messages...))
+
+	var content string
+	if len(p.Responses) > 0 {
+		content = p.Responses[(len(p.Calls)-1)%len(p.Responses)]
+	} else if len(messages) > 0 {
+		content = fmt.Sprintf("fake: %s", messages[len(messages)-1].Content)
+	}
+
+	// Грубая оценка токенов
+	promptTokens := 0
+	for _, msg := range messages {
+		promptTokens += estimateTokens(msg.Content)
+	}
+	completionTokens := estimateTokens(content)
+
+	model := opts.Model
+	if model == "" {
+		model = "fake"
+	}
+
+	return &LLMResult{
+		Content: content,
+		Model:   model,
+		Usage: LLMUsage{
+			PromptTokens:     promptTokens,
+			CompletionTokens: completionTokens,
+			TotalTokens:      promptTokens + completionTokens,
+		},
+	}, nil
+}
+
+// Stream отдает ответ Complete по словам
+func (p *FakeLLMProvider) Stream(ctx context.Context, messages []LLMMessage, opts LLMOptions, onDelta func(string)) (*LLMResult, error) {
+	result, err := p.Complete(ctx, messages, opts)
+	if err != nil {
+		return nil, err
+	}
+	for _, word := range strings.SplitAfter(result.Content, " ") {
+		onDelta(word)
+	}
+	return result, nil
+}
+
+// CallCount возвращает количество выполненных запросов
+func (p *FakeLLMProvider) CallCount() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.Calls)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// ollamaChatRequest структура запроса к нативному /api/chat Ollama
+type ollamaChatRequest struct {
+	Model    string       `json:"model"`
+	Messages []LLMMessage `json:"messages"`
+	Stream   bool         `json:"stream"`
+	Options  struct {
+		Temperature float64 `json:"temperature,omitempty"`
+		NumPredict  int     `json:"num_predict,omitempty"`
+	} `json:"options"`
+}
+
+// ollamaChatResponse структура ответа /api/chat Ollama
+type ollamaChatResponse struct {
+	Model   string     `json:"model"`
+	Message LLMMessage `json:"message"`
+	Done    bool       `json:"done"`
+	// Ollama считает токены отдельно для промпта и ответа
+	PromptEvalCount int    `json:"prompt_eval_count"`
+	EvalCount       int    `json:"eval_count"`
+	Error           string `json:"error"`
+}
+
+// OllamaProvider провайдер для нативного API Ollama (http://localhost:11434/api/chat)
+type OllamaProvider struct {
+	url    string
+	client *http.Client
+}
+
+// NewOllamaProvider создает провайдера Ollama
+func NewOllamaProvider(url string, client *http.Client) *OllamaProvider {
+	return &OllamaProvider{url: url, client: client}
+}
+
+func (p *OllamaProvider) Name() string {
+	return "ollama"
+}
+
+// Complete отправляет запрос в /api/chat без стриминга
+func (p *OllamaProvider) Complete(ctx context.Context, messages []LLMMessage, opts LLMOptions) (*LLMResult, error) {
+	request := ollamaChatRequest{
+		Model:    opts.Model,
+		Messages: messages,
+		Stream:   false,
+	}
+	request.Options.Temperature = opts.Temperature
+	request.Options.NumPredict = opts.MaxTokens
+
+	jsonData, err := json.Marshal(request)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка маршалинга запроса: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, bytes.NewReader(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("ошибка создания HTTP запроса: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка HTTP запроса: %v", err)
+	}
+	defer resp.Body.Close()
+
+	limitReader := &io.LimitedReader{R: resp.Body, N: 10 * 1024 * 1024}
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(&io.LimitedReader{R: limitReader, N: 4096})
+		return nil, newLLMStatusError(resp, string(body))
+	}
+
+	var response ollamaChatResponse
+	if err := json.NewDecoder(limitReader).Decode(&response); err != nil {
+		return nil, fmt.Errorf("ошибка декодирования ответа: %v", err)
+	}
+
+	if response.Error != "" {
+		return nil, fmt.Errorf("ошибка Ollama: %s", response.Error)
+	}
+	if response.Message.Content == "" {
+		return nil, fmt.Errorf("пустой ответ от LLM")
+	}
+
+	return &LLMResult{
+		Content: response.Message.Content,
+		Model:   response.Model,
+		Usage: LLMUsage{
+			PromptTokens:     response.PromptEvalCount,
+			CompletionTokens: response.EvalCount,
+			TotalTokens:      response.PromptEvalCount + response.EvalCount,
+		},
+	}, nil
+}
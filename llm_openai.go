@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// LocalLLMRequest структура запроса к OpenAI-совместимому API
+type LocalLLMRequest struct {
+	Model       string       `json:"model"`
+	Messages    []LLMMessage `json:"messages"`
+	Temperature float64      `json:"temperature,omitempty"`
+	MaxTokens   int          `json:"max_tokens,omitempty"`
+	Stream      bool         `json:"stream,omitempty"`
+	// StreamOptions просим прислать usage последним чанком потока
+	StreamOptions *struct {
+		IncludeUsage bool `json:"include_usage"`
+	} `json:"stream_options,omitempty"`
+}
+
+// LocalLLMResponse структура ответа от OpenAI-совместимого API
+type LocalLLMResponse struct {
+	Choices []struct {
+		Message struct {
+			Content string `json:"content"`
+		} `json:"message"`
+	} `json:"choices"`
+	Model string `json:"model"`
+	Usage struct {
+		CompletionTokens        int `json:"completion_tokens"`
+		CompletionTokensDetails struct {
+			AcceptedPredictionTokens int `json:"accepted_prediction_tokens"`
+			AudioTokens              int `json:"audio_tokens"`
+			ReasoningTokens          int `json:"reasoning_tokens"`
+			RejectedPredictionTokens int `json:"rejected_prediction_tokens"`
+		} `json:"completion_tokens_details"`
+		PromptTokens        int `json:"prompt_tokens"`
+		PromptTokensDetails struct {
+			AudioTokens  int `json:"audio_tokens"`
+			CachedTokens int `json:"cached_tokens"`
+		} `json:"prompt_tokens_details"`
+		TotalTokens int `json:"total_tokens"`
+	} `json:"usage"`
+}
+
+// openAIStreamChunk один SSE чанк потокового ответа
+type openAIStreamChunk struct {
+	Model   string `json:"model"`
+	Choices []struct {
+		Delta struct {
+			Content string `json:"content"`
+		} `json:"delta"`
+	} `json:"choices"`
+	Usage *struct {
+		PromptTokens     int `json:"prompt_tokens"`
+		CompletionTokens int `json:"completion_tokens"`
+		TotalTokens      int `json:"total_tokens"`
+	} `json:"usage"`
+}
+
+// OpenAIProvider провайдер для /v1/chat/completions (LM Studio, vLLM, OpenAI и т.п.)
+type OpenAIProvider struct {
+	url    string
+	client *http.Client
+}
+
+// NewOpenAIProvider создает OpenAI-совместимого провайдера
+func NewOpenAIProvider(url string, client *http.Client) *OpenAIProvider {
+	return &OpenAIProvider{url: url, client: client}
+}
+
+func (p *OpenAIProvider) Name() string {
+	return "openai"
+}
+
+// Complete отправляет запрос chat completions и разбирает ответ
+func (p *OpenAIProvider) Complete(ctx context.Context, messages []LLMMessage, opts LLMOptions) (*LLMResult, error) {
+	resp, err := p.post(ctx, LocalLLMRequest{
+		Model:       opts.Model,
+		Messages:    messages,
+		Temperature: opts.Temperature,
+		MaxTokens:   opts.MaxTokens,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	return decodeOpenAIResponse(resp.Body)
+}
+
+// Stream отправляет запрос с stream: true и читает SSE поток, вызывая onDelta на каждый фрагмент текста.
+// Если бэкенд проигнорировал stream и вернул обычный JSON, весь ответ передается одним фрагментом.
+func (p *OpenAIProvider) Stream(ctx context.Context, messages []LLMMessage, opts LLMOptions, onDelta func(string)) (*LLMResult, error) {
+	request := LocalLLMRequest{
+		Model:       opts.Model,
+		Messages:    messages,
+		Temperature: opts.Temperature,
+		MaxTokens:   opts.MaxTokens,
+		Stream:      true,
+	}
+	request.StreamOptions = &struct {
+		IncludeUsage bool `json:"include_usage"`
+	}{IncludeUsage: true}
+
+	resp, err := p.post(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		result, err := decodeOpenAIResponse(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		onDelta(result.Content)
+		return result, nil
+	}
+
+	result := &LLMResult{}
+	var content strings.Builder
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+		data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		if data == "[DONE]" {
+			break
+		}
+
+		var chunk openAIStreamChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return nil, fmt.Errorf("ошибка декодирования чанка: %v", err)
+		}
+
+		if chunk.Model != "" {
+			result.Model = chunk.Model
+		}
+		if chunk.Usage != nil {
+			result.Usage = LLMUsage{
+				PromptTokens:     chunk.Usage.PromptTokens,
+				CompletionTokens: chunk.Usage.CompletionTokens,
+				TotalTokens:      chunk.Usage.TotalTokens,
+			}
+		}
+		for _, choice := range chunk.Choices {
+			if choice.Delta.Content == "" {
+				continue
+			}
+			content.WriteString(choice.Delta.Content)
+			onDelta(choice.Delta.Content)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("ошибка чтения потока: %w", err)
+	}
+
+	if content.Len() == 0 {
+		return nil, fmt.Errorf("пустой ответ от LLM")
+	}
+	result.Content = content.String()
+	return result, nil
+}
+
+// post отправляет запрос и проверяет статус ответа
+func (p *OpenAIProvider) post(ctx context.Context, request LocalLLMRequest) (*http.Response, error) {
+	jsonData, err := json.Marshal(request)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка маршалинга запроса: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, bytes.NewReader(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("ошибка создания HTTP запроса: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка HTTP запроса: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(&io.LimitedReader{R: resp.Body, N: 4096})
+		return nil, newLLMStatusError(resp, string(body))
+	}
+
+	return resp, nil
+}
+
+// decodeOpenAIResponse разбирает обычный (не потоковый) ответ chat completions
+func decodeOpenAIResponse(body io.Reader) (*LLMResult, error) {
+	// Создаем ограниченный Reader для предотвращения утечки памяти
+	limitReader := &io.LimitedReader{
+		R: body,
+		N: 10 * 1024 * 1024, // Ограничиваем чтение 10MB
+	}
+
+	var response LocalLLMResponse
+	if err := json.NewDecoder(limitReader).Decode(&response); err != nil {
+		return nil, fmt.Errorf("ошибка декодирования ответа: %v", err)
+	}
+
+	if len(response.Choices) == 0 {
+		return nil, fmt.Errorf("пустой ответ от LLM")
+	}
+
+	return &LLMResult{
+		Content: response.Choices[0].Message.Content,
+		Model:   response.Model,
+		Usage: LLMUsage{
+			PromptTokens:     response.Usage.PromptTokens,
+			CompletionTokens: response.Usage.CompletionTokens,
+			TotalTokens:      response.Usage.TotalTokens,
+		},
+	}, nil
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LLMStatusError ответ бэкенда с кодом отличным от 200
+type LLMStatusError struct {
+	StatusCode int
+	Body       string
+	RetryAfter time.Duration // значение заголовка Retry-After, 0 если не было
+}
+
+func (e *LLMStatusError) Error() string {
+	return fmt.Sprintf("неверный статус код %d: %s", e.StatusCode, e.Body)
+}
+
+// newLLMStatusError собирает ошибку статуса с учетом заголовка Retry-After
+func newLLMStatusError(resp *http.Response, body string) *LLMStatusError {
+	return &LLMStatusError{
+		StatusCode: resp.StatusCode,
+		Body:       body,
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+	}
+}
+
+// parseRetryAfter разбирает Retry-After в секундах или в формате HTTP-даты
+func parseRetryAfter(value string) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		if d := time.Until(at); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+// RetryPolicy политика повторов запросов к LLM
+type RetryPolicy struct {
+	MaxAttempts     int           // всего попыток, включая первую
+	BaseDelay       time.Duration // задержка перед второй попыткой, далее удваивается
+	MaxDelay        time.Duration // верхняя граница задержки (в т.ч. для Retry-After)
+	Jitter          float64       // доля случайного разброса задержки, 0..1
+	RetryableStatus map[int]bool  // HTTP статусы, при которых имеет смысл повторять
+}
+
+// DefaultRetryPolicy политика по умолчанию: 3 попытки, 429 и 5xx
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:     3,
+		BaseDelay:       2 * time.Second,
+		MaxDelay:        60 * time.Second,
+		Jitter:          0.2,
+		RetryableStatus: parseStatusCodes("429,500,502,503,504"),
+	}
+}
+
+// parseStatusCodes парсит список статусов через запятую
+func parseStatusCodes(value string) map[int]bool {
+	result := make(map[int]bool)
+	for _, part := range strings.Split(value, ",") {
+		code, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil {
+			if strings.TrimSpace(part) != "" {
+				log.Printf("Ошибка парсинга HTTP статуса %q: %v", part, err)
+			}
+			continue
+		}
+		result[code] = true
+	}
+	return result
+}
+
+// retryable решает, стоит ли повторять запрос после ошибки
+func (p RetryPolicy) retryable(err error) bool {
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	var statusErr *LLMStatusError
+	if errors.As(err, &statusErr) {
+		return p.RetryableStatus[statusErr.StatusCode]
+	}
+	// Сетевые ошибки, таймауты и пустые ответы повторяем
+	return true
+}
+
+// delay вычисляет паузу перед попыткой attempt (начиная с 1 для второй попытки)
+func (p RetryPolicy) delay(attempt int, err error) time.Duration {
+	d := p.BaseDelay << uint(attempt-1)
+
+	var statusErr *LLMStatusError
+	if errors.As(err, &statusErr) && statusErr.RetryAfter > 0 {
+		d = statusErr.RetryAfter
+	} else if p.Jitter > 0 {
+		d += time.Duration(float64(d) * p.Jitter * (rand.Float64()*2 - 1))
+	}
+
+	if p.MaxDelay > 0 && d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	return d
+}
+
+// Do выполняет fn с повторами согласно политике. Ожидание между попытками
+// прерывается отменой ctx, поэтому /cancel срабатывает сразу.
+func (p RetryPolicy) Do(ctx context.Context, name string, fn func(ctx context.Context) error) error {
+	attempts := max(p.MaxAttempts, 1)
+
+	var err error
+	for attempt := 0; attempt < attempts; attempt++ {
+		if attempt > 0 {
+			wait := p.delay(attempt, err)
+			log.Printf("[%s] Повтор через %v", name, wait)
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(wait):
+			}
+		}
+
+		log.Printf("[%s] Попытка %d/%d", name, attempt+1, attempts)
+		err = fn(ctx)
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		log.Printf("[%s] Ошибка (попытка %d): %v", name, attempt+1, err)
+		if !p.retryable(err) {
+			return err
+		}
+	}
+
+	return fmt.Errorf("все %d попытки завершились неудачей: %w", attempts, err)
+}
@@ -3,14 +3,19 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"facilitatorbot/db"
@@ -30,80 +35,36 @@ const IGNORE_OLD_MSG_MIN = 15  // игнорируем старые сообще
 const LOG_FILENAME = "tg_bot.log"
 const LOG_DIR = "logs"
 
-// Config структура для конфигурации бота
-type Config struct {
-	TelegramToken        string
-	LocalLLMUrl          string // URL локальной LLM (например "http://localhost:1234/v1/chat/completions")
-	AiModelName          string
-	AllowedGroups        []int64
-	SummaryPrompt        string
-	SystemPrompt         string
-	AnekdotPrompt        string
-	TopicPrompt          string
-	ReplyPrompt          string
-	ImagePrompt          string
-	HistoryDays          int                // Сколько дней хранить историю
-	DBPath               string             // Путь к файлу SQLite
-	ContextMessageLimit  int                // размер хранения контекста сообщений от пользователя
-	ContextTimeLimit     int                // размер в часах хранения контекста
-	ContextRetentionDays int                //удаление контекста диалога с пользователем из БД
-	TokenCosts           map[string]float64 // стоимость токенов для разных моделей
-	AIImageURL           string             // URL для генерации изображений
-}
-
 // Bot структура основного бота
 type Bot struct {
-	config         Config
+	conf           atomic.Pointer[Config] // текущая конфигурация, подменяется при перезагрузке
+	reloadMu       sync.Mutex             // перезагрузки конфигурации выполняются по одной
 	tgBot          *tgbotapi.BotAPI
 	httpClient     *http.Client
 	db             *db.DB
 	captchaManager *module.CaptchaManager
+	llm            LLMProvider
+	embedder       EmbeddingProvider  // векторы для /search, nil - поиск выключен
+	aiJobs         *aiJobs            // выполняющиеся генерации для /cancel
+	dispatcher     *Dispatcher        // параллельная обработка чатов с сохранением порядка внутри чата
+	workCtx        context.Context    // родительский контекст AI и image задач
+	cancelWork     context.CancelFunc // отменяет задачи, не успевшие завершиться при остановке
+	background     sync.WaitGroup     // фоновые циклы очистки БД
 	//chatHistories map[int64][]ChatMessage // История сообщений по чатам
-	lastSummary map[int64]time.Time // Время последней сводки по чатам
-}
-
-// LocalLLMRequest структура запроса к локальной LLM
-type LocalLLMRequest struct {
-	Model       string            `json:"model"`
-	Messages    []LocalLLMMessage `json:"messages"`
-	Temperature float64           `json:"temperature,omitempty"`
-	MaxTokens   int               `json:"max_tokens,omitempty"`
-}
-
-// LocalLLMMessage структура сообщения для LLM
-type LocalLLMMessage struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
-}
-
-// LocalLLMResponse структура ответа от LLM
-type LocalLLMResponse struct {
-	Choices []struct {
-		Message struct {
-			Content string `json:"content"`
-		} `json:"message"`
-	} `json:"choices"`
-	Model string `json:"model"`
-	Usage struct {
-		CompletionTokens        int `json:"completion_tokens"`
-		CompletionTokensDetails struct {
-			AcceptedPredictionTokens int `json:"accepted_prediction_tokens"`
-			AudioTokens              int `json:"audio_tokens"`
-			ReasoningTokens          int `json:"reasoning_tokens"`
-			RejectedPredictionTokens int `json:"rejected_prediction_tokens"`
-		} `json:"completion_tokens_details"`
-		PromptTokens        int `json:"prompt_tokens"`
-		PromptTokensDetails struct {
-			AudioTokens  int `json:"audio_tokens"`
-			CachedTokens int `json:"cached_tokens"`
-		} `json:"prompt_tokens_details"`
-		TotalTokens int `json:"total_tokens"`
-	} `json:"usage"`
+	lastSummary  *summaryTimes         // Время последней сводки по чатам
+	settings     *chatSettingsStore    // настройки чатов из /settings
+	spamRules    *spamRulesStore       // скомпилированные правила антиспама по чатам
+	spamVerdicts *spamVerdictCache     // вердикты модели о спаме по тексту сообщения
+	flood        *module.FloodDetector // отпечатки недавних сообщений всех чатов для поиска флуда
+	topics       *messageTopics        // темы форума входящих сообщений
+	incidents    *incidentClaims       // инциденты, по которым администратор сейчас принимает решение
+	updateOffset atomic.Int64          // offset getUpdates, сохраняется между переподключениями
 }
 
 func main() {
 	// Настройка логирования
-	setupLogger()
+	logWriter := setupLogger()
+	defer logWriter.Close()
 
 	// Логируем информацию о версии
 	if BuildDate != "" {
@@ -119,43 +80,10 @@ func main() {
 		log.Printf("Ошибка загрузки .env файла: %v (продолжаем с переменными окружения)", err)
 	}
 
-	// Загрузка конфигурации
-	config := Config{
-		TelegramToken:        getEnv("TELEGRAM_BOT_TOKEN", ""),
-		LocalLLMUrl:          getEnv("AI_LOCAL_LLM_URL", "http://localhost:1234/v1/chat/completions"),
-		AiModelName:          getEnv("AI_MODEL", ""),
-		AllowedGroups:        parseAllowedGroups(getEnv("ALLOWED_GROUPS", "")),
-		HistoryDays:          30, //DB save msg days
-		ContextMessageLimit:  10,
-		ContextTimeLimit:     4,
-		ContextRetentionDays: 7,
-		DBPath:               getEnv("DB_PATH", "telegram_bot.db"),
-		AIImageURL:           getEnv("AI_IMAGE_URL", "https://image.pollinations.ai/prompt/"),
-		SummaryPrompt:        "Generate concise Russian summary of discussion. Highlight key topics. Format authors as name(@username). Use only these messages:\n%s\nReply in Russian. Sometimes mention the time hour of messages.",
-		SystemPrompt:         "You're an AI assistant that creates concise Russian summaries of chat discussions. Identify main topics and essence. Always reply in Russian. Do not answer think.",
-		AnekdotPrompt:        "Using these messages, create a short funny joke in Russian, loosely related to discussion. Format as one cohesive text. Don't use usernames:\n%s\nReply in Russian only.",
-		TopicPrompt:          "Using these messages, create a short, funny discussion topic in Russian, loosely related to the previous conversation. Format it as one cohesive text. Add start topic question of disscussion. Do not use usernames:\n%s\nReply in Russian only.",
-		//ReplyPrompt:          "Create a ansver for user question. Format it as one cohesive text. Do not use usernames:\n%s\nReply in if user ask Russian and reply another language if user ask.",
-		ReplyPrompt: `Ты — AI-собеседник по имени "Шерифф". Твой стиль общения: дружелюбный, вежливый, поддерживающий и немного разговорный. Ты стремишься быть максимально полезным, даешь подробные и обоснованные ответы, а также проявляешь искренний интерес к диалогу.
-Критически важные инструкции для каждого твоего ответа:
-1. **Язык:** Всегда отвечай на том же языке, на котором пользователь написал свое сообщение. Не переключай языки произвольно.
-2. **Формат ответа:** Ответ должен быть единым, связным и хорошо структурированным текстом. Не используй маркеры списка (например, - / *), если об этом не попросили явно.
-3. **Обращения:** Не используй в ответе username'ы (например, "Пользователь:", "Дорогой пользователь" и т.д.). Веди диалог так, как будто это естественная беседа.
-4. **Участие:** Поддержи беседу. Если уместно, задай уточняющий или встречный вопрос, чтобы диалог продолжался.
-5. **Без предупреждений:** Не начинай ответ с таких фраз, как "Как AI, я...", "Я не человек, но...". Просто дай лучший возможный ответ.
-
-Проанализируй последнее сообщение пользователя и продолжай диалог:
-"%s"`,
-		ImagePrompt: "A cartoonish атипичный black wolf with big, expressive eyes and sharp teeth, dynamically posing while holding random objects. The wolf looks slightly confused or nervous. Simple gray background with subtle rain streaks. Stylized as a humorous comic—flat colors, bold outlines, exaggerated expressions. Add top right copyright eng text `(с)wrwfx`,",
-		TokenCosts: map[string]float64{
-			"deepseek": 0.0001,
-			"openai":   0.001,
-		},
-	}
-
-	// Проверка обязательных переменных
-	if config.TelegramToken == "" {
-		log.Fatal("TELEGRAM_BOT_TOKEN не установлен")
+	// Загрузка конфигурации: значения по умолчанию, config.yaml, переменные окружения
+	config, err := loadConfig()
+	if err != nil {
+		log.Fatal(err)
 	}
 	log.Printf("config.TelegramToken: %s***%s\n",
 		config.TelegramToken[:6],
@@ -174,15 +102,20 @@ func main() {
 	if err != nil {
 		log.Fatalf("Ошибка инициализации DB: %v", err)
 	}
-	defer bot.db.Close()
 
 	// Инициализация менеджера капчи
 	log.Printf("Инициализация модуля капчи...")
 	bot.initializeCaptchaManager()
 
+	// Останавливаемся по SIGINT/SIGTERM (Ctrl+C, systemctl stop, rc-service stop)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	// Запуск бота
 	log.Printf("Запуск обработки...")
-	bot.Run()
+	bot.Run(ctx)
+
+	bot.Shutdown(config.ShutdownTimeout)
 }
 
 // initializeCaptchaManager инициализирует менеджер капчи
@@ -191,7 +124,8 @@ func (b *Bot) initializeCaptchaManager() {
 	log.Printf("Менеджер капчи инициализирован")
 }
 
-func setupLogger() {
+// setupLogger настраивает вывод логов в stdout и файл с ротацией, возвращает файл для закрытия при остановке
+func setupLogger() io.Closer {
 	logDir := LOG_DIR
 	if err := os.MkdirAll(logDir, 0755); err != nil {
 		log.Printf("Не удалось создать директорию для логов: %v. Используется текущая директория.", err)
@@ -213,15 +147,7 @@ func setupLogger() {
 	// Направляем вывод логов в файл и в stdout
 	log.SetOutput(io.MultiWriter(os.Stdout, lumberjackLogger))
 	log.Println("Logger Run.")
-}
-
-// getEnv возвращает значение переменной окружения или значение по умолчанию
-func getEnv(key, defaultValue string) string {
-	value := os.Getenv(key)
-	if value == "" {
-		return defaultValue
-	}
-	return value
+	return lumberjackLogger
 }
 
 // NewBot создает новый экземпляр бота
@@ -236,17 +162,48 @@ func NewBot(config Config) (*Bot, error) {
 		return nil, fmt.Errorf("ошибка создания DB: %v", err)
 	}
 
-	return &Bot{
-		config:      config,
-		tgBot:       tgBot,
-		httpClient:  &http.Client{Timeout: AI_REQUEST_TIMEOUT * time.Second, Transport: &http.Transport{MaxIdleConns: 10, IdleConnTimeout: 30 * time.Second}},
-		db:          dbInstance,
-		lastSummary: make(map[int64]time.Time),
-	}, nil
+	httpClient := &http.Client{Timeout: AI_REQUEST_TIMEOUT * time.Second, Transport: &http.Transport{MaxIdleConns: 10, IdleConnTimeout: 30 * time.Second}}
+
+	llm, err := NewLLMProvider(config.AiProvider, config.LocalLLMUrl, httpClient)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка создания LLM провайдера: %v", err)
+	}
+
+	var embedder EmbeddingProvider
+	if config.EmbeddingsURL != "" {
+		embedder = NewOpenAIEmbedder(config.EmbeddingsURL, httpClient)
+	}
+
+	workCtx, cancelWork := context.WithCancel(context.Background())
+
+	bot := &Bot{
+		workCtx:      workCtx,
+		cancelWork:   cancelWork,
+		tgBot:        tgBot,
+		httpClient:   httpClient,
+		db:           dbInstance,
+		llm:          llm,
+		embedder:     embedder,
+		aiJobs:       newAiJobs(),
+		lastSummary:  newSummaryTimes(),
+		settings:     newChatSettingsStore(dbInstance),
+		spamRules:    newSpamRulesStore(dbInstance),
+		spamVerdicts: newSpamVerdictCache(),
+		flood:        module.NewFloodDetector(),
+		topics:       newMessageTopics(),
+		incidents:    newIncidentClaims(),
+	}
+	bot.conf.Store(&config)
+	return bot, nil
+}
+
+// config возвращает текущую конфигурацию (после /reload это уже новый экземпляр)
+func (b *Bot) config() *Config {
+	return b.conf.Load()
 }
 
-// Run запускает бота
-func (b *Bot) Run() {
+// Run запускает бота и возвращается после отмены ctx
+func (b *Bot) Run(ctx context.Context) {
 	// Логируем информацию о версии
 	log.Printf("Бот запущен как %s, %s, %s", b.tgBot.Self.UserName, Version, BuildDate)
 
@@ -255,36 +212,116 @@ func (b *Bot) Run() {
 	if BuildDate != "" {
 		versionInfo += ", сборка: " + BuildDate
 	}
-	msg := tgbotapi.NewMessage(152657363, "🤖 Бот "+b.tgBot.Self.UserName+" запущен! Версия: "+versionInfo)
+	msg := tgbotapi.NewMessage(b.config().AdminChatID, "🤖 Бот "+b.tgBot.Self.UserName+" запущен! Версия: "+versionInfo)
 	_, err := b.tgBot.Send(msg)
 	if err != nil {
 		log.Printf("Ошибка отправки сообщения о запуске:%v", err)
 	}
 
+	// Воркеры обработки обновлений переживают реконнекты
+	b.dispatcher = NewDispatcher(b.config().Workers, b.config().QueueSize, b.handleUpdate)
+
+	// Очистка старых сообщений в БД, отслеживание изменений конфигурации, дайджесты, индексация для /search
+	b.background.Add(5)
+	go func() {
+		defer b.background.Done()
+		b.db.DeleteOldMessages(ctx)
+	}()
+	go func() {
+		defer b.background.Done()
+		b.db.CleanupOldContext(ctx)
+	}()
+	go func() {
+		defer b.background.Done()
+		b.watchConfig(ctx)
+	}()
+	go func() {
+		defer b.background.Done()
+		b.runDigestScheduler(ctx)
+	}()
+	go func() {
+		defer b.background.Done()
+		b.runEmbeddingIndexer(ctx)
+	}()
+
+	// Получение обновлений: вебхук или long polling
+	receive := b.runWithReconnect
+	if b.config().BotMode == "webhook" {
+		receive = b.runWebhook
+	} else {
+		// Пока зарегистрирован вебхук, getUpdates возвращает ошибку
+		if _, err := b.tgBot.Request(tgbotapi.DeleteWebhookConfig{}); err != nil {
+			log.Printf("Ошибка удаления вебхука: %v", err)
+		}
+	}
+	log.Printf("Режим получения обновлений: %s", b.config().BotMode)
+
 	// Основной цикл обработки обновлений с реконнектом
 	for {
-		if err := b.runWithReconnect(); err != nil {
+		err := receive(ctx)
+		if ctx.Err() != nil {
+			log.Printf("Получен сигнал остановки")
+			return
+		}
+		if err != nil {
 			log.Printf("Ошибка в основном цикле: %v. Повторная попытка через 5 секунд...", err)
-			time.Sleep(5 * time.Second)
+			select {
+			case <-ctx.Done():
+				log.Printf("Получен сигнал остановки")
+				return
+			case <-time.After(5 * time.Second):
+			}
 		}
 	}
 }
 
-// runWithReconnect запускает основной цикл с возможностью реконнекта
-func (b *Bot) runWithReconnect() error {
+// Shutdown останавливает прием обновлений, ждет не дольше timeout завершения
+// уже начатой обработки (AI, картинки), затем отменяет оставшееся и закрывает БД
+func (b *Bot) Shutdown(timeout time.Duration) {
+	log.Printf("[Shutdown] Остановка бота, ожидание текущих задач до %v...", timeout)
+	b.tgBot.StopReceivingUpdates()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if b.dispatcher != nil {
+		if err := b.dispatcher.Stop(ctx); err != nil {
+			log.Printf("[Shutdown] %v, отменяем оставшиеся задачи", err)
+			b.cancelWork()
+
+			// Даем отмененным задачам немного времени освободить БД
+			graceCtx, graceCancel := context.WithTimeout(context.Background(), 5*time.Second)
+			if err := b.dispatcher.Stop(graceCtx); err != nil {
+				log.Printf("[Shutdown] Задачи не завершились после отмены: %v", err)
+			}
+			graceCancel()
+		}
+	}
+	b.cancelWork()
+
+	// Фоновые циклы останавливаются по отмене ctx из Run
+	b.background.Wait()
+
+	if err := b.db.Close(); err != nil {
+		log.Printf("[Shutdown] Ошибка закрытия БД: %v", err)
+	}
+	log.Printf("[Shutdown] Бот остановлен")
+}
+
+// runWithReconnect запускает основной цикл с возможностью реконнекта, возвращает nil при отмене ctx
+func (b *Bot) runWithReconnect(ctx context.Context) error {
 	// Проверка доступности Telegram API
 	if err := b.checkTelegramAPI(); err != nil {
 		return fmt.Errorf("Telegram API недоступен: %w", err)
 	}
 
-	// Очистка старых сообщений в БД
-	go b.db.DeleteOldMessages()
-	go b.db.CleanupOldContext()
+	// Основной цикл обработки обновлений, получение останавливается при выходе из функции
+	pollCtx, stopPolling := context.WithCancel(ctx)
+	defer stopPolling()
 
-	// Основной цикл обработки обновлений
-	u := tgbotapi.NewUpdate(0)
+	u := tgbotapi.NewUpdate(int(b.updateOffset.Load()))
 	u.Timeout = 60
-	updates := b.tgBot.GetUpdatesChan(u)
+	updates := b.pollUpdates(pollCtx, u)
 
 	// Таймер для контроля времени бездействия
 	idleTimer := time.NewTimer(5 * time.Minute)
@@ -292,6 +329,9 @@ func (b *Bot) runWithReconnect() error {
 
 	for {
 		select {
+		case <-ctx.Done():
+			return nil
+
 		case update, ok := <-updates:
 			// Сбрасываем таймер бездействия
 			if !idleTimer.Stop() {
@@ -303,46 +343,9 @@ func (b *Bot) runWithReconnect() error {
 				return fmt.Errorf("канал обновлений закрыт")
 			}
 
-			if update.Message != nil {
-				// Проверка размера сообщения
-				if len(update.Message.Text) > 4096 {
-					log.Printf("[Run()] Сообщение слишком большое: %d символов", len(update.Message.Text))
-					continue
-				}
-
-				// Логирование входящего сообщения (сокращенная версия)
-				logMsg := fmt.Sprintf("[Run()] Тип: %s", getMessageType(update.Message))
-
-				if update.Message.From != nil {
-					logMsg += fmt.Sprintf("%s[%v] ", getUserName(update.Message.From), update.Message.From.ID)
-				}
-
-				if update.Message.Chat != nil {
-					logMsg += fmt.Sprintf("в %s(%d) ", getChatTitle(update.Message), update.Message.Chat.ID)
-				}
-
-				// Добавляем либо текст, либо подпись, либо отметку о медиа
-				switch {
-				case update.Message.Text != "":
-					text := update.Message.Text
-					if len(text) > 50 {
-						text = text[:50] + "..."
-					}
-					logMsg += fmt.Sprintf("- %q", text)
-				case update.Message.Caption != "":
-					caption := update.Message.Caption
-					if len(caption) > 50 {
-						caption = caption[:50] + "..."
-					}
-					logMsg += fmt.Sprintf("- [подпись] %q", caption)
-				default:
-					logMsg += "- [медиа]"
-				}
-
-				log.Println(logMsg)
-
-				// Обработка сообщения
-				b.processAllMessage(update.Message)
+			// Обработка в очереди чата, чтобы медленный чат не тормозил остальные
+			if err := b.dispatchUpdate(update); err != nil {
+				log.Printf("[Run()] Обновление %d не принято: %v", update.UpdateID, err)
 			}
 
 		case <-idleTimer.C:
@@ -360,6 +363,82 @@ func (b *Bot) runWithReconnect() error {
 	}
 }
 
+// handleUpdate обрабатывает одно обновление (вызывается из воркера чата)
+func (b *Bot) handleUpdate(update tgbotapi.Update) {
+	defer b.topics.forget(update)
+
+	if update.CallbackQuery != nil {
+		b.handleCallbackQuery(update.CallbackQuery)
+		return
+	}
+	if update.Message == nil {
+		return
+	}
+
+	// Проверка размера сообщения
+	if len(update.Message.Text) > 4096 {
+		log.Printf("[Run()] Сообщение слишком большое: %d символов", len(update.Message.Text))
+		return
+	}
+
+	// Логирование входящего сообщения (сокращенная версия)
+	logMsg := fmt.Sprintf("[Run()] Тип: %s", getMessageType(update.Message))
+
+	if update.Message.From != nil {
+		logMsg += fmt.Sprintf("%s[%v] ", getUserName(update.Message.From), update.Message.From.ID)
+	}
+
+	if update.Message.Chat != nil {
+		logMsg += fmt.Sprintf("в %s(%d) ", getChatTitle(update.Message), update.Message.Chat.ID)
+	}
+
+	// Добавляем либо текст, либо подпись, либо отметку о медиа
+	switch {
+	case update.Message.Text != "":
+		text := update.Message.Text
+		if len(text) > 50 {
+			text = text[:50] + "..."
+		}
+		logMsg += fmt.Sprintf("- %q", text)
+	case update.Message.Caption != "":
+		caption := update.Message.Caption
+		if len(caption) > 50 {
+			caption = caption[:50] + "..."
+		}
+		logMsg += fmt.Sprintf("- [подпись] %q", caption)
+	default:
+		logMsg += "- [медиа]"
+	}
+
+	log.Println(logMsg)
+
+	// Обработка сообщения
+	b.processAllMessage(update.Message)
+}
+
+// handleCallbackQuery обрабатывает нажатия inline кнопок
+func (b *Bot) handleCallbackQuery(query *tgbotapi.CallbackQuery) {
+	// Кнопки без сообщения (inline режим) бот не создает
+	if query.Message == nil || query.Message.Chat == nil {
+		b.answerCallback(query, "")
+		return
+	}
+	log.Printf("[Run()] Callback %q от %s[%d] в чате %d", query.Data, getUserName(query.From), query.From.ID, query.Message.Chat.ID)
+
+	switch {
+	case strings.HasPrefix(query.Data, SETTINGS_CALLBACK_PREFIX):
+		b.handleSettingsCallback(query)
+	case strings.HasPrefix(query.Data, FIND_CALLBACK_PREFIX):
+		b.handleFindCallback(query)
+	case strings.HasPrefix(query.Data, INCIDENT_CALLBACK_PREFIX):
+		b.handleIncidentCallback(query)
+	case strings.HasPrefix(query.Data, INCIDENTS_CALLBACK_PREFIX):
+		b.handleIncidentsCallback(query)
+	default:
+		b.answerCallback(query, "")
+	}
+}
+
 // checkTelegramAPI проверяет доступность Telegram API
 func (b *Bot) checkTelegramAPI() error {
 	// Пытаемся получить информацию о боте
@@ -371,8 +450,8 @@ func (b *Bot) checkTelegramAPI() error {
 }
 
 func (b *Bot) processAllMessage(message *tgbotapi.Message) {
-	// Пропускаем служебные сообщения и сообщения от каналов
-	if message.Text == "" || message.From == nil {
+	// Пропускаем служебные сообщения и сообщения от каналов, фото и голосовые сохраняем для сводок
+	if (message.Text == "" && message.Caption == "" && len(message.Photo) == 0 && messageVoice(message) == nil) || message.From == nil {
 		log.Printf("Служебное: %v", message)
 		return
 	}
@@ -388,9 +467,10 @@ func (b *Bot) processAllMessage(message *tgbotapi.Message) {
 		return
 	}
 
-	// Сохранение сообщений из групп
+	// Сохранение сообщений из групп. Описание фото и расшифровка голосовых дописываются позже,
+	// только если сообщение свежее и прошло антиспам
 	if message.Chat.IsGroup() || message.Chat.IsSuperGroup() {
-		b.storeMessage(message)
+		b.storeMessage(message, "")
 	}
 
 	// Проверяем возраст сообщения
@@ -410,7 +490,20 @@ func (b *Bot) processAllMessage(message *tgbotapi.Message) {
 	//log.Printf("[processMessage] Msg от %v в чате %v: %q", getUserName(message.From), getChatTitle(message), message.Text)
 
 	// Обработка всех сообщений, валидации проверки, антиспам, капча
-	b.handleAllMessages(message)
+	if !b.handleAllMessages(message) {
+		return
+	}
+
+	// Медиа только сохраняются, команды и обращения - текстом
+	if message.Text == "" {
+		// Модели vision и распознавания речи медленные: вызываем их только для сообщений, прошедших
+		// антиспам, чтобы рейд картинками или голосовыми не занял очередь чата
+		transcript := b.storeMediaContent(message)
+		if transcript != "" && b.chatConfig(message.Chat.ID).VoiceReplyEnabled {
+			b.replyTranscript(message, transcript)
+		}
+		return
+	}
 
 	// Обработка сообщений команд
 	if message.IsCommand() {
@@ -419,6 +512,11 @@ func (b *Bot) processAllMessage(message *tgbotapi.Message) {
 		return
 	}
 
+	// Ответы AI на обращения можно отключить для чата
+	if !b.chatConfig(message.Chat.ID).AiReplyEnabled {
+		return
+	}
+
 	// Проверяем, обращается ли пользователь к боту
 	if b.isBotMentioned(message) {
 		log.Printf("[processMessage]Обращение к боту")
@@ -439,25 +537,24 @@ func (b *Bot) processAllMessage(message *tgbotapi.Message) {
 
 }
 
-func (b *Bot) storeMessage(message *tgbotapi.Message) {
+// storeMessage сохраняет сообщение в историю чата, content - описание фото или расшифровка голосового
+func (b *Bot) storeMessage(message *tgbotapi.Message, content string) {
 	//chatID := message.Chat.ID
 	userID := message.From.ID
 
 	// Логируем ID чата и пользователя
 	//log.Printf("[storeMessage] от %d в чате %d",  userID, chatID)
 
-	// Пропускаем служебные пустые сообщения
-	if message.Text == "" {
-		// Проверяем наличие подписи (для медиа-сообщений)
-		if message.Caption == "" {
-			return
-		}
+	// Используем текст или подпись (для медиа-сообщений), к фото добавляем описание,
+	// к голосовым - расшифровку
+	text := message.Text
+	if text == "" {
+		text = mediaText(message.Caption, mediaKind(message), content)
 	}
 
-	// Используем текст или подпись (для медиа-сообщений)
-	text := message.Text
-	if text == "" && message.Caption != "" {
-		text = message.Caption
+	// Пропускаем служебные пустые сообщения
+	if text == "" {
+		return
 	}
 
 	// Сохраняем чат и пользователя в БД
@@ -477,6 +574,9 @@ func (b *Bot) storeMessage(message *tgbotapi.Message) {
 	err = b.db.SaveMessage(
 		message.Chat.ID,
 		userID,
+		message.MessageID,
+		b.replyToMessageID(message),
+		b.messageThreadID(message),
 		text,
 		int64(message.Date),
 	)
@@ -486,11 +586,37 @@ func (b *Bot) storeMessage(message *tgbotapi.Message) {
 
 }
 
+// storeMediaContent описывает фото или расшифровывает голосовое и дописывает результат
+// в сохраненное сообщение (фото без подписи сохраняется только сейчас). Возвращает расшифровку голосового.
+func (b *Bot) storeMediaContent(message *tgbotapi.Message) string {
+	var content, transcript string
+	if messageVoice(message) != nil {
+		transcript = b.transcribeVoice(message)
+		content = transcript
+	} else {
+		content = b.describePhoto(message)
+	}
+	if content == "" || !(message.Chat.IsGroup() || message.Chat.IsSuperGroup()) {
+		return transcript
+	}
+
+	text := mediaText(message.Caption, mediaKind(message), content)
+	updated, err := b.db.UpdateMessageText(message.Chat.ID, message.MessageID, text)
+	if err != nil {
+		log.Printf("[storeMediaContent] %v", err)
+		return transcript
+	}
+	if !updated {
+		b.storeMessage(message, content)
+	}
+	return transcript
+}
+
 // handleBotMention обрабатывает сообщения, адресованные боту
 func (b *Bot) handleBotMention(message *tgbotapi.Message) {
 
 	// Удаляем ключевое слово или упоминание из текста
-	cleanText := b.removeBotMention(message.Text)
+	cleanText := b.removeBotMention(message.Chat.ID, message.Text)
 	// Обрабатываем очищенный текст сообщения
 	switch {
 	case strings.Contains(strings.ToLower(cleanText), "забудь"):
@@ -504,16 +630,17 @@ func (b *Bot) handleBotMention(message *tgbotapi.Message) {
 		b.sendMessage(message.Chat.ID, fmt.Sprintf("Все забыл =) %s", getUserName(message.From)))
 	case strings.Contains(strings.ToLower(cleanText), "сводка"),
 		strings.Contains(strings.ToLower(cleanText), "саммари"):
-		// Обработка параметра количества сообщений (по умолчанию LIMIT_MSG)
+		// Обработка параметра количества сообщений (по умолчанию summary_limit чата)
+		limit := b.chatConfig(message.Chat.ID).SummaryLimit
 		args := strings.Fields(message.CommandArguments())
-		count := LIMIT_MSG // значение по умолчанию
+		count := limit // значение по умолчанию
 		if len(args) > 0 {
 			if num, err := strconv.Atoi(args[0]); err == nil && num > 0 {
 				count = num
 				// Ограничим максимальное количество сообщений для безопасности
-				if count > LIMIT_MSG {
-					count = LIMIT_MSG
-					b.sendMessage(message.Chat.ID, fmt.Sprintf("Я помню только %d сообщений...", LIMIT_MSG))
+				if count > limit {
+					count = limit
+					b.sendMessage(message.Chat.ID, fmt.Sprintf("Я помню только %d сообщений...", limit))
 				}
 			}
 		}
@@ -532,6 +659,7 @@ func (b *Bot) handleBotMention(message *tgbotapi.Message) {
 // handleReplyToBot обрабатывает ответы на сообщения бота
 func (b *Bot) handleReplyToBot(message *tgbotapi.Message) {
 	chatID := message.Chat.ID
+	chatConfig := b.chatConfig(chatID)
 	log.Printf("Пользователь %d обратился: %s", message.From.ID, message.Text)
 
 	// Запускаем горутину для периодической отправки индикатора печати
@@ -558,11 +686,11 @@ func (b *Bot) handleReplyToBot(message *tgbotapi.Message) {
 	}
 
 	// Получаем историю диалога (последние 30 сообщений или за последние 24 часа)
-	context, err := b.db.GetConversationContext(
+	history, err := b.db.GetConversationContext(
 		message.Chat.ID,
 		message.From.ID,
-		b.config.ContextMessageLimit, // например 30
-		b.config.ContextTimeLimit,    // например 24
+		chatConfig.ContextMessageLimit, // например 30
+		b.config().ContextTimeLimit,    // например 24
 	)
 	if err != nil {
 		log.Printf("Ошибка получения контекста: %v", err)
@@ -571,18 +699,18 @@ func (b *Bot) handleReplyToBot(message *tgbotapi.Message) {
 	// Обрабатываем сообщение с учетом системного промпта пользователя
 	if aiInfo != "" {
 		// Добавляем системный промпт в начало контекста
-		context = append([]db.ContextMessage{{
+		history = append([]db.ContextMessage{{
 			Role:      "system",
 			Content:   aiInfo,
 			Timestamp: message.Time().Unix(),
-		}}, context...)
+		}}, history...)
 	}
 
 	// Формируем промпт с учетом контекста
 	var prompt string
-	if len(context) > 0 {
+	if len(history) > 0 {
 		prompt = "Контекст предыдущего общения:\n"
-		for _, msg := range context {
+		for _, msg := range history {
 			prompt += fmt.Sprintf("%s: %s\n", msg.Role, msg.Content)
 		}
 		prompt += "\nНовый запрос: " + message.Text
@@ -590,19 +718,32 @@ func (b *Bot) handleReplyToBot(message *tgbotapi.Message) {
 		prompt = message.Text
 	}
 
+	ctx, done := b.aiJobs.start(b.workCtx, chatID)
+	defer done()
+
+	// Сообщения истории чата по теме вопроса, ответ ссылается на них
+	ragPrompt, render := b.historyContext(ctx, message)
+	if ragPrompt != "" {
+		prompt = ragPrompt + "\n\n" + prompt
+	}
+
 	log.Printf("prompt: %v", prompt)
 
-	// Создание сводки с помощью локальной LLM
-	summary, err := b.generateAiRequest(
-		b.config.ReplyPrompt,
-		//b.config.SystemPrompt,
-		//fmt.Sprintf(b.config.ReplyPrompt, prompt),
+	// Создание ответа с помощью локальной LLM (с потоковым выводом)
+	summary, err := b.generateAiRequestStreamHTML(
+		ctx,
+		chatConfig.PersonaReplyPrompt(),
+		//b.config().SystemPrompt,
+		//fmt.Sprintf(b.config().ReplyPrompt, prompt),
 		prompt,
 		message,
+		"",
+		" @"+message.From.UserName,
+		render,
 	)
 	if err != nil {
 		log.Printf("Ошибка генерации reply: %v", err)
-		b.sendMessage(message.Chat.ID, "Что-то мои мозги потекли.")
+		b.replyAiError(message.Chat.ID, err, "Что-то мои мозги потекли.")
 		return
 	}
 
@@ -619,5 +760,4 @@ func (b *Bot) handleReplyToBot(message *tgbotapi.Message) {
 	}
 
 	fmt.Printf("Resp AI: %v", summary)
-	b.sendMessage(message.Chat.ID, summary+" @"+message.From.UserName)
 }
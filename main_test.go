@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"facilitatorbot/db"
+	"facilitatorbot/module"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const TEST_CHAT_ID = -1001234567890
+
+// telegramRequest запрос бота к заглушке Telegram Bot API
+type telegramRequest struct {
+	Method string
+	Params map[string]string
+}
+
+// telegramStub заглушка Telegram Bot API: на любой метод, кроме getFile, отвечает успехом и запоминает запросы
+type telegramStub struct {
+	mu       sync.Mutex
+	requests []telegramRequest
+	before   func(method string) // если задан, вызывается до ответа, например чтобы задержать запрос
+}
+
+func (s *telegramStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	method := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+	r.ParseMultipartForm(1 << 20)
+	params := make(map[string]string)
+	for key, values := range r.Form {
+		params[key] = values[0]
+	}
+	s.mu.Lock()
+	s.requests = append(s.requests, telegramRequest{Method: method, Params: params})
+	messageID := len(s.requests)
+	s.mu.Unlock()
+	if s.before != nil {
+		s.before(method)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	switch method {
+	case "getMe":
+		fmt.Fprint(w, `{"ok":true,"result":{"id":1,"is_bot":true,"first_name":"Sheriff","username":"test_bot"}}`)
+	case "sendChatAction", "deleteMessage", "restrictChatMember", "banChatMember", "unbanChatMember":
+		fmt.Fprint(w, `{"ok":true,"result":true}`)
+	case "getChatMember":
+		// Бот (id 1) администратор, остальные - обычные участники
+		status := "member"
+		if params["user_id"] == "1" {
+			status = "administrator"
+		}
+		fmt.Fprintf(w, `{"ok":true,"result":{"user":{"id":%s},"status":%q}}`, params["user_id"], status)
+	case "getFile":
+		// Файлы заглушка не отдает, важен только сам запрос
+		fmt.Fprint(w, `{"ok":false,"error_code":400,"description":"Bad Request: file not found"}`)
+	default:
+		fmt.Fprintf(w, `{"ok":true,"result":{"message_id":%d,"date":0,"chat":{"id":%s,"type":"supergroup"}}}`,
+			messageID, params["chat_id"])
+	}
+}
+
+// sent запросы метода method в порядке отправки
+func (s *telegramStub) sent(method string) []telegramRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var requests []telegramRequest
+	for _, request := range s.requests {
+		if request.Method == method {
+			requests = append(requests, request)
+		}
+	}
+	return requests
+}
+
+// newTestBot бот с временной БД, моделью llm и заглушкой Telegram вместо api.telegram.org.
+// Бот работает только в тестовой группе TEST_CHAT_ID.
+func newTestBot(t *testing.T, llm LLMProvider, config Config) (*Bot, *telegramStub) {
+	t.Helper()
+
+	stub := &telegramStub{}
+	server := httptest.NewServer(stub)
+	t.Cleanup(server.Close)
+
+	tgBot, err := tgbotapi.NewBotAPIWithClient("test", server.URL+"/bot%s/%s", server.Client())
+	if err != nil {
+		t.Fatalf("NewBotAPIWithClient: %v", err)
+	}
+
+	database, err := db.NewDB(filepath.Join(t.TempDir(), "test.db"), config.HistoryDays, config.ContextRetentionDays)
+	if err != nil {
+		t.Fatalf("NewDB: %v", err)
+	}
+	t.Cleanup(func() { database.Close() })
+	if err := database.Init(); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+
+	workCtx, cancelWork := context.WithCancel(context.Background())
+	t.Cleanup(cancelWork)
+
+	bot := &Bot{
+		workCtx:      workCtx,
+		cancelWork:   cancelWork,
+		tgBot:        tgBot,
+		httpClient:   server.Client(),
+		db:           database,
+		llm:          llm,
+		aiJobs:       newAiJobs(),
+		lastSummary:  newSummaryTimes(),
+		settings:     newChatSettingsStore(database),
+		spamRules:    newSpamRulesStore(database),
+		spamVerdicts: newSpamVerdictCache(),
+		flood:        module.NewFloodDetector(),
+		topics:       newMessageTopics(),
+		incidents:    newIncidentClaims(),
+	}
+	config.AllowedGroups = []int64{TEST_CHAT_ID}
+	bot.conf.Store(&config)
+	return bot, stub
+}
+
+// testMessage сообщение пользователя в тестовой группе
+func testMessage(messageID int, userID int64, text string) *tgbotapi.Message {
+	return &tgbotapi.Message{
+		MessageID: messageID,
+		From:      &tgbotapi.User{ID: userID, FirstName: fmt.Sprintf("User%d", userID), UserName: fmt.Sprintf("user%d", userID)},
+		Chat:      &tgbotapi.Chat{ID: TEST_CHAT_ID, Type: "supergroup", Title: "Test"},
+		Text:      text,
+	}
+}
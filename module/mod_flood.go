@@ -0,0 +1,284 @@
+package module
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/bits"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// Виды флуда, от самого серьезного
+const (
+	FLOOD_CROSS_CHAT = "cross_chat" // один пользователь рассылает текст по нескольким чатам
+	FLOOD_RAID       = "raid"       // новые участники публикуют один и тот же текст
+	FLOOD_REPEAT     = "repeat"     // пользователь повторяет сообщение в чате
+	FLOOD_RATE       = "rate"       // пользователь пишет слишком часто
+)
+
+// FLOOD_KINDS названия видов флуда для отчетов
+var FLOOD_KINDS = map[string]string{
+	FLOOD_CROSS_CHAT: "рассылка по чатам",
+	FLOOD_RAID:       "рейд новых аккаунтов",
+	FLOOD_REPEAT:     "повтор сообщения",
+	FLOOD_RATE:       "слишком частые сообщения",
+}
+
+const FLOOD_RETENTION = time.Hour        // дольше отпечатки и время сообщений не храним, окна проверок не больше
+const FLOOD_MAX_ENTRIES = 20000          // предел отпечатков в памяти, старые вытесняются
+const FLOOD_TRIM_BATCH = 1000            // сверх предела вытесняем пачкой, а не сдвигаем массив на каждом сообщении
+const FLOOD_SIMHASH_DISTANCE = 6         // отпечатки, различающиеся не больше чем в 6 битах из 64, - один текст с мелкими правками
+const FLOOD_PRUNE_INTERVAL = time.Minute // как часто забывать старые сообщения
+
+// homoglyphs латинские буквы, которыми спамеры подменяют кириллицу
+var homoglyphs = map[rune]rune{
+	'a': 'а', 'c': 'с', 'e': 'е', 'k': 'к', 'm': 'м', 'o': 'о', 'p': 'р', 'x': 'х', 'y': 'у',
+}
+
+// NormalizeText приводит текст к виду для сравнения: нижний регистр, без знаков и эмодзи,
+// цифры как 0 (спамеры меняют суммы), ё как е, латинские двойники кириллических букв в русских словах заменены
+func NormalizeText(text string) string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for i, word := range words {
+		cyrillic := strings.ContainsFunc(word, func(r rune) bool { return unicode.Is(unicode.Cyrillic, r) })
+		words[i] = strings.Map(func(r rune) rune {
+			if unicode.IsDigit(r) {
+				return '0'
+			}
+			if r == 'ё' {
+				return 'е'
+			}
+			if cyr, ok := homoglyphs[r]; ok && cyrillic {
+				return cyr
+			}
+			return r
+		}, word)
+	}
+	return strings.Join(words, " ")
+}
+
+// Simhash отпечаток нормализованного текста по словам и парам слов:
+// у похожих текстов отличается в нескольких битах
+func Simhash(normalized string) uint64 {
+	words := strings.Fields(normalized)
+	features := make([]string, 0, 2*len(words))
+	features = append(features, words...)
+	for i := 0; i+1 < len(words); i++ {
+		features = append(features, words[i]+" "+words[i+1])
+	}
+
+	var weights [64]int
+	for _, feature := range features {
+		h := fnv.New64a()
+		h.Write([]byte(feature))
+		sum := h.Sum64()
+		for bit := 0; bit < 64; bit++ {
+			if sum&(1<<bit) != 0 {
+				weights[bit]++
+			} else {
+				weights[bit]--
+			}
+		}
+	}
+
+	var hash uint64
+	for bit, weight := range weights {
+		if weight > 0 {
+			hash |= 1 << bit
+		}
+	}
+	return hash
+}
+
+// SimhashDistance число различающихся бит двух отпечатков
+func SimhashDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// FloodLimits пороги проверки, 0 выключает соответствующую проверку
+type FloodLimits struct {
+	Window     time.Duration // окно поиска одинаковых текстов, не больше FLOOD_RETENTION
+	MinLength  int           // тексты короче (в символах после нормализации) не сравниваются
+	Repeats    int           // сколько одинаковых сообщений пользователя в чате - флуд
+	RaidUsers  int           // сколько новых участников с одинаковым текстом - рейд
+	Chats      int           // в скольких чатах один пользователь публикует текст - рассылка
+	RateLimit  int           // больше стольких сообщений за RateWindow - флуд
+	RateWindow time.Duration // не больше FLOOD_RETENTION
+}
+
+// FloodMessage сообщение для проверки
+type FloodMessage struct {
+	ChatID    int64
+	UserID    int64
+	MessageID int
+	Text      string
+	NewUser   bool // участник недавно в чате, учитывается при поиске рейдов
+	Time      time.Time
+}
+
+// FloodHit сообщение, которое оказалось частью флуда уже после того, как было пропущено
+type FloodHit struct {
+	UserID    int64
+	MessageID int
+}
+
+// FloodVerdict результат проверки
+type FloodVerdict struct {
+	Kind    string     // пусто - флуда нет
+	Count   int        // сколько сообщений, участников или чатов набралось за окно
+	Earlier []FloodHit // для рейда: сообщения других участников до порога, о них сообщается один раз
+}
+
+// Reason причина для инцидента
+func (v FloodVerdict) Reason() string {
+	return fmt.Sprintf("%s (×%d)", FLOOD_KINDS[v.Kind], v.Count)
+}
+
+type floodEntry struct {
+	chatID    int64
+	userID    int64
+	messageID int
+	hash      uint64
+	newUser   bool
+	raid      bool // уже учтено как часть рейда
+	time      time.Time
+}
+
+type floodUser struct {
+	chatID int64
+	userID int64
+}
+
+// FloodDetector помнит отпечатки и время недавних сообщений всех чатов
+type FloodDetector struct {
+	mu        sync.Mutex
+	entries   []floodEntry // по возрастанию времени
+	rates     map[floodUser][]time.Time
+	lastPrune time.Time
+}
+
+func NewFloodDetector() *FloodDetector {
+	return &FloodDetector{rates: make(map[floodUser][]time.Time)}
+}
+
+// Check запоминает сообщение и проверяет, не флуд ли оно
+func (d *FloodDetector) Check(msg FloodMessage, limits FloodLimits) FloodVerdict {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.prune(msg.Time)
+
+	var verdict FloodVerdict
+	normalized := NormalizeText(msg.Text)
+	if len([]rune(normalized)) >= max(limits.MinLength, 1) {
+		verdict = d.checkText(msg, Simhash(normalized), limits)
+	}
+	if rate := d.checkRate(msg, limits); verdict.Kind == "" {
+		verdict = rate
+	}
+	return verdict
+}
+
+// checkText ищет похожие тексты за окно и запоминает отпечаток сообщения
+func (d *FloodDetector) checkText(msg FloodMessage, hash uint64, limits FloodLimits) FloodVerdict {
+	repeats := 1
+	chats := map[int64]bool{msg.ChatID: true}
+	raiders := make(map[int64]bool)
+	if msg.NewUser {
+		raiders[msg.UserID] = true
+	}
+	var raidEntries []int // индексы сообщений других новых участников с тем же текстом
+
+	since := msg.Time.Add(-limits.Window)
+	for i := len(d.entries) - 1; i >= 0 && !d.entries[i].time.Before(since); i-- {
+		e := d.entries[i]
+		if SimhashDistance(e.hash, hash) > FLOOD_SIMHASH_DISTANCE {
+			continue
+		}
+		switch {
+		case e.userID == msg.UserID && e.chatID == msg.ChatID:
+			repeats++
+		case e.userID == msg.UserID:
+			chats[e.chatID] = true
+		case e.chatID == msg.ChatID && e.newUser:
+			raiders[e.userID] = true
+			raidEntries = append(raidEntries, i)
+		}
+	}
+
+	entry := floodEntry{chatID: msg.ChatID, userID: msg.UserID, messageID: msg.MessageID, hash: hash, newUser: msg.NewUser, time: msg.Time}
+	isCrossChat := limits.Chats > 1 && len(chats) >= limits.Chats
+	isRaid := !isCrossChat && limits.RaidUsers > 1 && msg.NewUser && len(raiders) >= limits.RaidUsers
+	var earlier []FloodHit
+	if isRaid {
+		// Сообщения первых участников рейда пришли до порога и были пропущены
+		for _, i := range raidEntries {
+			if !d.entries[i].raid {
+				d.entries[i].raid = true
+				earlier = append(earlier, FloodHit{UserID: d.entries[i].userID, MessageID: d.entries[i].messageID})
+			}
+		}
+		entry.raid = true
+	}
+
+	d.entries = append(d.entries, entry)
+	if len(d.entries) > FLOOD_MAX_ENTRIES+FLOOD_TRIM_BATCH {
+		d.entries = slices.Delete(d.entries, 0, len(d.entries)-FLOOD_MAX_ENTRIES)
+	}
+
+	switch {
+	case isCrossChat:
+		return FloodVerdict{Kind: FLOOD_CROSS_CHAT, Count: len(chats)}
+	case isRaid:
+		// Найдены в обратном порядке, отдаем по времени
+		slices.Reverse(earlier)
+		return FloodVerdict{Kind: FLOOD_RAID, Count: len(raiders), Earlier: earlier}
+	case limits.Repeats > 1 && repeats >= limits.Repeats:
+		return FloodVerdict{Kind: FLOOD_REPEAT, Count: repeats}
+	}
+	return FloodVerdict{}
+}
+
+// checkRate считает сообщения пользователя в чате за RateWindow.
+// После нарушения счет начинается заново, чтобы каждое следующее сообщение не было новым нарушением.
+func (d *FloodDetector) checkRate(msg FloodMessage, limits FloodLimits) FloodVerdict {
+	if limits.RateLimit <= 0 {
+		return FloodVerdict{}
+	}
+	key := floodUser{chatID: msg.ChatID, userID: msg.UserID}
+	since := msg.Time.Add(-limits.RateWindow)
+	times := d.rates[key]
+	for len(times) > 0 && times[0].Before(since) {
+		times = times[1:]
+	}
+	times = append(times, msg.Time)
+	if len(times) > limits.RateLimit {
+		delete(d.rates, key)
+		return FloodVerdict{Kind: FLOOD_RATE, Count: len(times)}
+	}
+	d.rates[key] = times
+	return FloodVerdict{}
+}
+
+// prune раз в FLOOD_PRUNE_INTERVAL забывает сообщения старше FLOOD_RETENTION
+func (d *FloodDetector) prune(now time.Time) {
+	if now.Sub(d.lastPrune) < FLOOD_PRUNE_INTERVAL {
+		return
+	}
+	d.lastPrune = now
+	since := now.Add(-FLOOD_RETENTION)
+	old := 0
+	for old < len(d.entries) && d.entries[old].time.Before(since) {
+		old++
+	}
+	d.entries = slices.Delete(d.entries, 0, old)
+	for key, times := range d.rates {
+		if len(times) == 0 || times[len(times)-1].Before(since) {
+			delete(d.rates, key)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package module
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNormalizeText(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{in: "Привет, МИР!!! 🚀", want: "привет мир"},
+		{in: "Заработок 5000$ в день", want: "заработок 0000 в день"},
+		{in: "Ёлка и ещё", want: "елка и еще"},
+		// Латинские двойники в русских словах заменяются кириллицей
+		{in: "Зapабoтoк", want: "заработок"},
+		{in: "Kpипто дoхoд", want: "крипто доход"},
+		// В латинских словах буквы остаются
+		{in: "Crypto Coin", want: "crypto coin"},
+		{in: "   ", want: ""},
+	}
+	for _, tt := range tests {
+		if got := NormalizeText(tt.in); got != tt.want {
+			t.Errorf("NormalizeText(%q) = %q, ожидалось %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestSimhashDistance(t *testing.T) {
+	base := NormalizeText("Ищем людей на удаленную работу, доход от 3000 рублей в день, пишите в личные сообщения")
+
+	similar := []string{
+		"Ищем людей на удаленную работу, доход от 5000 рублей в день, пишите в личные сообщения",
+		"ИЩЕМ ЛЮДЕЙ на удаленную работу!!! доход от 3000 рублей в день, пишите в личные сообщения 🔥",
+		"Ищем людей нa удaленную рaботу, доход от 3000 рублей в день, пишите в личные сообщения",
+	}
+	for _, text := range similar {
+		if d := SimhashDistance(Simhash(base), Simhash(NormalizeText(text))); d > FLOOD_SIMHASH_DISTANCE {
+			t.Errorf("%q: расстояние %d, ожидалось не больше %d", text, d, FLOOD_SIMHASH_DISTANCE)
+		}
+	}
+
+	unrelated := []string{
+		"Кто-нибудь знает, во сколько завтра начинается митап и можно ли прийти без регистрации",
+		"Сервер опять упал после обновления, база не отвечает, посмотрите логи пожалуйста",
+		"Продам велосипед в хорошем состоянии, почти не катался, самовывоз из центра",
+	}
+	for _, text := range unrelated {
+		if d := SimhashDistance(Simhash(base), Simhash(NormalizeText(text))); d <= FLOOD_SIMHASH_DISTANCE {
+			t.Errorf("%q: расстояние %d, ожидалось больше %d", text, d, FLOOD_SIMHASH_DISTANCE)
+		}
+	}
+}
+
+func TestFloodDetectorRaidEarlier(t *testing.T) {
+	const chatID = -100
+	limits := FloodLimits{Window: 10 * time.Minute, MinLength: 10, RaidUsers: 3}
+	text := "Крипто доход без вложений, пиши в личку, осталось пять мест"
+	now := time.Now()
+
+	d := NewFloodDetector()
+	check := func(userID int64, messageID int) FloodVerdict {
+		return d.Check(FloodMessage{ChatID: chatID, UserID: userID, MessageID: messageID, Text: text, NewUser: true,
+			Time: now.Add(time.Duration(messageID) * time.Second)}, limits)
+	}
+
+	// Первые два участника до порога пропускаются
+	for i := 1; i <= 2; i++ {
+		if v := check(int64(i), i); v.Kind != "" {
+			t.Fatalf("сообщение %d: %s до порога", i, v.Kind)
+		}
+	}
+
+	// Третий - рейд, первые два сообщаются по времени
+	v := check(3, 3)
+	if v.Kind != FLOOD_RAID || v.Count != 3 {
+		t.Fatalf("третье сообщение: %+v, ожидался рейд из 3", v)
+	}
+	want := []FloodHit{{UserID: 1, MessageID: 1}, {UserID: 2, MessageID: 2}}
+	if len(v.Earlier) != len(want) || v.Earlier[0] != want[0] || v.Earlier[1] != want[1] {
+		t.Errorf("Earlier %+v, ожидалось %+v", v.Earlier, want)
+	}
+
+	// Дальше рейд продолжается, но уже сообщенные сообщения повторно не возвращаются
+	for i := 4; i <= 5; i++ {
+		v := check(int64(i), i)
+		if v.Kind != FLOOD_RAID || v.Count != i {
+			t.Errorf("сообщение %d: %+v, ожидался рейд из %d", i, v, i)
+		}
+		if len(v.Earlier) != 0 {
+			t.Errorf("сообщение %d: повторно сообщены %+v", i, v.Earlier)
+		}
+	}
+
+	// Старые участники в рейд не входят
+	old := d.Check(FloodMessage{ChatID: chatID, UserID: 100, MessageID: 100, Text: text, Time: now.Add(time.Minute)}, limits)
+	if old.Kind != "" {
+		t.Errorf("сообщение старого участника: %+v", old)
+	}
+}
+
+func TestFloodDetectorRateReset(t *testing.T) {
+	limits := FloodLimits{RateLimit: 3, RateWindow: 10 * time.Second}
+	now := time.Now()
+
+	d := NewFloodDetector()
+	check := func(userID int64, at time.Duration) FloodVerdict {
+		return d.Check(FloodMessage{ChatID: -100, UserID: userID, Text: "ок", Time: now.Add(at)}, limits)
+	}
+
+	for i := range 3 {
+		if v := check(1, time.Duration(i)*time.Second); v.Kind != "" {
+			t.Fatalf("сообщение %d: %+v до лимита", i+1, v)
+		}
+	}
+	if v := check(1, 3*time.Second); v.Kind != FLOOD_RATE || v.Count != 4 {
+		t.Fatalf("четвертое сообщение: %+v, ожидался флуд из 4", v)
+	}
+
+	// После нарушения счет начинается заново, а не каждое следующее сообщение - нарушение
+	for i := 4; i < 7; i++ {
+		if v := check(1, time.Duration(i)*time.Second); v.Kind != "" {
+			t.Errorf("сообщение %d после сброса: %+v", i+1, v)
+		}
+	}
+	if v := check(1, 7*time.Second); v.Kind != FLOOD_RATE {
+		t.Errorf("сообщение 8: %+v, ожидался флуд", v)
+	}
+
+	// Сообщения за пределами окна не считаются, другие пользователи - отдельно
+	if v := check(1, 30*time.Second); v.Kind != "" {
+		t.Errorf("сообщение после окна: %+v", v)
+	}
+	if v := check(2, 7*time.Second); v.Kind != "" {
+		t.Errorf("другой пользователь: %+v", v)
+	}
+}
+
+func TestFloodDetectorEntriesLimit(t *testing.T) {
+	d := NewFloodDetector()
+	limits := FloodLimits{Window: time.Millisecond, MinLength: 1}
+	now := time.Now()
+	// Сообщения раз в миллисекунду: окно короткое, чтобы тест не сравнивал каждое с каждым
+	for i := range FLOOD_MAX_ENTRIES + FLOOD_TRIM_BATCH + 1 {
+		d.Check(FloodMessage{ChatID: -100, UserID: int64(i), MessageID: i, Text: "сообщение",
+			Time: now.Add(time.Duration(i) * time.Millisecond)}, limits)
+	}
+	if len(d.entries) != FLOOD_MAX_ENTRIES {
+		t.Fatalf("отпечатков %d, ожидалось %d", len(d.entries), FLOOD_MAX_ENTRIES)
+	}
+	// Вытеснены самые старые
+	if first := d.entries[0].messageID; first != FLOOD_TRIM_BATCH+1 {
+		t.Errorf("первый отпечаток %d, ожидался %d", first, FLOOD_TRIM_BATCH+1)
+	}
+}
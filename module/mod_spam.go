@@ -1,153 +1,287 @@
 package module
 
 import (
-	"log"
+	"fmt"
 	"regexp"
+	"sort"
 	"strings"
 )
 
-// Проверка сообщения на спам, скам и рекламу
-// Проверка сообщения на спам, скам и рекламу
-// Возвращает (isSpam bool, reason string, pattern string)
-func IsSpam(text string) (bool, string, string) {
-
-	// Пропускаем админов
-	// if b.isUserAdmin(chatID, userID) {
-	// 	b.processVerifiedMessage(message)
-	// 	return
-	// }
-	// Приводим текст к нижнему регистру для регистронезависимого поиска
-	lowerText := strings.ToLower(text)
-
-	// Создаем карту паттернов с описаниями для лучшего логирования
-	patternDefinitions := map[string]string{
+// Типы правил антиспама
+const (
+	SPAM_RULE_REGEX   = "regex"   // регулярное выражение по тексту, без учета регистра
+	SPAM_RULE_KEYWORD = "keyword" // все слова фразы встречаются в тексте
+	SPAM_RULE_DOMAIN  = "domain"  // домен ссылки или его поддомен; без точки - часть имени домена
+	SPAM_RULE_ENTITY  = "entity"  // сущность Telegram в сообщении: text_link, mention, phone_number...
+)
+
+// SPAM_ENTITY_TYPES типы сущностей, которые можно указать в правиле entity
+var SPAM_ENTITY_TYPES = map[string]bool{
+	"mention": true, "hashtag": true, "cashtag": true, "bot_command": true, "url": true,
+	"email": true, "phone_number": true, "text_link": true, "text_mention": true,
+	"spoiler": true, "custom_emoji": true,
+}
+
+// urlRe ссылки в тексте: со схемой, с www. или вида t.me/...
+var urlRe = regexp.MustCompile(`(?i)\b(?:https?://|ftp://|www\.)\S+|\b[a-z0-9-]+(?:\.[a-z0-9-]+)*\.(?:me|ly|gl|io|com|ru|net|org|xyz|top|site|online|link|cc)/\S*`)
+
+// SpamRule правило антиспама. Вес совпавших правил суммируется и сравнивается с порогом,
+// отрицательный вес снижает оценку (например, для доверенных доменов).
+type SpamRule struct {
+	ID       int64   `yaml:"-"`        // ID в БД, 0 - правило из конфигурации
+	Kind     string  `yaml:"kind"`     // regex, keyword, domain, entity
+	Pattern  string  `yaml:"pattern"`  // шаблон в формате типа правила
+	Weight   float64 `yaml:"weight"`   // вклад в оценку
+	Category string  `yaml:"category"` // причина для отчетов
+	Chats    []int64 `yaml:"chats"`    // в каких чатах действует, пусто - во всех
+}
+
+// AppliesTo действует ли правило в чате
+func (r SpamRule) AppliesTo(chatID int64) bool {
+	if len(r.Chats) == 0 {
+		return true
+	}
+	for _, id := range r.Chats {
+		if id == chatID {
+			return true
+		}
+	}
+	return false
+}
+
+// DefaultSpamRules правила по умолчанию. Одна ссылка или одно слово спамом не считаются,
+// при пороге 1 нужно совпадение нескольких признаков или явно запрещенный контент.
+func DefaultSpamRules() []SpamRule {
+	return []SpamRule{
 		// Ссылки и контакты
-		`(http|https|ftp|www\.|t\.me|telegram\.me|\+\d{7,})`: "ссылки и контакты",
-		//`[@#][a-z0-9_]{4,}`: "длинные упоминания и хэштеги",
+		{Kind: SPAM_RULE_REGEX, Pattern: `(http|https|ftp|www\.|t\.me|telegram\.me|\+\d{7,})`, Weight: 0.4, Category: "ссылки и контакты"},
+		{Kind: SPAM_RULE_ENTITY, Pattern: "text_link", Weight: 0.3, Category: "скрытые ссылки"},
 
 		// Финансовый спам и скам
-		`(?i)(крипто|биткоин|эфириум|блокчейн|nft|forex|форекс|трейд)`: "финансовый спам",
-		//`(?i)(заработок|доход|прибыль|пассивный доход|халява|богатство)`:            "заработок и доход",
-		`(?i)(брокер|трейдинг|дивидент|криптовалют|коин|токен)`: "трейдинг и инвестиции",
+		{Kind: SPAM_RULE_REGEX, Pattern: `(крипто|биткоин|эфириум|блокчейн|nft|forex|форекс|трейд)`, Weight: 0.5, Category: "финансовый спам"},
+		{Kind: SPAM_RULE_REGEX, Pattern: `(брокер|трейдинг|дивидент|криптовалют|коин|токен)`, Weight: 0.5, Category: "трейдинг и инвестиции"},
 
 		// Рекламные предложения
-		//`(?i)(бесплатно|акция|скидка|распродажа|промокод|купон|выиграй|приз|розыгрыш)`:        "рекламные предложения",
-		`(?i)(ограниченное время|только сегодня|последний шанс|уникальное предложение)`: "ограниченные предложения",
-		`(?i)(покупай|покупка|продажа)`: "торговые предложения",
+		{Kind: SPAM_RULE_REGEX, Pattern: `(ограниченное время|только сегодня|последний шанс|уникальное предложение)`, Weight: 0.6, Category: "ограниченные предложения"},
+		{Kind: SPAM_RULE_REGEX, Pattern: `(покупай|покупка|продажа)`, Weight: 0.3, Category: "торговые предложения"},
 
-		// Сомнительные предложения
-		`(?i)(скам|мошенник|лохотрон|надувательство|фишинг)`: "прямые упоминания скама",
+		// Сомнительные предложения: про скам чаще предупреждают, чем пишут сами мошенники
+		{Kind: SPAM_RULE_REGEX, Pattern: `(скам|мошенник|лохотрон|надувательство|фишинг)`, Weight: 0.3, Category: "упоминания скама"},
 
-		// Adult и запрещенный контент
-		`(?i)\b(xxx|porn|интим\s*услуг|love\s*сайт|казино|ставк[иа]|азартн[ые]|viagra)\b`: "запрещенный контент",
+		// Adult и запрещенный контент. \b в Go понимает только латиницу, поэтому граница слова - не буква
+		{Kind: SPAM_RULE_REGEX, Pattern: `(^|\P{L})(xxx|porn|интим\s*услуг|love\s*сайт|viagra)`, Weight: 1, Category: "запрещенный контент"},
+		{Kind: SPAM_RULE_REGEX, Pattern: `(^|\P{L})(казино|ставк[иа]|азартн[ые])`, Weight: 0.6, Category: "азартные игры"},
 
 		// Спам-техники
-		//`[!?]{5,}`:   "множественные восклицательные знаки",
-		//`\p{Lu}{5,}`: "множественные заглавные буквы",
-		`\d{10,}`: "длинные числовые последовательности",
-		//`\S{20,}`: "очень длинные слова без пробелов",
+		{Kind: SPAM_RULE_REGEX, Pattern: `\d{10,}`, Weight: 0.5, Category: "длинные числовые последовательности"},
+
+		// Подозрительные комбинации слов
+		{Kind: SPAM_RULE_KEYWORD, Pattern: "быстро деньги", Weight: 0.7, Category: "быстрый заработок"},
+		{Kind: SPAM_RULE_KEYWORD, Pattern: "легкий заработок", Weight: 0.7, Category: "легкие деньги"},
+		{Kind: SPAM_RULE_KEYWORD, Pattern: "работа дома", Weight: 0.5, Category: "работа на дому"},
+		{Kind: SPAM_RULE_KEYWORD, Pattern: "удаленная работа", Weight: 0.5, Category: "удаленка"},
+		{Kind: SPAM_RULE_KEYWORD, Pattern: "заработок интернет", Weight: 0.7, Category: "онлайн заработок"},
+		{Kind: SPAM_RULE_KEYWORD, Pattern: "инвестиции гарантия", Weight: 0.7, Category: "гарантированные инвестиции"},
+		{Kind: SPAM_RULE_KEYWORD, Pattern: "крипто доход", Weight: 0.7, Category: "доход от крипто"},
+		{Kind: SPAM_RULE_KEYWORD, Pattern: "бесплатный подарок", Weight: 0.5, Category: "бесплатные подарки"},
+		{Kind: SPAM_RULE_KEYWORD, Pattern: "выиграй iphone", Weight: 0.7, Category: "конкурсы и розыгрыши"},
+		{Kind: SPAM_RULE_KEYWORD, Pattern: "акция только сегодня", Weight: 0.5, Category: "ограниченные акции"},
+
+		// Подозрительные домены
+		{Kind: SPAM_RULE_DOMAIN, Pattern: "bit.ly", Weight: 0.6, Category: "укороченная ссылка"},
+		{Kind: SPAM_RULE_DOMAIN, Pattern: "goo.gl", Weight: 0.6, Category: "укороченная ссылка"},
+		{Kind: SPAM_RULE_DOMAIN, Pattern: "tinyurl", Weight: 0.6, Category: "укороченная ссылка"},
+		{Kind: SPAM_RULE_DOMAIN, Pattern: "shorte.st", Weight: 0.6, Category: "укороченная ссылка"},
+		{Kind: SPAM_RULE_DOMAIN, Pattern: "adf.ly", Weight: 0.6, Category: "рекламная ссылка"},
+		{Kind: SPAM_RULE_DOMAIN, Pattern: "profit", Weight: 0.6, Category: "финансовый домен"},
+		{Kind: SPAM_RULE_DOMAIN, Pattern: "earn", Weight: 0.6, Category: "заработок"},
+		{Kind: SPAM_RULE_DOMAIN, Pattern: "money", Weight: 0.6, Category: "деньги"},
+		{Kind: SPAM_RULE_DOMAIN, Pattern: "crypto", Weight: 0.6, Category: "криптовалюты"},
+		{Kind: SPAM_RULE_DOMAIN, Pattern: "investment", Weight: 0.6, Category: "инвестиции"},
+		{Kind: SPAM_RULE_DOMAIN, Pattern: "casino", Weight: 1, Category: "азартные игры"},
+		{Kind: SPAM_RULE_DOMAIN, Pattern: "gambling", Weight: 1, Category: "гемблинг"},
 	}
+}
+
+// SpamInput сообщение для проверки
+type SpamInput struct {
+	Text     string
+	Entities []string // типы сущностей сообщения
+	URLs     []string // ссылки из сущностей text_link, ссылки из текста находятся сами
+}
+
+// SpamMatch сработавшее правило
+type SpamMatch struct {
+	Rule     SpamRule
+	Fragment string // что совпало
+}
+
+// SpamScore итоговая оценка сообщения
+type SpamScore struct {
+	Total   float64
+	Matches []SpamMatch
+}
 
-	// Проверка по регулярным выражениям
-	for pattern, description := range patternDefinitions {
-		if matched, _ := regexp.MatchString(pattern, text); matched {
-			log.Printf("🚨 СПАМ: '%s' (%s) в тексте: %s", description, pattern, text)
-			return true, description, pattern
+// Categories категории сработавших правил с наибольшим вкладом первыми
+func (s SpamScore) Categories() []string {
+	weights := make(map[string]float64)
+	var categories []string
+	for _, m := range s.Matches {
+		if _, ok := weights[m.Rule.Category]; !ok {
+			categories = append(categories, m.Rule.Category)
 		}
+		weights[m.Rule.Category] += m.Rule.Weight
 	}
+	sort.SliceStable(categories, func(i, j int) bool { return weights[categories[i]] > weights[categories[j]] })
+	return categories
+}
 
-	// Дополнительные проверки с возвратом причины
-	if HasSuspiciousWordCombinations(lowerText) {
-		reason := "подозрительная комбинация слов"
-		log.Printf("🚨 СПАМ: %s в тексте: %s", reason, text)
-		return true, reason, "word_combination"
-	}
+// Reason категории сработавших правил и итоговая оценка
+func (s SpamScore) Reason() string {
+	return fmt.Sprintf("%s (%.2f)", strings.Join(s.Categories(), ", "), s.Total)
+}
+
+// compiledRule правило, подготовленное к проверке
+type compiledRule struct {
+	SpamRule
+	re    *regexp.Regexp
+	words []string
+}
+
+// SpamRuleSet скомпилированный набор правил
+type SpamRuleSet struct {
+	rules []compiledRule
+}
+
+// ValidateSpamRule проверяет правило перед сохранением
+func ValidateSpamRule(rule SpamRule) error {
+	_, err := compileSpamRule(rule)
+	return err
+}
 
-	// Проверка на подозрительные URL
-	if ContainsSuspiciousURL(text) {
-		reason := "подозрительный URL"
-		log.Printf("🚨 СПАМ: %s в тексте: %s", reason, text)
-		return true, reason, "suspicious_url"
+// compileSpamRule проверяет правило и готовит его к использованию
+func compileSpamRule(rule SpamRule) (compiledRule, error) {
+	compiled := compiledRule{SpamRule: rule}
+	if rule.Weight == 0 {
+		return compiled, fmt.Errorf("вес правила не может быть 0")
+	}
+	pattern := strings.TrimSpace(rule.Pattern)
+	if pattern == "" {
+		return compiled, fmt.Errorf("пустой шаблон")
 	}
 
-	return false, "", ""
-}
-
-// // Проверка на избыточное количество эмодзи
-// func (b *Bot) containsExcessiveEmoji(text string) bool {
-// 	emojiPattern := `[\x{1F600}-\x{1F64F}\x{1F300}-\x{1F5FF}\x{1F680}-\x{1F6FF}\x{1F700}-\x{1F77F}\x{1F780}-\x{1F7FF}\x{1F800}-\x{1F8FF}\x{1F900}-\x{1F9FF}\x{1FA00}-\x{1FA6F}\x{1FA70}-\x{1FAFF}\x{2600}-\x{26FF}\x{2700}-\x{27BF}]`
-
-// 	emojiCount := len(regexp.MustCompile(emojiPattern).FindAllString(text, -1))
-// 	textLength := len([]rune(text))
-
-// 	// Если более 30% текста - эмодзи, считаем спамом
-// 	if textLength > 0 && float64(emojiCount)/float64(textLength) > 0.3 {
-// 		return true
-// 	}
-
-// 	return false
-// }
-
-// Проверка подозрительных комбинаций слов
-func HasSuspiciousWordCombinations(text string) bool {
-	suspiciousCombinations := map[string]string{
-		"быстро деньги":        "быстрый заработок",
-		"легкий заработок":     "легкие деньги",
-		"работа дома":          "работа на дому",
-		"удаленная работа":     "удаленка",
-		"заработок интернет":   "онлайн заработок",
-		"инвестиции гарантия":  "гарантированные инвестиции",
-		"крипто доход":         "доход от крипто",
-		"бесплатный подарок":   "бесплатные подарки",
-		"выиграй iphone":       "конкурсы и розыгрыши",
-		"акция только сегодня": "ограниченные акции",
+	switch rule.Kind {
+	case SPAM_RULE_REGEX:
+		re, err := regexp.Compile("(?i)" + pattern)
+		if err != nil {
+			return compiled, fmt.Errorf("некорректное регулярное выражение: %v", err)
+		}
+		compiled.re = re
+	case SPAM_RULE_KEYWORD:
+		compiled.words = strings.Fields(strings.ToLower(pattern))
+	case SPAM_RULE_DOMAIN:
+		compiled.Pattern = urlHost(pattern)
+		if compiled.Pattern == "" {
+			return compiled, fmt.Errorf("некорректный домен %q", pattern)
+		}
+	case SPAM_RULE_ENTITY:
+		compiled.Pattern = strings.ToLower(pattern)
+		if !SPAM_ENTITY_TYPES[compiled.Pattern] {
+			return compiled, fmt.Errorf("неизвестный тип сущности %q", pattern)
+		}
+	default:
+		return compiled, fmt.Errorf("неизвестный тип правила %q (regex, keyword, domain, entity)", rule.Kind)
 	}
+	return compiled, nil
+}
 
-	for combo, description := range suspiciousCombinations {
-		if strings.Contains(text, combo) {
-			log.Printf("🔍 Подозрительная комбинация: '%s' (%s)", combo, description)
-			return true
+// CompileSpamRules собирает набор из правил. Некорректные правила пропускаются
+// и возвращаются ошибками, чтобы одно неудачное правило не выключало антиспам.
+func CompileSpamRules(rules []SpamRule) (*SpamRuleSet, []error) {
+	set := &SpamRuleSet{}
+	var errs []error
+	for _, rule := range rules {
+		compiled, err := compileSpamRule(rule)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("правило %s %q: %v", rule.Kind, rule.Pattern, err))
+			continue
 		}
+		set.rules = append(set.rules, compiled)
 	}
+	return set, errs
+}
 
-	return false
+// Len количество правил в наборе
+func (s *SpamRuleSet) Len() int {
+	return len(s.rules)
 }
 
-// Проверка на подозрительные URL
-func ContainsSuspiciousURL(text string) bool {
-	urlPattern := `(http|https|ftp|www\.)\S+`
-	urls := regexp.MustCompile(urlPattern).FindAllString(text, -1)
-
-	suspiciousDomains := map[string]string{
-		"bit.ly":     "укороченная ссылка",
-		"goo.gl":     "укороченная ссылка",
-		"tinyurl":    "укороченная ссылка",
-		"shorte.st":  "укороченная ссылка",
-		"adf.ly":     "рекламная ссылка",
-		"profit":     "финансовый домен",
-		"earn":       "заработок",
-		"money":      "деньги",
-		"crypto":     "криптовалюты",
-		"investment": "инвестиции",
-		"casino":     "азартные игры",
-		"gambling":   "гемблинг",
+// Score оценивает сообщение: каждое правило учитывается один раз
+func (s *SpamRuleSet) Score(input SpamInput) SpamScore {
+	var score SpamScore
+	lowerText := strings.ToLower(input.Text)
+
+	var hosts []string
+	for _, u := range append(urlRe.FindAllString(input.Text, -1), input.URLs...) {
+		if host := urlHost(u); host != "" {
+			hosts = append(hosts, host)
+		}
 	}
 
-	for _, url := range urls {
-		for domain, reason := range suspiciousDomains {
-			if strings.Contains(strings.ToLower(url), domain) {
-				log.Printf("🔗 Подозрительный URL: %s (%s: %s)", url, domain, reason)
-				return true
-			}
+	for _, rule := range s.rules {
+		if fragment, ok := rule.match(input, lowerText, hosts); ok {
+			score.Total += rule.Weight
+			score.Matches = append(score.Matches, SpamMatch{Rule: rule.SpamRule, Fragment: fragment})
 		}
 	}
+	return score
+}
 
-	return false
+// match проверяет одно правило и возвращает совпавший фрагмент
+func (r compiledRule) match(input SpamInput, lowerText string, hosts []string) (string, bool) {
+	switch r.Kind {
+	case SPAM_RULE_REGEX:
+		if loc := r.re.FindStringIndex(input.Text); loc != nil {
+			return input.Text[loc[0]:loc[1]], true
+		}
+	case SPAM_RULE_KEYWORD:
+		for _, word := range r.words {
+			if !strings.Contains(lowerText, word) {
+				return "", false
+			}
+		}
+		return strings.Join(r.words, " "), true
+	case SPAM_RULE_DOMAIN:
+		for _, host := range hosts {
+			if host == r.Pattern || strings.HasSuffix(host, "."+r.Pattern) ||
+				(!strings.Contains(r.Pattern, ".") && strings.Contains(host, r.Pattern)) {
+				return host, true
+			}
+		}
+	case SPAM_RULE_ENTITY:
+		for _, entity := range input.Entities {
+			if entity == r.Pattern {
+				return entity, true
+			}
+		}
+	}
+	return "", false
 }
 
-// Проверка на повторяющиеся сообщения (нужно реализовать хранение истории)
-// func (b *Bot) isRepeatedMessage(text string) bool {
-// 	// Здесь должна быть логика проверки истории сообщений
-// 	// Например, если одинаковое сообщение отправляется много раз
-// 	return false
-// }
+// urlHost домен ссылки в нижнем регистре без www.
+func urlHost(u string) string {
+	host := strings.ToLower(strings.TrimSpace(u))
+	if i := strings.Index(host, "://"); i != -1 {
+		host = host[i+3:]
+	}
+	if i := strings.IndexAny(host, "/?#"); i != -1 {
+		host = host[:i]
+	}
+	if i := strings.LastIndex(host, "@"); i != -1 {
+		host = host[i+1:]
+	}
+	if i := strings.Index(host, ":"); i != -1 {
+		host = host[:i]
+	}
+	return strings.TrimPrefix(strings.Trim(host, "."), "www.")
+}
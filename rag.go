@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"facilitatorbot/db"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const RAG_MIN_SCORE = 0.35      // минимальная близость по эмбеддингам для контекста ответа
+const RAG_MIN_WORD = 4          // короче слова в поиске без эмбеддингов не учитываются
+const RAG_MESSAGE_MAX_LEN = 500 // длинные сообщения в контексте обрезаем
+
+// questionWords основы значимых слов вопроса для поиска без эмбеддингов:
+// окончания отбрасываются, чтобы "шины" и "шинах" совпадали
+func questionWords(text string) []string {
+	seen := make(map[string]bool)
+	var words []string
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		runes := []rune(word)
+		if len(runes) < RAG_MIN_WORD {
+			continue
+		}
+		stem := string(runes[:max(RAG_MIN_WORD, len(runes)-2)])
+		if !seen[stem] {
+			seen[stem] = true
+			words = append(words, stem)
+		}
+	}
+	return words
+}
+
+// searchMessagesByWords ищет сообщения со словами вопроса. Ранжирует индекс (bm25):
+// пересчитывать совпадения здесь нельзя, он сравнивает слова без учета диакритики (ё и е, й и и).
+func (b *Bot) searchMessagesByWords(chatID int64, question string, limit int) ([]db.DBMessage, error) {
+	return b.db.SearchMessagesByWords(chatID, questionWords(question), limit)
+}
+
+// retrieveHistory находит в истории чата сообщения, относящиеся к вопросу: по эмбеддингам,
+// если настроен /search, иначе по словам. Сам вопрос в результат не попадает.
+// Сообщения возвращаются в хронологическом порядке.
+func (b *Bot) retrieveHistory(ctx context.Context, message *tgbotapi.Message, question string, limit int) ([]db.DBMessage, error) {
+	chatID := message.Chat.ID
+
+	var found []db.DBMessage
+	var err error
+	if b.embedder != nil {
+		// Запрашиваем на одно больше: вопрос мог уже попасть в индекс
+		found, _, err = b.searchMessages(ctx, chatID, question, limit+1, RAG_MIN_SCORE)
+	} else {
+		found, err = b.searchMessagesByWords(chatID, question, limit+1)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]db.DBMessage, 0, len(found))
+	for _, msg := range found {
+		if msg.MessageID != message.MessageID && len(result) < limit {
+			result = append(result, msg)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Timestamp < result[j].Timestamp })
+	return result, nil
+}
+
+// ragContext промпт с найденными сообщениями истории, пронумерованными для ссылок [#N]
+func ragContext(c Config, messages []db.DBMessage) string {
+	loc := c.Location()
+	var lines strings.Builder
+	for i, msg := range messages {
+		lines.WriteString(fmt.Sprintf("#%d [%s] %s(%v): %s\n",
+			i+1,
+			time.Unix(msg.Timestamp, 0).In(loc).Format("02.01.2006 15:04"),
+			msg.UserFirstName,
+			msg.Username,
+			truncateRunes(msg.Text, RAG_MESSAGE_MAX_LEN)))
+	}
+	return fmt.Sprintf(c.RagPrompt, lines.String())
+}
+
+// historyContext ищет историю для ответа на message и возвращает промпт с ней и
+// функцию, превращающую ответ в HTML со ссылками на источники. Пустой промпт - ничего не нашлось.
+func (b *Bot) historyContext(ctx context.Context, message *tgbotapi.Message) (string, func(string) string) {
+	chatConfig := b.chatConfig(message.Chat.ID)
+	if chatConfig.RagMessages == 0 || !b.isChatAllowed(message.Chat.ID) {
+		return "", markdownToHTML
+	}
+
+	// Обращение к боту в поиске только мешает
+	question := b.removeBotMention(message.Chat.ID, message.Text)
+	for _, kw := range chatConfig.BotKeywords {
+		if strings.HasPrefix(strings.ToLower(question), strings.ToLower(kw)) {
+			question = strings.TrimLeft(question[len(kw):], " ,:!")
+			break
+		}
+	}
+	sources, err := b.retrieveHistory(ctx, message, question, chatConfig.RagMessages)
+	if err != nil {
+		log.Printf("[historyContext] Ошибка поиска по истории: %v", err)
+		return "", markdownToHTML
+	}
+	if len(sources) == 0 {
+		return "", markdownToHTML
+	}
+	log.Printf("[historyContext] Найдено %d сообщений истории для ответа", len(sources))
+
+	links := summaryLinks(message.Chat.ID, sources)
+	return ragContext(chatConfig, sources), func(answer string) string {
+		return renderCitations(markdownToHTML(answer), links)
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestSearchMessagesByWordsIgnoresCase(t *testing.T) {
+	bot, _ := newTestBot(t, NewFakeLLMProvider(), defaultConfig())
+
+	texts := []string{
+		"Зимние ШИНЫ лучше менять в ноябре",
+		"Москва стоит в пробках",
+		"Погода сегодня отличная",
+	}
+	for i, text := range texts {
+		bot.storeMessage(testMessage(i+1, int64(i+1), text), "")
+	}
+
+	tests := []struct {
+		question string
+		want     string
+	}{
+		{question: "какие шины ставить?", want: texts[0]},
+		{question: "что в москве", want: texts[1]},
+		{question: "ПОГОДА", want: texts[2]},
+	}
+	for _, tt := range tests {
+		found, err := bot.searchMessagesByWords(TEST_CHAT_ID, tt.question, 5)
+		if err != nil {
+			t.Fatalf("searchMessagesByWords(%q): %v", tt.question, err)
+		}
+		if len(found) != 1 || found[0].Text != tt.want {
+			t.Errorf("searchMessagesByWords(%q) = %v, ожидалось %q", tt.question, found, tt.want)
+		}
+	}
+}
+
+// TestSearchMessagesByWordsRanksRareWords частое слово не вытесняет старое сообщение со всеми словами вопроса
+func TestSearchMessagesByWordsRanksRareWords(t *testing.T) {
+	bot, _ := newTestBot(t, NewFakeLLMProvider(), defaultConfig())
+
+	now := time.Now()
+	store := func(id int, text string, age time.Duration) {
+		message := testMessage(id, 1, text)
+		message.Date = int(now.Add(-age).Unix())
+		bot.storeMessage(message, "")
+	}
+	const OLD_ANSWER = "Ёлку ставим в холле, гирлянды лежат на складе"
+	store(1, OLD_ANSWER, 48*time.Hour)
+	for i := 2; i <= 30; i++ {
+		store(i, fmt.Sprintf("когда будет обед %d", i), time.Duration(30-i)*time.Minute)
+	}
+
+	found, err := bot.searchMessagesByWords(TEST_CHAT_ID, "Когда ставим елку и где гирлянды?", 3)
+	if err != nil {
+		t.Fatalf("searchMessagesByWords: %v", err)
+	}
+	if len(found) == 0 || found[0].Text != OLD_ANSWER {
+		t.Errorf("первым должно быть сообщение со всеми редкими словами, найдено %v", found)
+	}
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"reflect"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const CONFIG_WATCH_INTERVAL = 10 * time.Second // как часто проверять изменение файла конфигурации
+const CONFIG_DIFF_VALUE_LEN = 60               // длина значения в отчете об изменениях
+
+// restartOnlyKeys ключи, которые используются только при запуске (соединения, БД, воркеры).
+// При перезагрузке их изменение не применяется, а попадает в отчет.
+var restartOnlyKeys = map[string]bool{
+	"telegram_bot_token":     true,
+	"db_path":                true,
+	"history_days":           true,
+	"context_retention_days": true,
+	"ai_provider":            true, // провайдер и его URL создаются в NewBot
+	"ai_local_llm_url":       true,
+	"ai_embeddings_url":      true, // клиент создается в NewBot, индексатор /search запускается, только если URL задан
+	"bot_workers":            true,
+	"bot_queue_size":         true,
+	"shutdown_timeout":       true,
+	"bot_mode":               true,
+	"webhook_url":            true,
+	"webhook_listen":         true,
+	"webhook_path":           true,
+	"webhook_secret":         true,
+	"webhook_cert":           true,
+	"webhook_key":            true,
+	"webhook_upload_cert":    true,
+}
+
+// configDiff изменения между двумя конфигурациями
+type configDiff struct {
+	Changed     []string // применено: "key: старое → новое"
+	RestartOnly []string // изменено, но требует перезапуска
+}
+
+func (d configDiff) empty() bool {
+	return len(d.Changed) == 0 && len(d.RestartOnly) == 0
+}
+
+// String отчет для админ чата
+func (d configDiff) String() string {
+	var sb strings.Builder
+	if len(d.Changed) > 0 {
+		sb.WriteString("Изменено:\n")
+		for _, line := range d.Changed {
+			sb.WriteString("• " + line + "\n")
+		}
+	}
+	if len(d.RestartOnly) > 0 {
+		sb.WriteString("Требуют перезапуска (не применены):\n")
+		for _, key := range d.RestartOnly {
+			sb.WriteString("• " + key + "\n")
+		}
+	}
+	return strings.TrimSpace(sb.String())
+}
+
+// diffConfig сравнивает конфигурации по ключам YAML. Значения restart-only ключей
+// в next заменяются текущими, чтобы не применить их частично.
+func diffConfig(current *Config, next *Config) configDiff {
+	var diff configDiff
+
+	cur := reflect.ValueOf(current).Elem()
+	nxt := reflect.ValueOf(next).Elem()
+	for i := 0; i < cur.NumField(); i++ {
+		field := cur.Type().Field(i)
+		key := strings.Split(field.Tag.Get("yaml"), ",")[0]
+		if key == "" || key == "-" {
+			continue
+		}
+
+		oldValue, newValue := cur.Field(i), nxt.Field(i)
+		if reflect.DeepEqual(oldValue.Interface(), newValue.Interface()) {
+			continue
+		}
+
+		switch {
+		case restartOnlyKeys[key]:
+			diff.RestartOnly = append(diff.RestartOnly, key)
+			newValue.Set(oldValue)
+		case key == "chats":
+			diff.Changed = append(diff.Changed, diffChats(current.Chats, next.Chats)...)
+		default:
+			diff.Changed = append(diff.Changed, fmt.Sprintf("%s: %s → %s", key, formatConfigValue(oldValue.Interface()), formatConfigValue(newValue.Interface())))
+		}
+	}
+	return diff
+}
+
+// diffChats перечисляет добавленные, удаленные и измененные секции chats
+func diffChats(current, next map[int64]ChatConfig) []string {
+	ids := make(map[int64]bool)
+	for id := range current {
+		ids[id] = true
+	}
+	for id := range next {
+		ids[id] = true
+	}
+	sorted := make([]int64, 0, len(ids))
+	for id := range ids {
+		sorted = append(sorted, id)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	var lines []string
+	for _, id := range sorted {
+		oldChat, hadOld := current[id]
+		newChat, hasNew := next[id]
+		switch {
+		case !hadOld:
+			lines = append(lines, fmt.Sprintf("chats.%d: добавлен", id))
+		case !hasNew:
+			lines = append(lines, fmt.Sprintf("chats.%d: удален", id))
+		case !reflect.DeepEqual(oldChat, newChat):
+			lines = append(lines, fmt.Sprintf("chats.%d: изменен", id))
+		}
+	}
+	return lines
+}
+
+// formatConfigValue короткое однострочное представление значения для отчета
+func formatConfigValue(value any) string {
+	switch v := value.(type) {
+	case string:
+		if v == "" {
+			return `""`
+		}
+		value = strings.Join(strings.Fields(v), " ")
+	case RetryPolicy:
+		codes := make([]int, 0, len(v.RetryableStatus))
+		for code := range v.RetryableStatus {
+			codes = append(codes, code)
+		}
+		sort.Ints(codes)
+		value = fmt.Sprintf("attempts=%d base=%v max=%v jitter=%v status=%v", v.MaxAttempts, v.BaseDelay, v.MaxDelay, v.Jitter, codes)
+	}
+	text := fmt.Sprint(value)
+	if len([]rune(text)) > CONFIG_DIFF_VALUE_LEN {
+		text = truncateRunes(text, CONFIG_DIFF_VALUE_LEN) + "…"
+	}
+	return text
+}
+
+// reloadConfig перечитывает конфигурацию и атомарно подменяет ее.
+// При ошибке валидации текущая конфигурация остается в силе.
+func (b *Bot) reloadConfig() (configDiff, error) {
+	b.reloadMu.Lock()
+	defer b.reloadMu.Unlock()
+
+	next, err := loadConfig()
+	if err != nil {
+		return configDiff{}, err
+	}
+
+	current := b.config()
+	diff := diffConfig(current, &next)
+	if diff.empty() {
+		return diff, nil
+	}
+	b.conf.Store(&next)
+	return diff, nil
+}
+
+// applyConfigReload перезагружает конфигурацию и сообщает изменения или ошибку в админ чат
+func (b *Bot) applyConfigReload(source string) (configDiff, error) {
+	diff, err := b.reloadConfig()
+	if err != nil {
+		log.Printf("[reloadConfig] %s: %v", source, err)
+		b.sendMessage(b.config().AdminChatID, fmt.Sprintf("⚠️ Конфигурация не перезагружена (%s), используется прежняя:\n%v", source, err))
+		return diff, err
+	}
+	if diff.empty() {
+		log.Printf("[reloadConfig] %s: без изменений", source)
+		return diff, nil
+	}
+
+	report := fmt.Sprintf("🔄 Конфигурация перезагружена (%s)\n%s", source, diff)
+	log.Printf("[reloadConfig] %s", report)
+	b.sendMessage(b.config().AdminChatID, report)
+	return diff, nil
+}
+
+// watchConfig перезагружает конфигурацию при изменении файла или по SIGHUP, пока не отменен ctx
+func (b *Bot) watchConfig(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	path := configPath()
+	lastMod, _ := configModTime(path)
+
+	ticker := time.NewTicker(CONFIG_WATCH_INTERVAL)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			log.Printf("Отслеживание конфигурации остановлено")
+			return
+		case <-hup:
+			lastMod, _ = configModTime(path)
+			b.applyConfigReload("SIGHUP")
+		case <-ticker.C:
+			modTime, err := configModTime(path)
+			if err != nil || modTime.Equal(lastMod) {
+				continue
+			}
+			lastMod = modTime
+			b.applyConfigReload("изменен " + path)
+		}
+	}
+}
+
+// configModTime время изменения файла конфигурации
+func configModTime(path string) (time.Time, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return info.ModTime(), nil
+}
+
+// handleReload обрабатывает команду /reload (только для администраторов бота)
+func (b *Bot) handleReload(message *tgbotapi.Message) {
+	if !allowedAdmins[message.From.ID] {
+		b.sendMessage(message.Chat.ID, "Перезагрузка конфигурации доступна только администраторам бота")
+		return
+	}
+
+	diff, err := b.applyConfigReload("/reload от " + getUserName(message.From))
+	switch {
+	case message.Chat.ID == b.config().AdminChatID && (err != nil || !diff.empty()):
+		// отчет уже отправлен в этот чат
+	case err != nil:
+		b.sendMessage(message.Chat.ID, fmt.Sprintf("Ошибка перезагрузки конфигурации:\n%v", err))
+	case diff.empty():
+		b.sendMessage(message.Chat.ID, "Конфигурация без изменений")
+	default:
+		b.sendMessage(message.Chat.ID, "🔄 Конфигурация перезагружена\n"+diff.String())
+	}
+}
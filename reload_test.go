@@ -0,0 +1,30 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestDiffConfigRestartOnly(t *testing.T) {
+	current := defaultConfig()
+	next := defaultConfig()
+	next.ShutdownTimeout = current.ShutdownTimeout + time.Minute
+	next.Workers = current.Workers + 1
+	next.SummaryLimit = current.SummaryLimit + 1
+
+	diff := diffConfig(&current, &next)
+
+	for _, key := range []string{"shutdown_timeout", "bot_workers"} {
+		if !slices.Contains(diff.RestartOnly, key) {
+			t.Errorf("%s должен требовать перезапуска, RestartOnly: %v", key, diff.RestartOnly)
+		}
+	}
+	// Значения restart-only ключей остаются прежними, чтобы не применить их частично
+	if next.ShutdownTimeout != current.ShutdownTimeout || next.Workers != current.Workers {
+		t.Errorf("restart-only значения изменены: shutdown_timeout %v, bot_workers %d", next.ShutdownTimeout, next.Workers)
+	}
+	if len(diff.Changed) != 1 || next.SummaryLimit != current.SummaryLimit+1 {
+		t.Errorf("summary_limit должен примениться, Changed: %v", diff.Changed)
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"facilitatorbot/db"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const EMBEDDING_INDEX_INTERVAL = 30 * time.Second // как часто индексировать новые сообщения
+const EMBEDDING_BATCH_SIZE = 32                   // сообщений в одном запросе к /v1/embeddings
+const EMBEDDING_MIN_TEXT = 10                     // короткие "ок", "+1" не индексируем
+const EMBEDDING_MAX_TEXT = 2000                   // длиннее обрезаем, чтобы не превысить контекст модели эмбеддингов
+const SEARCH_RESULTS = 5
+const SEARCH_MIN_SCORE = 0.3 // ниже этой близости совпадения не показываем
+const SEARCH_SNIPPET_LEN = 200
+
+// runEmbeddingIndexer фоном считает векторы новых сообщений, пока не отменен ctx
+func (b *Bot) runEmbeddingIndexer(ctx context.Context) {
+	if b.embedder == nil {
+		return
+	}
+
+	ticker := time.NewTicker(EMBEDDING_INDEX_INTERVAL)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			log.Printf("Индексация сообщений остановлена")
+			return
+		case <-ticker.C:
+		}
+
+		// Догоняем накопившиеся сообщения пачками, пока они есть
+		for ctx.Err() == nil {
+			indexed, err := b.indexEmbeddings(ctx)
+			if err != nil {
+				log.Printf("[runEmbeddingIndexer] %v", err)
+				break
+			}
+			if indexed < EMBEDDING_BATCH_SIZE {
+				break
+			}
+		}
+	}
+}
+
+// indexEmbeddings считает векторы одной пачки неиндексированных сообщений
+func (b *Bot) indexEmbeddings(ctx context.Context) (int, error) {
+	model := b.config().EmbeddingsModel
+	messages, err := b.db.GetMessagesToEmbed(model, EMBEDDING_MIN_TEXT, EMBEDDING_BATCH_SIZE)
+	if err != nil || len(messages) == 0 {
+		return 0, err
+	}
+
+	texts := make([]string, len(messages))
+	for i, msg := range messages {
+		texts[i] = truncateRunes(msg.Text, EMBEDDING_MAX_TEXT)
+	}
+	vectors, err := b.embedder.Embed(ctx, model, texts)
+	if embeddingRejected(err) {
+		// Сервер отклонил пачку: ищем отклоненные тексты по одному, иначе пачка повторялась бы вечно
+		log.Printf("[indexEmbeddings] Пачка отклонена, индексируем по одному: %v", err)
+		vectors, err = b.embedEach(ctx, model, texts)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("ошибка получения эмбеддингов: %w", err)
+	}
+
+	embeddings := make([]db.MessageEmbedding, len(messages))
+	for i, msg := range messages {
+		embeddings[i] = db.MessageEmbedding{MessageRowID: msg.ID, ChatID: msg.ChatID, Vector: vectors[i]}
+	}
+	if err := b.db.SaveEmbeddings(model, embeddings); err != nil {
+		return 0, err
+	}
+	return len(messages), nil
+}
+
+// embedEach считает векторы по одному тексту. Отклоненный сервером текст получает пустой вектор:
+// сообщение помечается проиндексированным и в поиск не попадает.
+func (b *Bot) embedEach(ctx context.Context, model string, texts []string) ([][]float32, error) {
+	vectors := make([][]float32, len(texts))
+	for i, text := range texts {
+		vector, err := b.embedder.Embed(ctx, model, []string{text})
+		if embeddingRejected(err) {
+			log.Printf("[embedEach] Текст пропущен: %v", err)
+			vectors[i] = []float32{}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		vectors[i] = vector[0]
+	}
+	return vectors, nil
+}
+
+// embeddingRejected сервер эмбеддингов отклонил запрос (4xx кроме 429): повтор того же текста не поможет
+func embeddingRejected(err error) bool {
+	var statusErr *LLMStatusError
+	return errors.As(err, &statusErr) && statusErr.StatusCode >= 400 && statusErr.StatusCode < 500 &&
+		statusErr.StatusCode != http.StatusTooManyRequests
+}
+
+// searchMessages ищет до limit сообщений чата, близких по смыслу к query, лучшие первыми
+func (b *Bot) searchMessages(ctx context.Context, chatID int64, query string, limit int, minScore float64) ([]db.DBMessage, []float64, error) {
+	model := b.config().EmbeddingsModel
+	vectors, err := b.embedder.Embed(ctx, model, []string{query})
+	if err != nil {
+		return nil, nil, fmt.Errorf("ошибка получения эмбеддинга запроса: %w", err)
+	}
+
+	top := &topMatches{limit: limit, minScore: minScore}
+	err = b.db.ScanChatEmbeddings(chatID, model, func(e db.MessageEmbedding) {
+		top.add(e.MessageRowID, cosineSimilarity(vectors[0], e.Vector))
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ids := make([]int, len(top.items))
+	scores := make(map[int]float64, len(top.items))
+	for i, item := range top.items {
+		ids[i] = item.rowID
+		scores[item.rowID] = item.score
+	}
+	messages, err := b.db.GetMessagesByRowIDs(ids)
+	if err != nil {
+		return nil, nil, err
+	}
+	result := make([]float64, len(messages))
+	for i, msg := range messages {
+		result[i] = scores[msg.ID]
+	}
+	return messages, result, nil
+}
+
+// handleSearch обрабатывает команду /search <запрос>
+func (b *Bot) handleSearch(message *tgbotapi.Message) {
+	chatID := message.Chat.ID
+
+	if b.embedder == nil {
+		b.sendMessage(chatID, "Поиск не настроен: не указан ai_embeddings_url")
+		return
+	}
+	if !b.isChatAllowed(chatID) {
+		b.sendMessage(chatID, "Извините, у меня нет доступа к истории этого чата.")
+		return
+	}
+	query := strings.TrimSpace(message.CommandArguments())
+	if query == "" {
+		b.sendMessage(chatID, "Использование: /search <что искать>, например /search когда обсуждали отпуск")
+		return
+	}
+
+	stopTyping := b.startChatTyping(chatID)
+	defer close(stopTyping)
+
+	ctx, cancel := context.WithTimeout(b.workCtx, AI_REQUEST_TIMEOUT*time.Second)
+	defer cancel()
+	messages, scores, err := b.searchMessages(ctx, chatID, query, SEARCH_RESULTS, SEARCH_MIN_SCORE)
+	if err != nil {
+		log.Printf("[handleSearch] %v", err)
+		b.sendMessage(chatID, "Не удалось выполнить поиск, попробуйте позднее.")
+		return
+	}
+	if len(messages) == 0 {
+		b.sendHTML(chatID, message.MessageID, fmt.Sprintf("🔎 По запросу «%s» ничего не нашлось", html.EscapeString(query)))
+		return
+	}
+
+	loc := b.chatConfig(chatID).Location()
+	var text strings.Builder
+	text.WriteString(fmt.Sprintf("🔎 Нашлось по запросу «%s»:\n", html.EscapeString(query)))
+	for i, msg := range messages {
+		author := html.EscapeString(msg.UserFirstName)
+		if msg.Username != "" {
+			author += " (@" + html.EscapeString(msg.Username) + ")"
+		}
+		text.WriteString(fmt.Sprintf("\n%d. <b>%s</b>, %s, %.0f%%", i+1, author,
+			time.Unix(msg.Timestamp, 0).In(loc).Format("02.01.2006 15:04"), scores[i]*100))
+		if link := messageLink(chatID, msg.MessageID); link != "" {
+			text.WriteString(fmt.Sprintf(` <a href="%s">→</a>`, html.EscapeString(link)))
+		}
+
+		snippet := strings.Join(strings.Fields(msg.Text), " ")
+		if len([]rune(snippet)) > SEARCH_SNIPPET_LEN {
+			snippet = truncateRunes(snippet, SEARCH_SNIPPET_LEN) + "…"
+		}
+		text.WriteString("\n<i>" + html.EscapeString(snippet) + "</i>\n")
+	}
+	b.sendHTML(chatID, message.MessageID, text.String())
+}
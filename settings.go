@@ -0,0 +1,346 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+
+	"facilitatorbot/db"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const SETTINGS_CALLBACK_PREFIX = "settings:"
+
+// SUMMARY_LIMIT_CHOICES варианты окна сводки, которые перебирает кнопка в /settings
+var SUMMARY_LIMIT_CHOICES = []int{100, 200, 400, LIMIT_MSG}
+
+// chatSettingsStore кэш настроек чатов из таблицы chat_settings
+type chatSettingsStore struct {
+	db *db.DB
+
+	mu    sync.RWMutex
+	cache map[int64]db.ChatSettings
+}
+
+func newChatSettingsStore(database *db.DB) *chatSettingsStore {
+	return &chatSettingsStore{db: database, cache: make(map[int64]db.ChatSettings)}
+}
+
+// Get возвращает настройки чата, при первом обращении читает их из БД
+func (s *chatSettingsStore) Get(chatID int64) db.ChatSettings {
+	s.mu.RLock()
+	settings, ok := s.cache[chatID]
+	s.mu.RUnlock()
+	if ok {
+		return settings
+	}
+
+	settings, err := s.db.GetChatSettings(chatID)
+	if err != nil {
+		// Не кэшируем, попробуем прочитать в следующий раз
+		log.Printf("[chatSettings] %v", err)
+		return db.ChatSettings{ChatID: chatID}
+	}
+
+	s.mu.Lock()
+	s.cache[chatID] = settings
+	s.mu.Unlock()
+	return settings
+}
+
+// Update изменяет настройки чата через fn и сохраняет их в БД.
+// fn должна присваивать новые значения полям, а не менять значения по указателям.
+func (s *chatSettingsStore) Update(chatID, userID int64, fn func(*db.ChatSettings)) (db.ChatSettings, error) {
+	settings := s.Get(chatID)
+	fn(&settings)
+	settings.UpdatedBy = userID
+
+	if err := s.db.SaveChatSettings(settings); err != nil {
+		return settings, err
+	}
+
+	s.mu.Lock()
+	s.cache[chatID] = settings
+	s.mu.Unlock()
+	return settings, nil
+}
+
+// Reset удаляет настройки чата, после чего действуют значения конфигурации
+func (s *chatSettingsStore) Reset(chatID int64) error {
+	if err := s.db.DeleteChatSettings(chatID); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	delete(s.cache, chatID)
+	s.mu.Unlock()
+	return nil
+}
+
+// applyChatSettings накладывает настройки чата из БД на конфигурацию чата
+func applyChatSettings(c Config, s db.ChatSettings) Config {
+	if s.ThanksEnabled != nil {
+		c.ThanksEnabled = *s.ThanksEnabled
+	}
+	if s.AntispamEnabled != nil {
+		c.AntispamEnabled = *s.AntispamEnabled
+	}
+	if s.CaptchaEnabled != nil {
+		c.CaptchaEnabled = *s.CaptchaEnabled
+	}
+	if s.SummaryImageEnabled != nil {
+		c.SummaryImageEnabled = *s.SummaryImageEnabled
+	}
+	if s.VisionEnabled != nil {
+		c.VisionEnabled = *s.VisionEnabled
+	}
+	if s.VoiceEnabled != nil {
+		c.VoiceEnabled = *s.VoiceEnabled
+	}
+	if s.VoiceReplyEnabled != nil {
+		c.VoiceReplyEnabled = *s.VoiceReplyEnabled
+	}
+	if s.SpamLLMEnabled != nil {
+		c.SpamLLMEnabled = *s.SpamLLMEnabled
+	}
+	if s.SummaryLimit != nil {
+		c.SummaryLimit = *s.SummaryLimit
+	}
+	if s.Language != nil {
+		c.Language = *s.Language
+	}
+	if len(s.BotKeywords) > 0 {
+		c.BotKeywords = s.BotKeywords
+	}
+	return c
+}
+
+// chatConfig итоговые настройки чата: конфигурация, секция chats и /settings
+func (b *Bot) chatConfig(chatID int64) Config {
+	return applyChatSettings(b.config().ForChat(chatID), b.settings.Get(chatID))
+}
+
+// handleSettings обрабатывает команду /settings (только для администраторов чата)
+//
+//	/settings                      - клавиатура с настройками
+//	/settings keywords шериф, sheriff - ключевые слова обращения к боту
+//	/settings keywords reset       - ключевые слова из конфигурации
+func (b *Bot) handleSettings(message *tgbotapi.Message) {
+	chatID := message.Chat.ID
+
+	isAdmin, err := b.IsUserAdmin(chatID, message.From.ID)
+	if err != nil {
+		b.sendMessage(chatID, "Ошибка проверки прав администратора")
+		return
+	}
+	if !isAdmin {
+		b.sendMessage(chatID, "Настройки чата доступны только администраторам")
+		return
+	}
+
+	args := strings.TrimSpace(message.CommandArguments())
+	if name, value, _ := strings.Cut(args, " "); strings.EqualFold(name, "keywords") {
+		b.setBotKeywords(message, strings.TrimSpace(value))
+		return
+	}
+
+	msg := tgbotapi.NewMessage(chatID, b.settingsText())
+	msg.ReplyMarkup = b.settingsKeyboard(chatID)
+	if _, err := b.tgBot.Send(msg); err != nil {
+		log.Printf("[handleSettings] Ошибка отправки настроек: %v", err)
+	}
+}
+
+// setBotKeywords меняет ключевые слова обращения к боту
+func (b *Bot) setBotKeywords(message *tgbotapi.Message, value string) {
+	chatID := message.Chat.ID
+	if value == "" {
+		b.sendMessage(chatID, "Использование: /settings keywords шериф, sheriff (или reset)")
+		return
+	}
+
+	var keywords []string
+	if !strings.EqualFold(value, "reset") {
+		for _, kw := range strings.Split(value, ",") {
+			if kw = strings.ToLower(strings.TrimSpace(kw)); kw != "" {
+				keywords = append(keywords, kw)
+			}
+		}
+	}
+
+	_, err := b.settings.Update(chatID, message.From.ID, func(s *db.ChatSettings) {
+		s.BotKeywords = keywords
+	})
+	if err != nil {
+		log.Printf("[setBotKeywords] %v", err)
+		b.sendMessage(chatID, "Не удалось сохранить ключевые слова")
+		return
+	}
+	b.sendMessage(chatID, "Ключевые слова обращения: "+strings.Join(b.chatConfig(chatID).BotKeywords, ", "))
+}
+
+// settingsText заголовок сообщения с настройками
+func (b *Bot) settingsText() string {
+	return "⚙️ Настройки чата\n" +
+		"Кнопки переключают функции, * - значение изменено для этого чата.\n" +
+		"Ключевые слова: /settings keywords шериф, sheriff"
+}
+
+// settingsKeyboard клавиатура с текущими значениями настроек
+func (b *Bot) settingsKeyboard(chatID int64) tgbotapi.InlineKeyboardMarkup {
+	cfg := b.chatConfig(chatID)
+	saved := b.settings.Get(chatID)
+
+	toggle := func(title string, enabled bool, override bool, action string) tgbotapi.InlineKeyboardButton {
+		mark := "❌"
+		if enabled {
+			mark = "✅"
+		}
+		return tgbotapi.NewInlineKeyboardButtonData(mark+" "+title+overrideMark(override), SETTINGS_CALLBACK_PREFIX+action)
+	}
+
+	return tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			toggle("Благодарности", cfg.ThanksEnabled, saved.ThanksEnabled != nil, "thanks"),
+			toggle("Картинка к сводке", cfg.SummaryImageEnabled, saved.SummaryImageEnabled != nil, "image"),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			toggle("Антиспам", cfg.AntispamEnabled, saved.AntispamEnabled != nil, "antispam"),
+			toggle("Капча", cfg.CaptchaEnabled, saved.CaptchaEnabled != nil, "captcha"),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			toggle("Проверка спама ИИ", cfg.SpamLLMEnabled, saved.SpamLLMEnabled != nil, "spamllm"),
+			toggle("Описание фото", cfg.VisionEnabled, saved.VisionEnabled != nil, "vision"),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			toggle("Голосовые", cfg.VoiceEnabled, saved.VoiceEnabled != nil, "voice"),
+			toggle("Расшифровка в чат", cfg.VoiceReplyEnabled, saved.VoiceReplyEnabled != nil, "voicereply"),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf("📜 Сводка: %d сообщений%s", cfg.SummaryLimit, overrideMark(saved.SummaryLimit != nil)), SETTINGS_CALLBACK_PREFIX+"limit"),
+			tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf("🌐 Язык: %s%s", cfg.Language, overrideMark(saved.Language != nil)), SETTINGS_CALLBACK_PREFIX+"lang"),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("🔑 "+strings.Join(cfg.BotKeywords, ", ")+overrideMark(len(saved.BotKeywords) > 0), SETTINGS_CALLBACK_PREFIX+"keywords"),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("↩️ Сбросить", SETTINGS_CALLBACK_PREFIX+"reset"),
+			tgbotapi.NewInlineKeyboardButtonData("✖️ Закрыть", SETTINGS_CALLBACK_PREFIX+"close"),
+		),
+	)
+}
+
+func overrideMark(override bool) string {
+	if override {
+		return " *"
+	}
+	return ""
+}
+
+// handleSettingsCallback обрабатывает нажатия кнопок /settings
+func (b *Bot) handleSettingsCallback(query *tgbotapi.CallbackQuery) {
+	chatID := query.Message.Chat.ID
+	action := strings.TrimPrefix(query.Data, SETTINGS_CALLBACK_PREFIX)
+
+	isAdmin, err := b.IsUserAdmin(chatID, query.From.ID)
+	if err != nil || !isAdmin {
+		b.answerCallback(query, "Настройки доступны только администраторам")
+		return
+	}
+
+	cfg := b.chatConfig(chatID)
+	var update func(*db.ChatSettings)
+	switch action {
+	case "thanks":
+		update = func(s *db.ChatSettings) { s.ThanksEnabled = boolPtr(!cfg.ThanksEnabled) }
+	case "image":
+		update = func(s *db.ChatSettings) { s.SummaryImageEnabled = boolPtr(!cfg.SummaryImageEnabled) }
+	case "antispam":
+		update = func(s *db.ChatSettings) { s.AntispamEnabled = boolPtr(!cfg.AntispamEnabled) }
+	case "captcha":
+		update = func(s *db.ChatSettings) { s.CaptchaEnabled = boolPtr(!cfg.CaptchaEnabled) }
+	case "spamllm":
+		update = func(s *db.ChatSettings) { s.SpamLLMEnabled = boolPtr(!cfg.SpamLLMEnabled) }
+	case "vision":
+		update = func(s *db.ChatSettings) { s.VisionEnabled = boolPtr(!cfg.VisionEnabled) }
+	case "voice":
+		update = func(s *db.ChatSettings) { s.VoiceEnabled = boolPtr(!cfg.VoiceEnabled) }
+	case "voicereply":
+		update = func(s *db.ChatSettings) { s.VoiceReplyEnabled = boolPtr(!cfg.VoiceReplyEnabled) }
+	case "limit":
+		next := nextSummaryLimit(cfg.SummaryLimit)
+		update = func(s *db.ChatSettings) { s.SummaryLimit = &next }
+	case "lang":
+		next := nextLanguage(cfg.Language)
+		update = func(s *db.ChatSettings) { s.Language = &next }
+	case "keywords":
+		b.answerCallback(query, "Изменить: /settings keywords шериф, sheriff")
+		return
+	case "reset":
+		if err := b.settings.Reset(chatID); err != nil {
+			log.Printf("[handleSettingsCallback] %v", err)
+			b.answerCallback(query, "Не удалось сбросить настройки")
+			return
+		}
+	case "close":
+		b.answerCallback(query, "")
+		b.deleteMessage(chatID, query.Message.MessageID)
+		return
+	default:
+		b.answerCallback(query, "Неизвестная настройка")
+		return
+	}
+
+	if update != nil {
+		if _, err := b.settings.Update(chatID, query.From.ID, update); err != nil {
+			log.Printf("[handleSettingsCallback] %v", err)
+			b.answerCallback(query, "Не удалось сохранить настройку")
+			return
+		}
+	}
+	log.Printf("[handleSettingsCallback] %s изменил настройку %s в чате %d", getUserName(query.From), action, chatID)
+
+	edit := tgbotapi.NewEditMessageReplyMarkup(chatID, query.Message.MessageID, b.settingsKeyboard(chatID))
+	if _, err := b.tgBot.Request(edit); err != nil {
+		log.Printf("[handleSettingsCallback] Ошибка обновления клавиатуры: %v", err)
+	}
+	b.answerCallback(query, "Сохранено")
+}
+
+// answerCallback убирает индикатор загрузки с кнопки, text показывается всплывающей подсказкой
+func (b *Bot) answerCallback(query *tgbotapi.CallbackQuery, text string) {
+	if _, err := b.tgBot.Request(tgbotapi.NewCallback(query.ID, text)); err != nil {
+		log.Printf("Ошибка ответа на callback: %v", err)
+	}
+}
+
+// nextSummaryLimit следующий вариант окна сводки по кругу
+func nextSummaryLimit(current int) int {
+	for _, limit := range SUMMARY_LIMIT_CHOICES {
+		if limit > current {
+			return limit
+		}
+	}
+	return SUMMARY_LIMIT_CHOICES[0]
+}
+
+// nextLanguage следующий язык сводок по кругу
+func nextLanguage(current string) string {
+	codes := make([]string, 0, len(LANGUAGES))
+	for code := range LANGUAGES {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	for i, code := range codes {
+		if code == current {
+			return codes[(i+1)%len(codes)]
+		}
+	}
+	return codes[0]
+}
+
+func boolPtr(v bool) *bool {
+	return &v
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"facilitatorbot/module"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const SPAM_LLM_CACHE_TTL = 6 * time.Hour // сколько помнить вердикт модели по тексту, спам рассылают одинаковым текстом
+const SPAM_LLM_CACHE_SIZE = 1000         // максимум вердиктов в кэше
+const SPAM_LLM_MAX_TOKENS = 100          // ответ модели - короткий JSON
+const SPAM_LLM_TEXT_LEN = 2000           // длиннее сообщение обрезаем перед отправкой модели
+
+// spamVerdict ответ модели о сообщении
+type spamVerdict struct {
+	Spam       bool    `json:"spam"`
+	Category   string  `json:"category"`
+	Confidence float64 `json:"confidence"`
+}
+
+// Reason причина для инцидента: вердикт модели и сработавшие правила
+func (v spamVerdict) Reason(score module.SpamScore) string {
+	reason := fmt.Sprintf("ИИ: %s (%.2f)", v.Category, v.Confidence)
+	if len(score.Matches) > 0 {
+		reason += "; правила: " + score.Reason()
+	}
+	return reason
+}
+
+// parseSpamVerdict разбирает JSON из ответа модели, допускает текст и ``` вокруг него
+func parseSpamVerdict(content string) (spamVerdict, error) {
+	var verdict spamVerdict
+	start, end := strings.Index(content, "{"), strings.LastIndex(content, "}")
+	if start == -1 || end < start {
+		return verdict, fmt.Errorf("в ответе модели нет JSON: %q", truncateRunes(content, 200))
+	}
+	if err := json.Unmarshal([]byte(content[start:end+1]), &verdict); err != nil {
+		return verdict, fmt.Errorf("ошибка разбора ответа модели: %v", err)
+	}
+	verdict.Confidence = min(max(verdict.Confidence, 0), 1)
+	verdict.Category = strings.TrimSpace(verdict.Category)
+	if verdict.Category == "" {
+		verdict.Category = "без категории"
+	}
+	return verdict, nil
+}
+
+type spamVerdictEntry struct {
+	verdict spamVerdict
+	expires time.Time
+}
+
+// spamVerdictCache вердикты модели по нормализованному тексту сообщения
+type spamVerdictCache struct {
+	mu      sync.Mutex
+	conf    *Config // конфигурация, с промптом которой получены вердикты; после /reload кэш сбрасывается
+	entries map[uint64]spamVerdictEntry
+}
+
+func newSpamVerdictCache() *spamVerdictCache {
+	return &spamVerdictCache{entries: make(map[uint64]spamVerdictEntry)}
+}
+
+// spamTextKey ключ кэша: регистр и пробелы не влияют на вердикт
+func spamTextKey(text string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(strings.Join(strings.Fields(strings.ToLower(text)), " ")))
+	return h.Sum64()
+}
+
+func (c *spamVerdictCache) get(conf *Config, text string) (spamVerdict, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conf != conf {
+		return spamVerdict{}, false
+	}
+	entry, ok := c.entries[spamTextKey(text)]
+	if !ok || time.Now().After(entry.expires) {
+		return spamVerdict{}, false
+	}
+	return entry.verdict, true
+}
+
+func (c *spamVerdictCache) put(conf *Config, text string, verdict spamVerdict) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conf != conf {
+		c.conf = conf
+		c.entries = make(map[uint64]spamVerdictEntry)
+	}
+
+	now := time.Now()
+	if len(c.entries) >= SPAM_LLM_CACHE_SIZE {
+		var oldestKey uint64
+		var oldest time.Time
+		for key, entry := range c.entries {
+			if now.After(entry.expires) {
+				delete(c.entries, key)
+			} else if oldest.IsZero() || entry.expires.Before(oldest) {
+				oldestKey, oldest = key, entry.expires
+			}
+		}
+		if len(c.entries) >= SPAM_LLM_CACHE_SIZE {
+			delete(c.entries, oldestKey)
+		}
+	}
+	c.entries[spamTextKey(text)] = spamVerdictEntry{verdict: verdict, expires: now.Add(SPAM_LLM_CACHE_TTL)}
+}
+
+// needsSpamLLM нужно ли спрашивать модель о сообщении ниже порога правил:
+// оценка сомнительная или это одно из первых сообщений участника
+func (b *Bot) needsSpamLLM(message *tgbotapi.Message, cfg Config, score module.SpamScore) bool {
+	if !cfg.SpamLLMEnabled {
+		return false
+	}
+	if score.Total > 0 && score.Total >= cfg.SpamLLMMinScore {
+		return true
+	}
+	if cfg.SpamLLMNewMessages == 0 || message.IsCommand() || !(message.Chat.IsGroup() || message.Chat.IsSuperGroup()) {
+		return false
+	}
+	// Сообщение уже сохранено в историю, оно тоже учитывается
+	count, err := b.db.CountUserMessages(message.Chat.ID, message.From.ID)
+	if err != nil {
+		log.Printf("[needsSpamLLM] %v", err)
+		return false
+	}
+	return count <= cfg.SpamLLMNewMessages
+}
+
+// classifySpam спрашивает модель, спам ли текст. Повторный текст берется из кэша.
+func (b *Bot) classifySpam(message *tgbotapi.Message, text string, cfg Config) (spamVerdict, error) {
+	conf := b.config()
+	if verdict, ok := b.spamVerdicts.get(conf, text); ok {
+		return verdict, nil
+	}
+
+	ctx, cancel := context.WithTimeout(b.workCtx, cfg.SpamLLMTimeout)
+	defer cancel()
+
+	// Без повторов: за время повторной попытки сообщение уже прочитают
+	result, err := b.llm.Complete(ctx, []LLMMessage{
+		{Role: "system", Content: cfg.SpamLLMPrompt},
+		{Role: "user", Content: truncateRunes(text, SPAM_LLM_TEXT_LEN)},
+	}, LLMOptions{Model: cfg.AiModelName, Temperature: 0, MaxTokens: SPAM_LLM_MAX_TOKENS})
+	if err != nil {
+		return spamVerdict{}, fmt.Errorf("ошибка запроса к модели: %v", err)
+	}
+	b.saveBilling(message, result)
+
+	verdict, err := parseSpamVerdict(result.Content)
+	if err != nil {
+		return verdict, err
+	}
+	b.spamVerdicts.put(conf, text, verdict)
+	return verdict, nil
+}
+
+// checkSpamLLM вторая ступень антиспама для сообщений ниже порога правил.
+// Возвращает вердикт и true, только если модель уверена, что это спам.
+func (b *Bot) checkSpamLLM(message *tgbotapi.Message, text string, score module.SpamScore, cfg Config) (spamVerdict, bool) {
+	if !b.needsSpamLLM(message, cfg, score) {
+		return spamVerdict{}, false
+	}
+
+	verdict, err := b.classifySpam(message, text, cfg)
+	if err != nil {
+		// Модель недоступна - сообщение пропускаем, правила его уже не остановили
+		log.Printf("[checkSpamLLM] %v", err)
+		return verdict, false
+	}
+	if !verdict.Spam || verdict.Confidence < cfg.SpamLLMConfidence {
+		if verdict.Spam {
+			log.Printf("[checkSpamLLM] Сообщение %s похоже на спам (%s), но уверенность ниже %.2f",
+				getUserName(message.From), verdict.Reason(score), cfg.SpamLLMConfidence)
+		}
+		return verdict, false
+	}
+	return verdict, true
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+
+	"facilitatorbot/db"
+	"facilitatorbot/module"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// SPAMRULE_USAGE подсказка по /spamrule
+const SPAMRULE_USAGE = `Использование:
+/spamrule list - правила чата и порог
+/spamrule add <тип> <вес> <шаблон> [| категория] - добавить правило
+/spamrule del <id> - удалить правило чата
+/spamrule test <текст> - проверить текст правилами (и моделью, если она включена)
+Типы: regex, keyword (все слова фразы), domain (домен ссылки), entity (text_link, mention, phone_number...)
+Например: /spamrule add keyword 0.8 быстрый заработок | заработок`
+
+// spamRulesStore кэш скомпилированных правил антиспама по чатам
+type spamRulesStore struct {
+	db *db.DB
+
+	mu   sync.Mutex
+	conf *Config // конфигурация, из которой собраны наборы; после /reload кэш сбрасывается
+	sets map[int64]*module.SpamRuleSet
+}
+
+func newSpamRulesStore(database *db.DB) *spamRulesStore {
+	return &spamRulesStore{db: database, sets: make(map[int64]*module.SpamRuleSet)}
+}
+
+// Rules правила, действующие в чате: из конфигурации и добавленные через /spamrule
+func (s *spamRulesStore) Rules(conf *Config, chatID int64) ([]module.SpamRule, error) {
+	var rules []module.SpamRule
+	for _, rule := range conf.SpamRules {
+		if rule.AppliesTo(chatID) {
+			rules = append(rules, rule)
+		}
+	}
+
+	saved, err := s.db.GetSpamRules(chatID)
+	for _, r := range saved {
+		rules = append(rules, module.SpamRule{
+			ID: r.ID, Kind: r.Kind, Pattern: r.Pattern, Weight: r.Weight, Category: r.Category, Chats: []int64{chatID},
+		})
+	}
+	return rules, err
+}
+
+// Get набор правил чата, собирается при первом обращении
+func (s *spamRulesStore) Get(conf *Config, chatID int64) *module.SpamRuleSet {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conf != conf {
+		s.conf = conf
+		s.sets = make(map[int64]*module.SpamRuleSet)
+	}
+	if set, ok := s.sets[chatID]; ok {
+		return set
+	}
+
+	rules, err := s.Rules(conf, chatID)
+	set, errs := module.CompileSpamRules(rules)
+	for _, e := range errs {
+		log.Printf("[spamRules] Чат %d: %v", chatID, e)
+	}
+	// Без правил чата из БД работаем, но не кэшируем, попробуем прочитать в следующий раз
+	if err != nil {
+		log.Printf("[spamRules] %v", err)
+		return set
+	}
+	s.sets[chatID] = set
+	return set
+}
+
+// Invalidate сбрасывает набор чата после изменения его правил
+func (s *spamRulesStore) Invalidate(chatID int64) {
+	s.mu.Lock()
+	delete(s.sets, chatID)
+	s.mu.Unlock()
+}
+
+// spamInput текст, сущности и скрытые ссылки сообщения для проверки правилами
+func spamInput(message *tgbotapi.Message) module.SpamInput {
+	input := module.SpamInput{Text: message.Text}
+	entities := message.Entities
+	if input.Text == "" {
+		input.Text = message.Caption
+		entities = message.CaptionEntities
+	}
+	for _, entity := range entities {
+		input.Entities = append(input.Entities, entity.Type)
+		if entity.URL != "" {
+			input.URLs = append(input.URLs, entity.URL)
+		}
+	}
+	return input
+}
+
+// handleSpamRule обрабатывает команду /spamrule (только для администраторов чата)
+func (b *Bot) handleSpamRule(message *tgbotapi.Message) {
+	chatID := message.Chat.ID
+	isAdmin, err := b.IsUserAdmin(chatID, message.From.ID)
+	if err != nil {
+		b.sendMessage(chatID, "Ошибка проверки прав администратора")
+		return
+	}
+	if !isAdmin {
+		b.sendMessage(chatID, "Правила антиспама доступны только администраторам")
+		return
+	}
+
+	action, args, _ := strings.Cut(strings.TrimSpace(message.CommandArguments()), " ")
+	args = strings.TrimSpace(args)
+	switch strings.ToLower(action) {
+	case "", "list":
+		b.listSpamRules(message)
+	case "add":
+		b.addSpamRule(message, args)
+	case "del", "delete", "rm":
+		id, err := strconv.ParseInt(strings.TrimPrefix(args, "#"), 10, 64)
+		if err != nil {
+			b.sendMessage(chatID, SPAMRULE_USAGE)
+			return
+		}
+		deleted, err := b.db.DeleteSpamRule(chatID, id)
+		if err != nil {
+			log.Printf("[handleSpamRule] %v", err)
+			b.sendMessage(chatID, "Не удалось удалить правило")
+			return
+		}
+		if !deleted {
+			b.sendMessage(chatID, fmt.Sprintf("Правила #%d в этом чате нет. Правила из конфигурации меняются в файле конфигурации.", id))
+			return
+		}
+		b.spamRules.Invalidate(chatID)
+		b.sendMessage(chatID, fmt.Sprintf("Правило #%d удалено", id))
+	case "test":
+		if args == "" {
+			b.sendMessage(chatID, SPAMRULE_USAGE)
+			return
+		}
+		b.testSpamRules(message, args)
+	default:
+		b.sendMessage(chatID, SPAMRULE_USAGE)
+	}
+}
+
+// addSpamRule разбирает "<тип> <вес> <шаблон> [| категория]" и сохраняет правило чата
+func (b *Bot) addSpamRule(message *tgbotapi.Message, args string) {
+	chatID := message.Chat.ID
+	fields := strings.SplitN(args, " ", 3)
+	if len(fields) < 3 {
+		b.sendMessage(chatID, SPAMRULE_USAGE)
+		return
+	}
+	weight, err := strconv.ParseFloat(strings.Replace(fields[1], ",", ".", 1), 64)
+	if err != nil {
+		b.sendMessage(chatID, fmt.Sprintf("Вес %q не число\n%s", fields[1], SPAMRULE_USAGE))
+		return
+	}
+	pattern, category, _ := strings.Cut(fields[2], " | ")
+	category = strings.TrimSpace(category)
+	if category == "" {
+		category = "правило чата"
+	}
+
+	rule := module.SpamRule{Kind: strings.ToLower(fields[0]), Pattern: strings.TrimSpace(pattern), Weight: weight, Category: category}
+	if err := module.ValidateSpamRule(rule); err != nil {
+		b.sendMessage(chatID, fmt.Sprintf("Правило не добавлено: %v", err))
+		return
+	}
+	id, err := b.db.AddSpamRule(db.SpamRule{
+		ChatID: chatID, Kind: rule.Kind, Pattern: rule.Pattern, Weight: rule.Weight, Category: rule.Category, CreatedBy: message.From.ID,
+	})
+	if err != nil {
+		log.Printf("[addSpamRule] %v", err)
+		b.sendMessage(chatID, "Не удалось сохранить правило")
+		return
+	}
+	b.spamRules.Invalidate(chatID)
+	b.sendHTML(chatID, message.MessageID, "✅ Добавлено правило "+formatSpamRule(rule, id))
+}
+
+// listSpamRules показывает правила, действующие в чате
+func (b *Bot) listSpamRules(message *tgbotapi.Message) {
+	chatID := message.Chat.ID
+	rules, err := b.spamRules.Rules(b.config(), chatID)
+	if err != nil {
+		log.Printf("[listSpamRules] %v", err)
+	}
+
+	var text strings.Builder
+	text.WriteString(fmt.Sprintf("🛡 Правила антиспама, порог %.2f", b.chatConfig(chatID).SpamThreshold))
+	if !b.chatConfig(chatID).AntispamEnabled {
+		text.WriteString(" (антиспам в чате выключен)")
+	}
+	var fromConfig, fromChat []string
+	for _, rule := range rules {
+		if rule.ID == 0 {
+			fromConfig = append(fromConfig, formatSpamRule(rule, 0))
+		} else {
+			fromChat = append(fromChat, formatSpamRule(rule, rule.ID))
+		}
+	}
+	if len(fromConfig) > 0 {
+		text.WriteString("\n\n<b>Из конфигурации:</b>\n" + strings.Join(fromConfig, "\n"))
+	}
+	if len(fromChat) > 0 {
+		text.WriteString("\n\n<b>Правила чата:</b>\n" + strings.Join(fromChat, "\n"))
+	} else {
+		text.WriteString("\n\nСвоих правил у чата нет, добавить: /spamrule add")
+	}
+	b.sendHTML(chatID, message.MessageID, text.String())
+}
+
+// testSpamRules показывает оценку текста правилами чата
+func (b *Bot) testSpamRules(message *tgbotapi.Message, text string) {
+	chatID := message.Chat.ID
+	score := b.spamRules.Get(b.config(), chatID).Score(module.SpamInput{Text: text})
+	threshold := b.chatConfig(chatID).SpamThreshold
+
+	verdict := "не спам"
+	if score.Total >= threshold {
+		verdict = "спам"
+	}
+	var reply strings.Builder
+	reply.WriteString(fmt.Sprintf("Оценка %.2f при пороге %.2f: <b>%s</b>", score.Total, threshold, verdict))
+	for _, m := range score.Matches {
+		reply.WriteString(fmt.Sprintf("\n%+.2f %s: <code>%s</code>", m.Rule.Weight, html.EscapeString(m.Rule.Category), html.EscapeString(m.Fragment)))
+	}
+	// Ниже порога показываем и ответ модели, если она проверяет сообщения чата
+	if cfg := b.chatConfig(chatID); cfg.SpamLLMEnabled && score.Total < threshold {
+		if llmVerdict, err := b.classifySpam(message, text, cfg); err != nil {
+			reply.WriteString("\n\nИИ: " + html.EscapeString(err.Error()))
+		} else {
+			reply.WriteString(fmt.Sprintf("\n\nИИ: спам %t, %s, уверенность %.2f при пороге %.2f",
+				llmVerdict.Spam, html.EscapeString(llmVerdict.Category), llmVerdict.Confidence, cfg.SpamLLMConfidence))
+		}
+	}
+	b.sendHTML(chatID, message.MessageID, reply.String())
+}
+
+// formatSpamRule строка правила для списка, id 0 - правило из конфигурации
+func formatSpamRule(rule module.SpamRule, id int64) string {
+	prefix := "•"
+	if id != 0 {
+		prefix = fmt.Sprintf("#%d", id)
+	}
+	return fmt.Sprintf("%s %s %+.2f <code>%s</code> - %s", prefix, rule.Kind, rule.Weight,
+		html.EscapeString(rule.Pattern), html.EscapeString(rule.Category))
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"facilitatorbot/module"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const TEST_ADMIN_ID = 152657363 // из allowedAdmins, права не запрашиваются у Telegram
+
+// spamRuleCommand команда /spamrule от администратора в тестовой группе
+func spamRuleCommand(messageID int, args string) *tgbotapi.Message {
+	message := testMessage(messageID, TEST_ADMIN_ID, "/spamrule "+args)
+	message.Entities = []tgbotapi.MessageEntity{{Type: "bot_command", Offset: 0, Length: len("/spamrule")}}
+	return message
+}
+
+func TestSpamRulesStoreChats(t *testing.T) {
+	const OTHER_CHAT_ID = -1009999999999
+
+	config := defaultConfig()
+	config.SpamRules = []module.SpamRule{
+		{Kind: module.SPAM_RULE_KEYWORD, Pattern: "общее", Weight: 1, Category: "везде"},
+		{Kind: module.SPAM_RULE_KEYWORD, Pattern: "тестовое", Weight: 1, Category: "тестовый чат", Chats: []int64{TEST_CHAT_ID}},
+		{Kind: module.SPAM_RULE_KEYWORD, Pattern: "чужое", Weight: 1, Category: "другой чат", Chats: []int64{OTHER_CHAT_ID}},
+	}
+	bot, _ := newTestBot(t, NewFakeLLMProvider(), config)
+	bot.handleSpamRule(spamRuleCommand(1, "add keyword 1 сохраненное | из БД"))
+
+	tests := []struct {
+		chatID int64
+		text   string
+		want   float64
+	}{
+		{chatID: TEST_CHAT_ID, text: "общее", want: 1},
+		{chatID: TEST_CHAT_ID, text: "тестовое", want: 1},
+		{chatID: TEST_CHAT_ID, text: "чужое", want: 0},
+		{chatID: TEST_CHAT_ID, text: "сохраненное", want: 1},
+		{chatID: OTHER_CHAT_ID, text: "общее", want: 1},
+		{chatID: OTHER_CHAT_ID, text: "тестовое", want: 0},
+		{chatID: OTHER_CHAT_ID, text: "чужое", want: 1},
+		{chatID: OTHER_CHAT_ID, text: "сохраненное", want: 0},
+	}
+	for _, tt := range tests {
+		score := bot.spamRules.Get(bot.config(), tt.chatID).Score(module.SpamInput{Text: tt.text})
+		if score.Total != tt.want {
+			t.Errorf("чат %d, %q: оценка %.2f, ожидалось %.2f", tt.chatID, tt.text, score.Total, tt.want)
+		}
+	}
+}
+
+func TestHandleSpamRuleAdd(t *testing.T) {
+	config := defaultConfig()
+	config.SpamRules = nil
+	bot, stub := newTestBot(t, NewFakeLLMProvider(), config)
+
+	tests := []struct {
+		args  string
+		reply string
+	}{
+		{args: "add keyword 0,8 быстрый заработок | заработок", reply: "Добавлено правило"},
+		{args: "add domain -0.5 github.com | доверенный", reply: "Добавлено правило"},
+		{args: "add regex 1 (незакрытая", reply: "Правило не добавлено"},
+		{args: "add entity 1 bold", reply: "Правило не добавлено"},
+		{args: "add keyword 0 ноль", reply: "Правило не добавлено"},
+		{args: "add keyword много слово", reply: "не число"},
+		{args: "add keyword 1", reply: "Использование"},
+	}
+	for i, tt := range tests {
+		bot.handleSpamRule(spamRuleCommand(i+1, tt.args))
+		sent := stub.sent("sendMessage")
+		if len(sent) != i+1 {
+			t.Fatalf("/spamrule %s: отправлено %d ответов, ожидалось %d", tt.args, len(sent), i+1)
+		}
+		if text := sent[i].Params["text"]; !strings.Contains(text, tt.reply) {
+			t.Errorf("/spamrule %s: ответ %q, ожидалось %q", tt.args, text, tt.reply)
+		}
+	}
+
+	rules, err := bot.db.GetSpamRules(TEST_CHAT_ID)
+	if err != nil {
+		t.Fatalf("GetSpamRules: %v", err)
+	}
+	if len(rules) != 2 {
+		t.Fatalf("сохранено %d правил, ожидалось 2: %+v", len(rules), rules)
+	}
+	if r := rules[0]; r.Kind != module.SPAM_RULE_KEYWORD || r.Pattern != "быстрый заработок" || r.Weight != 0.8 || r.Category != "заработок" {
+		t.Errorf("первое правило %+v", r)
+	}
+
+	// Отрицательный вес доверенного домена снижает оценку
+	score := bot.spamRules.Get(bot.config(), TEST_CHAT_ID).Score(module.SpamInput{Text: "быстрый заработок: https://github.com/x"})
+	if math.Abs(score.Total-0.3) > 1e-9 {
+		t.Errorf("оценка %.2f (%s), ожидалось 0.30", score.Total, score.Reason())
+	}
+}
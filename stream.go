@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"html"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const STREAM_EDIT_INTERVAL = 2 * time.Second       // не чаще одного редактирования в 2 секунды в личке (лимиты Telegram)
+const STREAM_GROUP_EDIT_INTERVAL = 3 * time.Second // в группах лимит ~20 сообщений в минуту
+const STREAM_PLACEHOLDER = "✍️ ..."
+const TG_MAX_MESSAGE_LEN = 4096 // максимальная длина сообщения Telegram в символах
+
+// streamingMessage сообщение в чате, которое дописывается по мере генерации ответа
+type streamingMessage struct {
+	bot       *Bot
+	chatID    int64
+	messageID int
+	header    string
+	footer    string
+	render    func(string) string // ответ -> Telegram HTML для итогового сообщения
+
+	mu         sync.Mutex
+	text       strings.Builder
+	lastSent   string
+	pauseUntil time.Time // Telegram вернул retry_after, до этого момента не редактируем
+}
+
+// generateAiRequestStream генерирует ответ с потоковым выводом: отправляет заглушку
+// и редактирует ее по мере поступления токенов. Если провайдер не умеет стримить
+// или стрим оборвался, используется обычный блокирующий generateAiRequest (не дольше AI_REQUEST_TIMEOUT).
+// После таймаута или отмены стрима повторной генерации нет.
+// Итоговое сообщение header + ответ + footer уже отправлено в чат, когда функция вернула nil.
+// Markdown ответа и header переводятся в Telegram HTML, footer экранируется.
+func (b *Bot) generateAiRequestStream(ctx context.Context, systemPrompt, prompt string, message *tgbotapi.Message, header, footer string) (string, error) {
+	return b.generateAiRequestStreamHTML(ctx, systemPrompt, prompt, message, header, footer, markdownToHTML)
+}
+
+// generateAiRequestStreamHTML как generateAiRequestStream, но ответ модели переводится
+// в Telegram HTML функцией render. Пока ответ генерируется, он показывается обычным текстом.
+func (b *Bot) generateAiRequestStreamHTML(ctx context.Context, systemPrompt, prompt string, message *tgbotapi.Message, header, footer string, render func(string) string) (string, error) {
+	streamer, ok := b.llm.(LLMStreamer)
+	if !ok || !b.config().AiStream {
+		return b.generateAndSend(ctx, systemPrompt, prompt, message, header, footer, render)
+	}
+
+	chatID := message.Chat.ID
+	placeholder := tgbotapi.NewMessage(chatID, strings.TrimSpace(header+"\n"+STREAM_PLACEHOLDER))
+	if b.messageThreadID(message) != 0 {
+		// Ответом, чтобы сообщение попало в тему форума
+		placeholder.ReplyToMessageID = message.MessageID
+	}
+	sent, err := b.tgBot.Send(placeholder)
+	if err != nil {
+		log.Printf("[generateAiRequestStream] Ошибка отправки заглушки: %v", err)
+		return b.generateAndSend(ctx, systemPrompt, prompt, message, header, footer, render)
+	}
+
+	sm := &streamingMessage{
+		bot:       b,
+		chatID:    chatID,
+		messageID: sent.MessageID,
+		header:    header,
+		footer:    footer,
+		render:    render,
+	}
+
+	log.Printf("[generateAiRequestStream] Начало потокового запроса к AI. ChatID: %d, Provider: %s, Model: %s", chatID, b.llm.Name(), b.config().AiModelName)
+	log.Printf("[generateAiRequestStream] User prompt[%d]: %v", len(prompt), b.truncateText(prompt, 256))
+
+	// Периодически отправляем накопленный текст
+	stopEdits := make(chan struct{})
+	editsDone := make(chan struct{})
+	go func() {
+		defer close(editsDone)
+		interval := STREAM_GROUP_EDIT_INTERVAL
+		if message.Chat.IsPrivate() {
+			interval = STREAM_EDIT_INTERVAL
+		}
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				sm.flush(false)
+			case <-stopEdits:
+				return
+			}
+		}
+	}()
+
+	streamCtx, cancel := context.WithTimeout(ctx, AI_REQUEST_TIMEOUT*time.Second)
+	result, err := streamer.Stream(streamCtx, b.buildAiMessages(systemPrompt, prompt), b.aiOptions(), sm.append)
+	cancel()
+	close(stopEdits)
+	<-editsDone
+
+	var netErr net.Error
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) || errors.As(err, &netErr) && netErr.Timeout() {
+		// Модель не ответила за отведенное время: повтор держал бы очередь чата еще столько же
+		b.deleteMessage(chatID, sm.messageID)
+		return "", err
+	}
+	if err != nil {
+		log.Printf("[generateAiRequestStream] Ошибка потокового запроса, переключаемся на обычный: %v", err)
+		fallbackCtx, cancel := context.WithTimeout(ctx, AI_REQUEST_TIMEOUT*time.Second)
+		answer, err := b.generateAiRequest(fallbackCtx, systemPrompt, prompt, message)
+		cancel()
+		if err != nil {
+			b.deleteMessage(chatID, sm.messageID)
+			return "", err
+		}
+		sm.finish(answer)
+		return answer, nil
+	}
+
+	log.Printf("[generateAiRequestStream] Потоковый ответ получен")
+	b.saveBilling(message, result)
+
+	answer := cleanAiResponse(result.Content)
+	sm.finish(answer)
+	return answer, nil
+}
+
+// generateAndSend блокирующая генерация с отправкой результата одним сообщением
+func (b *Bot) generateAndSend(ctx context.Context, systemPrompt, prompt string, message *tgbotapi.Message, header, footer string, render func(string) string) (string, error) {
+	answer, err := b.generateAiRequest(ctx, systemPrompt, prompt, message)
+	if err != nil {
+		return "", err
+	}
+	replyTo := 0
+	if b.messageThreadID(message) != 0 {
+		replyTo = message.MessageID
+	}
+	b.sendHTML(message.Chat.ID, replyTo, renderAnswerHTML(header, answer, footer, render))
+	return answer, nil
+}
+
+// renderAnswerHTML итоговое сообщение header + ответ + footer в Telegram HTML
+func renderAnswerHTML(header, answer, footer string, render func(string) string) string {
+	return strings.TrimSpace(markdownToHTML(header) + "\n" + render(answer) + html.EscapeString(footer))
+}
+
+// append добавляет фрагмент ответа (вызывается из провайдера)
+func (sm *streamingMessage) append(delta string) {
+	sm.mu.Lock()
+	sm.text.WriteString(delta)
+	sm.mu.Unlock()
+}
+
+// flush редактирует сообщение, если текст изменился с прошлой отправки
+func (sm *streamingMessage) flush(final bool) {
+	sm.mu.Lock()
+	body := sm.text.String()
+	sm.mu.Unlock()
+
+	text := strings.TrimSpace(sm.header + "\n" + body)
+	if !final {
+		text += " " + STREAM_PLACEHOLDER
+	}
+	text = truncateRunes(text, TG_MAX_MESSAGE_LEN)
+
+	if text == sm.lastSent || strings.TrimSpace(body) == "" || time.Now().Before(sm.pauseUntil) {
+		return
+	}
+
+	edit := tgbotapi.NewEditMessageText(sm.chatID, sm.messageID, text)
+	if _, err := sm.bot.tgBot.Request(edit); err != nil {
+		var tgErr *tgbotapi.Error
+		if errors.As(err, &tgErr) && tgErr.RetryAfter > 0 {
+			sm.pauseUntil = time.Now().Add(time.Duration(tgErr.RetryAfter) * time.Second)
+		}
+		log.Printf("[streamingMessage] Ошибка редактирования сообщения: %v", err)
+		return
+	}
+	sm.lastSent = text
+}
+
+// finish заменяет накопленный текст итоговым ответом в Telegram HTML.
+// Не поместившиеся в одно сообщение абзацы уходят отдельными сообщениями.
+func (sm *streamingMessage) finish(answer string) {
+	parts := splitHTMLMessage(renderAnswerHTML(sm.header, answer, sm.footer, sm.render), TG_MAX_MESSAGE_LEN)
+
+	if err := sm.bot.editHTML(sm.chatID, sm.messageID, parts[0]); err != nil {
+		log.Printf("[streamingMessage] Ошибка финального редактирования: %v", err)
+	}
+	sm.lastSent = parts[0]
+
+	for _, part := range parts[1:] {
+		sm.bot.sendHTML(sm.chatID, 0, part)
+	}
+}
+
+// deleteMessage удаляет сообщение бота
+func (b *Bot) deleteMessage(chatID int64, messageID int) {
+	if _, err := b.tgBot.Request(tgbotapi.NewDeleteMessage(chatID, messageID)); err != nil {
+		log.Printf("Не удалось удалить сообщение: %v", err)
+	}
+}
+
+// truncateRunes обрезает строку до maxLen символов (не байт)
+func truncateRunes(text string, maxLen int) string {
+	runes := []rune(text)
+	if len(runes) <= maxLen {
+		return text
+	}
+	return string(runes[:maxLen])
+}
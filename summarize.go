@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"facilitatorbot/db"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const SUMMARY_OUTPUT_SHARE = 4       // 1/4 контекста модели оставляем под ответ
+const SUMMARY_MIN_CHUNK_TOKENS = 256 // меньше части не делаем, даже если промпты большие
+
+// summaryThread ветка обсуждения: первое сообщение в периоде и ответы на него, включая ответы на ответы
+type summaryThread struct {
+	messages []db.DBMessage
+}
+
+// groupThreads раскладывает сообщения по веткам ответов. Ответы на сообщение вне периода
+// объединяются в одну ветку по этому сообщению. Ветки упорядочены по первому сообщению.
+func groupThreads(messages []db.DBMessage) []summaryThread {
+	byID := make(map[int]db.DBMessage, len(messages))
+	for _, msg := range messages {
+		if msg.MessageID != 0 {
+			byID[msg.MessageID] = msg
+		}
+	}
+
+	// rootKey корень цепочки ответов внутри периода, или сообщение вне периода, на которое отвечали
+	rootKey := func(msg db.DBMessage) string {
+		for range len(messages) {
+			parent, ok := byID[msg.ReplyToID]
+			if msg.ReplyToID == 0 || !ok {
+				break
+			}
+			msg = parent
+		}
+		switch {
+		case msg.ReplyToID != 0:
+			return fmt.Sprintf("reply:%d", msg.ReplyToID)
+		case msg.MessageID != 0:
+			return fmt.Sprintf("msg:%d", msg.MessageID)
+		default:
+			return fmt.Sprintf("row:%d", msg.ID)
+		}
+	}
+
+	var threads []summaryThread
+	index := make(map[string]int)
+	for _, msg := range messages {
+		key := rootKey(msg)
+		i, ok := index[key]
+		if !ok {
+			i = len(threads)
+			index[key] = i
+			threads = append(threads, summaryThread{})
+		}
+		threads[i].messages = append(threads[i].messages, msg)
+	}
+	return threads
+}
+
+// formatSummaryLines строки истории для промпта сводки, по одной на сообщение.
+// Ветки ответов идут подряд с заголовком, кто начал ветку, одиночные сообщения - как есть.
+// Каждое сообщение помечено номером #N (позиция в messages с 1) для ссылок на источники.
+func formatSummaryLines(messages []db.DBMessage, loc *time.Location) []string {
+	numbers := make(map[int]int, len(messages))
+	for i, msg := range messages {
+		numbers[msg.ID] = i + 1
+	}
+
+	lines := make([]string, 0, len(messages))
+	for _, thread := range groupThreads(messages) {
+		indent := ""
+		if len(thread.messages) > 1 {
+			starter := thread.messages[0]
+			lines = append(lines, fmt.Sprintf("Thread started by %s(%v), %d messages:\n",
+				starter.UserFirstName, starter.Username, len(thread.messages)))
+			indent = "  "
+		}
+		for _, msg := range thread.messages {
+			// Переводим время сообщения в часовой пояс чата
+			msgTime := time.Unix(msg.Timestamp, 0).In(loc)
+			lines = append(lines, fmt.Sprintf("%s#%d [%s] %s(%v): %s\n",
+				indent,
+				numbers[msg.ID],
+				msgTime.Format("15:04"),
+				msg.UserFirstName,
+				msg.Username,
+				msg.Text))
+		}
+	}
+	return lines
+}
+
+// summaryLinks ссылки на сообщения по их номерам #N в промпте сводки
+func summaryLinks(chatID int64, messages []db.DBMessage) map[int]string {
+	links := make(map[int]string, len(messages))
+	for i, msg := range messages {
+		if link := messageLink(chatID, msg.MessageID); link != "" {
+			links[i+1] = link
+		}
+	}
+	return links
+}
+
+// summaryChunkBudget сколько токенов истории помещается в один запрос сводки
+func summaryChunkBudget(c Config) int {
+	contextTokens := c.ContextTokens()
+	promptTokens := estimateTokens(c.LanguageSystemPrompt()) + max(
+		estimateTokens(c.SummaryPrompt),
+		estimateTokens(c.SummaryChunkPrompt),
+		estimateTokens(c.SummaryReducePrompt),
+	)
+	return max(contextTokens-contextTokens/SUMMARY_OUTPUT_SHARE-promptTokens, SUMMARY_MIN_CHUNK_TOKENS)
+}
+
+// chunkLines делит строки на части, каждая не больше budget токенов, не разрывая строки.
+// Строка длиннее budget обрезается и становится отдельной частью.
+func chunkLines(lines []string, budget int) [][]string {
+	var chunks [][]string
+	var current []string
+	currentTokens := 0
+
+	for _, line := range lines {
+		tokens := estimateTokens(line)
+		if tokens > budget {
+			line = truncateRunes(line, (budget-1)*CHARS_PER_TOKEN)
+			tokens = estimateTokens(line)
+		}
+		if currentTokens+tokens > budget && len(current) > 0 {
+			chunks = append(chunks, current)
+			current, currentTokens = nil, 0
+		}
+		current = append(current, line)
+		currentTokens += tokens
+	}
+	if len(current) > 0 {
+		chunks = append(chunks, current)
+	}
+	return chunks
+}
+
+// fitParts обрезает части так, чтобы вместе они занимали не больше budget токенов.
+// Каждая часть получает долю бюджета пропорционально своей длине.
+func fitParts(parts []string, budget int) []string {
+	total := 0
+	for _, part := range parts {
+		total += estimateTokens(part)
+	}
+	if total <= budget {
+		return parts
+	}
+
+	// "…\n\n" в конце обрезанной части занимает еще токен, и estimateTokens добавляет один
+	available := max(budget-2*len(parts), 0)
+	fitted := make([]string, len(parts))
+	for i, part := range parts {
+		limit := available * estimateTokens(part) / total * CHARS_PER_TOKEN
+		if utf8.RuneCountInString(part) > limit {
+			part = truncateRunes(part, limit) + "…\n\n"
+		}
+		fitted[i] = part
+	}
+	return fitted
+}
+
+// summaryPrompt готовит промпт итоговой сводки: если история не помещается в контекст
+// модели, части суммируются отдельно (map), а в итоговый промпт идут частичные сводки (reduce)
+func (b *Bot) summaryPrompt(ctx context.Context, c Config, message *tgbotapi.Message, lines []string) (string, error) {
+	budget := summaryChunkBudget(c)
+	chunks := chunkLines(lines, budget)
+	if len(chunks) <= 1 {
+		return fmt.Sprintf(c.SummaryPrompt, strings.Join(lines, "")), nil
+	}
+
+	for round := 1; ; round++ {
+		log.Printf("[summaryPrompt] Раунд %d: %d частей по %d токенов, контекст %d", round, len(chunks), budget, c.ContextTokens())
+
+		partials := make([]string, 0, len(chunks))
+		for i, chunk := range chunks {
+			partial, err := b.generateAiRequest(ctx, c.LanguageSystemPrompt(), fmt.Sprintf(c.SummaryChunkPrompt, strings.Join(chunk, "")), message)
+			if err != nil {
+				return "", fmt.Errorf("ошибка сводки части %d/%d: %w", i+1, len(chunks), err)
+			}
+			partials = append(partials, fmt.Sprintf("Часть %d:\n%s\n\n", i+1, partial))
+		}
+
+		next := chunkLines(partials, budget)
+		if len(next) == 1 {
+			return fmt.Sprintf(c.SummaryReducePrompt, strings.Join(partials, "")), nil
+		}
+		if len(next) >= len(chunks) {
+			// Частичные сводки не короче исходных частей, дальше не сократить
+			log.Printf("[summaryPrompt] Частичные сводки не помещаются в контекст, сокращаем каждую пропорционально длине")
+			return fmt.Sprintf(c.SummaryReducePrompt, strings.Join(fitParts(partials, budget), "")), nil
+		}
+		chunks = next
+	}
+}
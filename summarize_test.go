@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// summaryTestConfig конфигурация с маленьким контекстом, чтобы история делилась на части
+func summaryTestConfig() Config {
+	config := defaultConfig()
+	config.AiContextTokens = 1000
+	config.AiRetry = RetryPolicy{}
+	config.SummaryImageEnabled = false
+	return config
+}
+
+// summaryTestLines строки истории, заметно больше контекста модели
+func summaryTestLines(count int) []string {
+	lines := make([]string, 0, count)
+	for i := 1; i <= count; i++ {
+		lines = append(lines, fmt.Sprintf("#%d [12:%02d] User(user%d): %s\n", i, i%60, i, strings.Repeat("обсуждаем сводки ", 6)))
+	}
+	return lines
+}
+
+// assertCallsFitContext проверяет, что ни один запрос к модели не вышел за контекст за вычетом доли под ответ
+func assertCallsFitContext(t *testing.T, llm *FakeLLMProvider, config Config) {
+	t.Helper()
+	limit := config.ContextTokens() - config.ContextTokens()/SUMMARY_OUTPUT_SHARE
+	if llm.CallCount() == 0 {
+		t.Fatal("модель не вызывалась")
+	}
+	for i, call := range llm.Calls {
+		tokens := 0
+		for _, msg := range call {
+			tokens += estimateTokens(msg.Content)
+		}
+		if tokens > limit {
+			t.Errorf("запрос %d: %d токенов, контекст для истории %d", i+1, tokens, limit)
+		}
+	}
+}
+
+func TestSummaryPromptFitsContext(t *testing.T) {
+	config := summaryTestConfig()
+	limit := config.ContextTokens() - config.ContextTokens()/SUMMARY_OUTPUT_SHARE
+
+	tests := []struct {
+		name    string
+		partial string
+	}{
+		{name: "короткие частичные сводки", partial: "Обсуждали сводки [#1]."},
+		// Модель пересказывает часть почти дословно: частичные сводки не короче исходных частей
+		{name: "частичные сводки не сокращаются", partial: strings.Repeat("Обсуждали сводки подробно [#1]. ", 80)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			llm := NewFakeLLMProvider(tt.partial)
+			bot, _ := newTestBot(t, llm, config)
+
+			lines := summaryTestLines(200)
+			chunks := chunkLines(lines, summaryChunkBudget(config))
+			if len(chunks) < 2 {
+				t.Fatalf("история должна делиться на части, получилось %d", len(chunks))
+			}
+
+			prompt, err := bot.summaryPrompt(context.Background(), config, testMessage(1, 1, "/summary"), lines)
+			if err != nil {
+				t.Fatalf("summaryPrompt: %v", err)
+			}
+			assertCallsFitContext(t, llm, config)
+
+			if tokens := estimateTokens(config.LanguageSystemPrompt()) + estimateTokens(prompt); tokens > limit {
+				t.Errorf("итоговый промпт: %d токенов, контекст для истории %d", tokens, limit)
+			}
+			// Каждая часть попадает в итоговый промпт, пусть и сокращенной
+			for i := 1; i <= len(chunks); i++ {
+				if !strings.Contains(prompt, fmt.Sprintf("Часть %d:", i)) {
+					t.Errorf("в итоговом промпте нет части %d", i)
+				}
+			}
+		})
+	}
+}
+
+func TestFitParts(t *testing.T) {
+	parts := []string{strings.Repeat("а", 3000), strings.Repeat("б", 300), "короткая\n\n"}
+	budget := 200
+
+	fitted := fitParts(parts, budget)
+	total := 0
+	for _, part := range fitted {
+		total += estimateTokens(part)
+	}
+	if total > budget {
+		t.Errorf("после сокращения %d токенов, бюджет %d", total, budget)
+	}
+	if len([]rune(fitted[0])) <= len([]rune(fitted[1])) {
+		t.Errorf("длинная часть должна получить большую долю: %d и %d символов", len([]rune(fitted[0])), len([]rune(fitted[1])))
+	}
+
+	if fitted := fitParts(parts[2:], budget); fitted[0] != parts[2] {
+		t.Errorf("часть в пределах бюджета изменена: %q", fitted[0])
+	}
+}
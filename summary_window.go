@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// SUMMARY_USAGE подсказка по периодам /summary
+const SUMMARY_USAGE = `Примеры: /summary 50, /summary 3h, /summary today, /summary yesterday, /summary since <ссылка на сообщение> или /summary ответом на сообщение`
+
+// summaryWindow период сообщений для /summary
+type summaryWindow struct {
+	From   time.Time
+	To     time.Time
+	Count  int    // максимум сообщений (последние в периоде)
+	Label  string // описание периода для заголовка, пусто - период по умолчанию
+	Capped bool   // запрошено больше сообщений, чем разрешено
+}
+
+var durationArgRe = regexp.MustCompile(`^(\d+)\s*(m|min|мин|м|h|ч|d|д|w|н)$`)
+var messageLinkRe = regexp.MustCompile(`^(?:https?://)?t\.me/(?:c/(\d+)|([A-Za-z0-9_]+))/(?:\d+/)?(\d+)/?$`)
+
+// parseDurationArg разбирает "3h", "90m", "2d", "1w" (и русские ч, м, д, н)
+func parseDurationArg(arg string) (time.Duration, bool) {
+	match := durationArgRe.FindStringSubmatch(strings.ToLower(arg))
+	if match == nil {
+		return 0, false
+	}
+	n, err := strconv.Atoi(match[1])
+	if err != nil || n <= 0 {
+		return 0, false
+	}
+	unit := map[string]time.Duration{
+		"m": time.Minute, "min": time.Minute, "мин": time.Minute, "м": time.Minute,
+		"h": time.Hour, "ч": time.Hour,
+		"d": 24 * time.Hour, "д": 24 * time.Hour,
+		"w": 7 * 24 * time.Hour, "н": 7 * 24 * time.Hour,
+	}[match[2]]
+	return time.Duration(n) * unit, true
+}
+
+// parseMessageLink возвращает message_id из ссылки на сообщение этого чата
+// (t.me/c/<id>/<msg>, t.me/<username>/<msg>, в том числе ссылки на сообщения в темах)
+func parseMessageLink(link string, chat *tgbotapi.Chat) (int, error) {
+	match := messageLinkRe.FindStringSubmatch(link)
+	if match == nil {
+		return 0, fmt.Errorf("не похоже на ссылку на сообщение: %s", link)
+	}
+
+	switch {
+	case match[1] != "":
+		if fmt.Sprintf("-100%s", match[1]) != strconv.FormatInt(chat.ID, 10) {
+			return 0, fmt.Errorf("ссылка ведет в другой чат")
+		}
+	case !strings.EqualFold(match[2], chat.UserName):
+		return 0, fmt.Errorf("ссылка ведет в другой чат")
+	}
+
+	messageID, err := strconv.Atoi(match[3])
+	if err != nil {
+		return 0, fmt.Errorf("некорректный номер сообщения в ссылке")
+	}
+	return messageID, nil
+}
+
+// startOfDay полночь дня t в часовом поясе loc
+func startOfDay(t time.Time, loc *time.Location) time.Time {
+	local := t.In(loc)
+	return time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, loc)
+}
+
+// parseSummaryWindow определяет период сводки по аргументам команды и reply.
+//
+//	/summary                  - последние limit сообщений за сутки
+//	/summary 50               - последние 50 сообщений за сутки
+//	/summary 3h | 90m | 2d    - за последние 3 часа, 90 минут, 2 дня
+//	/summary today | сегодня  - с полуночи
+//	/summary yesterday | вчера - за вчерашний день
+//	/summary since <ссылка>   - с указанного сообщения
+//	/summary в ответ на сообщение - с этого сообщения
+func (b *Bot) parseSummaryWindow(message *tgbotapi.Message, limit int, now time.Time) (summaryWindow, error) {
+	loc := b.chatConfig(message.Chat.ID).Location()
+	window := summaryWindow{From: now.Add(24 * -time.Hour), To: now, Count: limit}
+
+	args := strings.Fields(message.CommandArguments())
+	if len(args) == 0 {
+		// Ответ на сообщение - сводка с него
+		if reply := message.ReplyToMessage; reply != nil && reply.From != nil && reply.From.ID != b.tgBot.Self.ID {
+			window.From = reply.Time()
+			window.Label = "с " + window.From.In(loc).Format("02.01 15:04")
+		}
+		return window, nil
+	}
+
+	arg := strings.ToLower(args[0])
+	if num, err := strconv.Atoi(arg); err == nil && num > 0 {
+		window.Count = min(num, limit)
+		window.Capped = num > limit
+		return window, nil
+	}
+	if d, ok := parseDurationArg(strings.Join(args, "")); ok {
+		window.From = now.Add(-d)
+		window.Label = "за " + strings.Join(args, " ")
+		return window, nil
+	}
+
+	switch arg {
+	case "today", "сегодня":
+		window.From = startOfDay(now, loc)
+		window.Label = "за сегодня"
+	case "yesterday", "вчера":
+		today := startOfDay(now, loc)
+		window.From = today.AddDate(0, 0, -1)
+		window.To = today.Add(-time.Second)
+		window.Label = "за вчера"
+	case "since", "с", "от":
+		if len(args) < 2 {
+			return window, fmt.Errorf("укажите ссылку на сообщение: /summary since https://t.me/c/.../123")
+		}
+		messageID, err := parseMessageLink(args[1], message.Chat)
+		if err != nil {
+			return window, err
+		}
+		timestamp, err := b.db.GetMessageTimestamp(message.Chat.ID, messageID)
+		if err != nil {
+			return window, err
+		}
+		window.From = time.Unix(timestamp, 0)
+		window.Label = "с " + window.From.In(loc).Format("02.01 15:04")
+	default:
+		return window, fmt.Errorf("не понял период %q", args[0])
+	}
+	return window, nil
+}
@@ -59,11 +59,11 @@ func (b *Bot) canBotReadMessages(chatID int64) bool {
 
 // isChatAllowed проверяет разрешен ли чат
 func (b *Bot) isChatAllowed(chatID int64) bool {
-	if len(b.config.AllowedGroups) == 0 {
+	if len(b.config().AllowedGroups) == 0 {
 		return true
 	}
 
-	for _, id := range b.config.AllowedGroups {
+	for _, id := range b.config().AllowedGroups {
 		if id == chatID {
 			return true
 		}
@@ -77,9 +77,8 @@ func (b *Bot) isBotMentioned(message *tgbotapi.Message) bool {
 	lowerText := strings.ToLower(message.Text)
 
 	// Проверяем обращения по ключевым словам
-	keywords := []string{"sheriff", "шериф", "шерифф"}
-	for _, kw := range keywords {
-		if strings.HasPrefix(lowerText, kw) {
+	for _, kw := range b.chatConfig(message.Chat.ID).BotKeywords {
+		if strings.HasPrefix(lowerText, strings.ToLower(kw)) {
 			return true
 		}
 	}
@@ -103,12 +102,20 @@ func (b *Bot) isBotMentioned(message *tgbotapi.Message) bool {
 func (b *Bot) getHelp() string {
 	return `Доступные команды:
 /help - показать это сообщение
-/summary [N] - получить сводку обсуждений (N - количество сообщений, по умолчанию 100)
+/summary [N | 3h | today | yesterday | since <ссылка>] - сводка обсуждений за период (или ответом на сообщение - с него), в теме форума - только по теме
+/search <запрос> - найти в истории чата сообщения, близкие по смыслу
+/find <слова> [from:@user] [since:7d] [until:2024-05-31] - найти сообщения по словам
 /anekdot - придумать анекдот по темам обсуждения
 /tema - продолжить обсуждение темы
 /stats - показать статистику сообщений и благодарностей
 /aistats - показать статистику использования AI (только для администраторов)
+/digest on 21:00 | weekly mon 10:00 | every 300 | off - автоматический дайджест (настраивают администраторы)
+/spamrule list | add <тип> <вес> <шаблон> | del <id> | test <текст> - правила антиспама чата (только для администраторов)
+/incidents [<id> | stats | whitelist] - инциденты антиспама, их проверка и точность правил (только для администраторов)
+/settings - настройки чата: благодарности, антиспам, проверка спама ИИ, капча, описание фото, голосовые, сводка, язык (только для администраторов)
+/reload - перечитать конфигурацию без перезапуска (только для администраторов бота)
 /clear или /забудь - очистить контекст общения
+/cancel или /стоп - остановить текущую генерацию ответа
 /ping или /пинг - проверить работоспособность бота
 
 Вы также можете обратиться ко мне напрямую:
@@ -122,12 +129,12 @@ func (b *Bot) getHelp() string {
 }
 
 // removeBotMention удаляет упоминание бота из текста сообщения
-func (b *Bot) removeBotMention(text string) string {
+func (b *Bot) removeBotMention(chatID int64, text string) string {
 	lowerText := strings.ToLower(text)
 
 	// Удаляем ключевые слова
-	keywords := []string{"sheriff:", "шериф:", "шерифф:"}
-	for _, kw := range keywords {
+	for _, kw := range b.chatConfig(chatID).BotKeywords {
+		kw = strings.ToLower(kw) + ":"
 		if strings.HasPrefix(lowerText, kw) {
 			return strings.TrimSpace(text[len(kw):])
 		}
@@ -390,6 +397,8 @@ func getMessageType(msg *tgbotapi.Message) string {
 		return "аудио"
 	case msg.Voice != nil:
 		return "голосовое"
+	case msg.VideoNote != nil:
+		return "видеосообщение"
 	case msg.Sticker != nil:
 		return "стикер"
 	case msg.Location != nil:
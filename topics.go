@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Тема сообщения забывается после его обработки. TTL - только для сообщений, которые так и не
+// обработали (отброшены при переполнении очереди): в очереди сообщение может ждать часами.
+const TOPIC_CACHE_TTL = 24 * time.Hour
+const POLL_RETRY_DELAY = 3 * time.Second // пауза после ошибки getUpdates
+
+// rawTopicMessage поля тем форума, которых нет в telegram-bot-api v5.5.1
+type rawTopicMessage struct {
+	MessageID       int  `json:"message_id"`
+	MessageThreadID int  `json:"message_thread_id"`
+	IsTopicMessage  bool `json:"is_topic_message"`
+	Chat            struct {
+		ID int64 `json:"id"`
+	} `json:"chat"`
+}
+
+type rawTopicUpdate struct {
+	Message       *rawTopicMessage `json:"message"`
+	EditedMessage *rawTopicMessage `json:"edited_message"`
+}
+
+type topicKey struct {
+	chatID    int64
+	messageID int
+}
+
+type topicEntry struct {
+	threadID int
+	seen     time.Time
+}
+
+// messageTopics темы форума входящих сообщений, заполняется при разборе сырых обновлений
+type messageTopics struct {
+	mu     sync.Mutex
+	topics map[topicKey]topicEntry
+}
+
+func newMessageTopics() *messageTopics {
+	return &messageTopics{topics: make(map[topicKey]topicEntry)}
+}
+
+// record запоминает темы сообщений из JSON одного обновления или массива обновлений
+func (t *messageTopics) record(raw []byte) {
+	var updates []rawTopicUpdate
+	if err := json.Unmarshal(raw, &updates); err != nil {
+		var update rawTopicUpdate
+		if err := json.Unmarshal(raw, &update); err != nil {
+			return
+		}
+		updates = []rawTopicUpdate{update}
+	}
+
+	now := time.Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for key, entry := range t.topics {
+		if now.Sub(entry.seen) > TOPIC_CACHE_TTL {
+			delete(t.topics, key)
+		}
+	}
+	for _, update := range updates {
+		for _, msg := range []*rawTopicMessage{update.Message, update.EditedMessage} {
+			// В обычных супергруппах message_thread_id есть и у ответов, тема - только с is_topic_message
+			if msg == nil || !msg.IsTopicMessage || msg.MessageThreadID == 0 {
+				continue
+			}
+			t.topics[topicKey{msg.Chat.ID, msg.MessageID}] = topicEntry{threadID: msg.MessageThreadID, seen: now}
+		}
+	}
+}
+
+// forget удаляет темы сообщений обновления после его обработки
+func (t *messageTopics) forget(update tgbotapi.Update) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, msg := range []*tgbotapi.Message{update.Message, update.EditedMessage} {
+		if msg != nil && msg.Chat != nil {
+			delete(t.topics, topicKey{msg.Chat.ID, msg.MessageID})
+		}
+	}
+}
+
+// Get тема форума сообщения, 0 - сообщение не в теме
+func (t *messageTopics) Get(chatID int64, messageID int) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.topics[topicKey{chatID, messageID}].threadID
+}
+
+// messageThreadID тема форума сообщения, 0 - общий чат
+func (b *Bot) messageThreadID(message *tgbotapi.Message) int {
+	return b.topics.Get(message.Chat.ID, message.MessageID)
+}
+
+// replyToMessageID на какое сообщение отвечает message. В теме форума сообщение без ответа
+// ссылается на служебное сообщение создания темы, это не ответ.
+func (b *Bot) replyToMessageID(message *tgbotapi.Message) int {
+	if message.ReplyToMessage == nil || message.ReplyToMessage.MessageID == b.messageThreadID(message) {
+		return 0
+	}
+	return message.ReplyToMessage.MessageID
+}
+
+// sendToTopic отправляет текст в тему сообщения message (ответом, в v5.5.1 нет message_thread_id),
+// вне тем - обычным сообщением в чат
+func (b *Bot) sendToTopic(message *tgbotapi.Message, text string) {
+	msg := tgbotapi.NewMessage(message.Chat.ID, text)
+	if b.messageThreadID(message) != 0 {
+		msg.ReplyToMessageID = message.MessageID
+	}
+	if _, err := b.tgBot.Send(msg); err != nil {
+		log.Printf("Ошибка отправки сообщения: %v", err)
+	}
+}
+
+// pollUpdates получает обновления long polling, как BotAPI.GetUpdatesChan, но разбирает
+// сырой ответ сам, чтобы запомнить темы форума. Канал закрывается после отмены ctx.
+func (b *Bot) pollUpdates(ctx context.Context, config tgbotapi.UpdateConfig) <-chan tgbotapi.Update {
+	ch := make(chan tgbotapi.Update, b.tgBot.Buffer)
+
+	go func() {
+		defer close(ch)
+		for ctx.Err() == nil {
+			resp, err := b.tgBot.Request(config)
+			if err != nil {
+				log.Printf("[pollUpdates] Ошибка получения обновлений: %v", err)
+				select {
+				case <-ctx.Done():
+				case <-time.After(POLL_RETRY_DELAY):
+				}
+				continue
+			}
+
+			var updates []tgbotapi.Update
+			if err := json.Unmarshal(resp.Result, &updates); err != nil {
+				log.Printf("[pollUpdates] Ошибка декодирования обновлений: %v", err)
+				continue
+			}
+			b.topics.record(resp.Result)
+
+			for _, update := range updates {
+				select {
+				case ch <- update:
+				case <-ctx.Done():
+					return
+				}
+				// Смещение запоминаем после передачи, чтобы при переподключении не потерять обновление
+				if update.UpdateID >= config.Offset {
+					config.Offset = update.UpdateID + 1
+					b.updateOffset.Store(int64(config.Offset))
+				}
+			}
+		}
+	}()
+
+	return ch
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"facilitatorbot/db"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const TEST_TOPIC_ID = 42
+
+// recordTopicMessage запоминает сообщение messageID как сообщение темы TEST_TOPIC_ID
+func recordTopicMessage(bot *Bot, messageID int) {
+	bot.topics.record([]byte(fmt.Sprintf(`{"update_id":%d,"message":{"message_id":%d,"message_thread_id":%d,"is_topic_message":true,"chat":{"id":%d}}}`,
+		messageID, messageID, TEST_TOPIC_ID, int64(TEST_CHAT_ID))))
+}
+
+func TestMessageTopicsForgetAfterProcessing(t *testing.T) {
+	bot, _ := newTestBot(t, NewFakeLLMProvider(), defaultConfig())
+
+	recordTopicMessage(bot, 10)
+	message := testMessage(10, 1, "сообщение в теме")
+	if got := bot.messageThreadID(message); got != TEST_TOPIC_ID {
+		t.Fatalf("тема сообщения %d, ожидалась %d", got, TEST_TOPIC_ID)
+	}
+
+	// Тема нужна все время обработки, после нее запись удаляется
+	bot.handleUpdate(tgbotapi.Update{UpdateID: 10, Message: message})
+	if got := bot.messageThreadID(message); got != 0 {
+		t.Errorf("после обработки тема %d, запись должна быть удалена", got)
+	}
+}
+
+func TestGetMessagesRangeGeneralTopic(t *testing.T) {
+	bot, _ := newTestBot(t, NewFakeLLMProvider(), defaultConfig())
+
+	now := time.Now()
+	for i := 1; i <= 4; i++ {
+		if i%2 == 0 {
+			recordTopicMessage(bot, i)
+		}
+		message := testMessage(i, 1, fmt.Sprintf("сообщение %d", i))
+		message.Date = int(now.Add(-time.Duration(i) * time.Minute).Unix())
+		bot.storeMessage(message, "")
+	}
+
+	from, to := now.Add(-time.Hour).Unix(), now.Unix()
+	tests := []struct {
+		name     string
+		threadID int
+		want     int
+	}{
+		{name: "общая тема", threadID: 0, want: 2},
+		{name: "тема форума", threadID: TEST_TOPIC_ID, want: 2},
+		{name: "все темы", threadID: db.ALL_THREADS, want: 4},
+	}
+	for _, tt := range tests {
+		messages, err := bot.db.GetMessagesRange(TEST_CHAT_ID, tt.threadID, from, to, 0)
+		if err != nil {
+			t.Fatalf("GetMessagesRange: %v", err)
+		}
+		if len(messages) != tt.want {
+			t.Errorf("%s: %d сообщений, ожидалось %d", tt.name, len(messages), tt.want)
+		}
+		for _, msg := range messages {
+			if tt.threadID != db.ALL_THREADS && msg.ThreadID != tt.threadID {
+				t.Errorf("%s: сообщение из темы %d", tt.name, msg.ThreadID)
+			}
+		}
+	}
+}
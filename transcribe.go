@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const TRANSCRIBE_TIMEOUT = 2 * time.Minute // скачивание и расшифровка одного голосового
+const TRANSCRIPT_MAX_LEN = 3000            // длиннее расшифровку обрезаем перед сохранением
+
+// transcriptionResponse ответ /v1/audio/transcriptions с response_format=json
+type transcriptionResponse struct {
+	Text string `json:"text"`
+}
+
+// voiceFile голосовое или видеосообщение: файл, размер и пометка для истории
+type voiceFile struct {
+	fileID   string
+	fileSize int
+	fileName string // расширение подсказывает серверу формат
+	kind     string
+}
+
+// messageVoice голосовое или видеосообщение из message, nil - его нет
+func messageVoice(message *tgbotapi.Message) *voiceFile {
+	switch {
+	case message.Voice != nil:
+		return &voiceFile{fileID: message.Voice.FileID, fileSize: message.Voice.FileSize, fileName: "voice.ogg", kind: "голосовое"}
+	case message.VideoNote != nil:
+		return &voiceFile{fileID: message.VideoNote.FileID, fileSize: message.VideoNote.FileSize, fileName: "video.mp4", kind: "видеосообщение"}
+	}
+	return nil
+}
+
+// transcribeAudio отправляет файл в Whisper-совместимый /v1/audio/transcriptions
+func (b *Bot) transcribeAudio(ctx context.Context, audio []byte, fileName string) (string, error) {
+	cfg := b.config()
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("file", fileName)
+	if err != nil {
+		return "", fmt.Errorf("ошибка формирования запроса: %v", err)
+	}
+	if _, err := part.Write(audio); err != nil {
+		return "", fmt.Errorf("ошибка формирования запроса: %v", err)
+	}
+	form.WriteField("model", cfg.TranscribeModel)
+	form.WriteField("response_format", "json")
+	if err := form.Close(); err != nil {
+		return "", fmt.Errorf("ошибка формирования запроса: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, cfg.TranscribeURL, &body)
+	if err != nil {
+		return "", fmt.Errorf("ошибка создания HTTP запроса: %v", err)
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+
+	resp, err := b.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("ошибка HTTP запроса: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(&io.LimitedReader{R: resp.Body, N: 4096})
+		return "", newLLMStatusError(resp, string(body))
+	}
+
+	var response transcriptionResponse
+	if err := json.NewDecoder(&io.LimitedReader{R: resp.Body, N: 1024 * 1024}).Decode(&response); err != nil {
+		return "", fmt.Errorf("ошибка декодирования ответа: %v", err)
+	}
+	return strings.Join(strings.Fields(response.Text), " "), nil
+}
+
+// transcribeVoice расшифровка голосового или видеосообщения, если это включено для чата.
+// Пустая строка - это не голосовое, оно слишком большое или распознать не удалось.
+func (b *Bot) transcribeVoice(message *tgbotapi.Message) string {
+	voice := messageVoice(message)
+	cfg := b.config()
+	if voice == nil || cfg.TranscribeURL == "" || !b.chatConfig(message.Chat.ID).VoiceEnabled {
+		return ""
+	}
+	// Если Telegram не указал размер (0), проверка пропускается: лимит соблюдает downloadFile
+	if voice.fileSize > cfg.TranscribeMaxBytes {
+		log.Printf("[transcribeVoice] %s в чате %d больше %d байт, пропускаем", voice.kind, message.Chat.ID, cfg.TranscribeMaxBytes)
+		return ""
+	}
+
+	ctx, cancel := context.WithTimeout(b.workCtx, TRANSCRIBE_TIMEOUT)
+	defer cancel()
+
+	audio, err := b.downloadFile(ctx, voice.fileID, cfg.TranscribeMaxBytes)
+	if err != nil {
+		log.Printf("[transcribeVoice] %v", err)
+		return ""
+	}
+	transcript, err := b.transcribeAudio(ctx, audio, voice.fileName)
+	if err != nil {
+		log.Printf("[transcribeVoice] Ошибка расшифровки: %v", err)
+		return ""
+	}
+	log.Printf("[transcribeVoice] %s от %s: %d символов", voice.kind, getUserName(message.From), len([]rune(transcript)))
+	return truncateRunes(transcript, TRANSCRIPT_MAX_LEN)
+}
+
+// replyTranscript отвечает на голосовое его расшифровкой
+func (b *Bot) replyTranscript(message *tgbotapi.Message, transcript string) {
+	b.sendHTML(message.Chat.ID, message.MessageID, "🎙 <i>"+html.EscapeString(transcript)+"</i>")
+}
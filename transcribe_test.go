@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTranscribeAudio(t *testing.T) {
+	audio := []byte("OggS fake voice")
+
+	var got struct {
+		fileName, file, model, format string
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+		data, _ := io.ReadAll(file)
+		got.fileName, got.file = header.Filename, string(data)
+		got.model, got.format = r.FormValue("model"), r.FormValue("response_format")
+
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"text":"  Привет,\n всем   в чате "}`)
+	}))
+	t.Cleanup(server.Close)
+
+	config := defaultConfig()
+	config.TranscribeURL = server.URL + "/v1/audio/transcriptions"
+	bot, _ := newTestBot(t, NewFakeLLMProvider(), config)
+
+	text, err := bot.transcribeAudio(context.Background(), audio, "voice.ogg")
+	if err != nil {
+		t.Fatalf("transcribeAudio: %v", err)
+	}
+	if text != "Привет, всем в чате" {
+		t.Errorf("расшифровка %q, ожидалось %q", text, "Привет, всем в чате")
+	}
+	if got.fileName != "voice.ogg" || got.file != string(audio) {
+		t.Errorf("файл %q с содержимым %q, ожидался voice.ogg с %q", got.fileName, got.file, audio)
+	}
+	if got.model != config.TranscribeModel {
+		t.Errorf("model %q, ожидалось %q", got.model, config.TranscribeModel)
+	}
+	if got.format != "json" {
+		t.Errorf("response_format %q, ожидалось json", got.format)
+	}
+}
+
+func TestTranscribeAudioStatusError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error":"unsupported audio"}`, http.StatusBadRequest)
+	}))
+	t.Cleanup(server.Close)
+
+	config := defaultConfig()
+	config.TranscribeURL = server.URL + "/v1/audio/transcriptions"
+	bot, _ := newTestBot(t, NewFakeLLMProvider(), config)
+
+	_, err := bot.transcribeAudio(context.Background(), []byte("not audio"), "voice.ogg")
+	var statusErr *LLMStatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusBadRequest {
+		t.Fatalf("ожидалась ошибка статуса 400, получено %v", err)
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const VISION_TIMEOUT = 60 * time.Second // скачивание и описание одного фото
+const VISION_MAX_TOKENS = 200           // описание должно быть коротким
+const VISION_MAX_LEN = 500              // длиннее описание обрезаем перед сохранением
+
+// visionImageURL картинка в запросе, URL - data:image/...;base64,...
+type visionImageURL struct {
+	URL string `json:"url"`
+}
+
+// visionContent часть сообщения OpenAI-совместимого запроса: текст или картинка
+type visionContent struct {
+	Type     string          `json:"type"`
+	Text     string          `json:"text,omitempty"`
+	ImageURL *visionImageURL `json:"image_url,omitempty"`
+}
+
+// visionMessage сообщение из нескольких частей
+type visionMessage struct {
+	Role    string          `json:"role"`
+	Content []visionContent `json:"content"`
+}
+
+// visionRequest запрос к /v1/chat/completions с картинкой
+type visionRequest struct {
+	Model     string          `json:"model"`
+	Messages  []visionMessage `json:"messages"`
+	MaxTokens int             `json:"max_tokens,omitempty"`
+}
+
+// pickPhotoSize самый крупный вариант фото не больше maxBytes, nil - подходящего нет.
+// Telegram присылает размеры по возрастанию, FileSize может быть не указан.
+func pickPhotoSize(photos []tgbotapi.PhotoSize, maxBytes int) *tgbotapi.PhotoSize {
+	for i := len(photos) - 1; i >= 0; i-- {
+		if photos[i].FileSize <= maxBytes {
+			return &photos[i]
+		}
+	}
+	return nil
+}
+
+// downloadFile скачивает файл Telegram, не больше maxBytes
+func (b *Bot) downloadFile(ctx context.Context, fileID string, maxBytes int) ([]byte, error) {
+	fileURL, err := b.tgBot.GetFileDirectURL(fileID)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения файла: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fileURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка создания HTTP запроса: %v", err)
+	}
+	resp, err := b.httpClient.Do(req)
+	if err != nil {
+		// В ошибке URL с токеном бота, в лог его не пишем
+		return nil, fmt.Errorf("ошибка скачивания файла")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("ошибка скачивания файла: статус %d", resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(&io.LimitedReader{R: resp.Body, N: int64(maxBytes) + 1})
+	if err != nil {
+		return nil, fmt.Errorf("ошибка чтения файла: %v", err)
+	}
+	if len(data) > maxBytes {
+		return nil, fmt.Errorf("файл больше %d байт", maxBytes)
+	}
+	return data, nil
+}
+
+// describeImage просит vision-модель кратко описать картинку
+func (b *Bot) describeImage(ctx context.Context, image []byte, message *tgbotapi.Message) (string, error) {
+	cfg := b.config()
+	url := cfg.VisionURL
+	if url == "" {
+		url = cfg.LocalLLMUrl
+	}
+	model := cfg.VisionModel
+	if model == "" {
+		model = cfg.AiModelName
+	}
+
+	dataURL := "data:" + http.DetectContentType(image) + ";base64," + base64.StdEncoding.EncodeToString(image)
+	request := visionRequest{
+		Model: model,
+		Messages: []visionMessage{{
+			Role: "user",
+			Content: []visionContent{
+				{Type: "text", Text: cfg.VisionPrompt},
+				{Type: "image_url", ImageURL: &visionImageURL{URL: dataURL}},
+			},
+		}},
+		MaxTokens: VISION_MAX_TOKENS,
+	}
+
+	jsonData, err := json.Marshal(request)
+	if err != nil {
+		return "", fmt.Errorf("ошибка маршалинга запроса: %v", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(jsonData))
+	if err != nil {
+		return "", fmt.Errorf("ошибка создания HTTP запроса: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := b.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("ошибка HTTP запроса: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(&io.LimitedReader{R: resp.Body, N: 4096})
+		return "", newLLMStatusError(resp, string(body))
+	}
+
+	result, err := decodeOpenAIResponse(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	b.saveBilling(message, result)
+
+	description := strings.Join(strings.Fields(cleanAiResponse(result.Content)), " ")
+	return truncateRunes(description, VISION_MAX_LEN), nil
+}
+
+// describePhoto описание фото из сообщения, если это включено для чата.
+// Пустая строка - фото нет, оно слишком большое или модель не ответила.
+func (b *Bot) describePhoto(message *tgbotapi.Message) string {
+	if len(message.Photo) == 0 || !b.chatConfig(message.Chat.ID).VisionEnabled {
+		return ""
+	}
+	maxBytes := b.config().VisionMaxBytes
+	photo := pickPhotoSize(message.Photo, maxBytes)
+	if photo == nil {
+		log.Printf("[describePhoto] Фото в чате %d больше %d байт, пропускаем", message.Chat.ID, maxBytes)
+		return ""
+	}
+
+	ctx, cancel := context.WithTimeout(b.workCtx, VISION_TIMEOUT)
+	defer cancel()
+
+	image, err := b.downloadFile(ctx, photo.FileID, maxBytes)
+	if err != nil {
+		log.Printf("[describePhoto] %v", err)
+		return ""
+	}
+	description, err := b.describeImage(ctx, image, message)
+	if err != nil {
+		log.Printf("[describePhoto] Ошибка описания фото: %v", err)
+		return ""
+	}
+	log.Printf("[describePhoto] Фото от %s: %s", getUserName(message.From), description)
+	return description
+}
+
+// mediaKind пометка вида медиа для истории: "фото", "голосовое" или "видеосообщение"
+func mediaKind(message *tgbotapi.Message) string {
+	if voice := messageVoice(message); voice != nil {
+		return voice.kind
+	}
+	return "фото"
+}
+
+// mediaText текст медиа-сообщения для истории: подпись и содержимое с пометкой вида
+// в квадратных скобках, например "[фото: кот на диване]"
+func mediaText(caption, kind, content string) string {
+	if content == "" {
+		return caption
+	}
+	return strings.TrimSpace(caption + "\n[" + kind + ": " + content + "]")
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const WEBHOOK_SECRET_HEADER = "X-Telegram-Bot-Api-Secret-Token"
+const WEBHOOK_MAX_BODY = 1 << 20 // 1MB, обновления Telegram заметно меньше
+
+// WebhookHandler принимает обновления Telegram по HTTP и передает их в обработку.
+// Не зависит от BotAPI, поэтому проверяется обычным HTTP клиентом с записанным JSON Update.
+type WebhookHandler struct {
+	secret   string                      // ожидаемое значение заголовка secret_token, пусто - не проверяем
+	dispatch func(tgbotapi.Update) error // обычно Bot.dispatchUpdate
+	topics   *messageTopics              // куда запоминать темы форума, nil - не запоминать
+}
+
+// NewWebhookHandler создает обработчик вебхука
+func NewWebhookHandler(secret string, dispatch func(tgbotapi.Update) error, topics *messageTopics) *WebhookHandler {
+	return &WebhookHandler{secret: secret, dispatch: dispatch, topics: topics}
+}
+
+func (h *WebhookHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if h.secret != "" {
+		token := r.Header.Get(WEBHOOK_SECRET_HEADER)
+		if subtle.ConstantTimeCompare([]byte(token), []byte(h.secret)) != 1 {
+			log.Printf("[Webhook] Неверный secret_token от %s", r.RemoteAddr)
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, WEBHOOK_MAX_BODY))
+	if err != nil {
+		log.Printf("[Webhook] Ошибка чтения обновления: %v", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	var update tgbotapi.Update
+	if err := json.Unmarshal(body, &update); err != nil {
+		log.Printf("[Webhook] Ошибка декодирования обновления: %v", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	if h.topics != nil {
+		h.topics.record(body)
+	}
+
+	// Telegram повторит доставку, если ответить ошибкой
+	if err := h.dispatch(update); err != nil {
+		log.Printf("[Webhook] Обновление %d не принято: %v", update.UpdateID, err)
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// setWebhook регистрирует URL вебхука в Telegram вместе с secret_token
+// (в telegram-bot-api v5.5.1 нет поля secret_token, поэтому вызываем метод напрямую)
+func (b *Bot) setWebhook() error {
+	params := tgbotapi.Params{}
+	params["url"] = b.config().WebhookURL
+	params.AddNonEmpty("secret_token", b.config().WebhookSecret)
+
+	var err error
+	if b.config().WebhookCert != "" && b.config().WebhookUploadCert {
+		// Самоподписанный сертификат нужно передать Telegram
+		_, err = b.tgBot.UploadFiles("setWebhook", params, []tgbotapi.RequestFile{{
+			Name: "certificate",
+			Data: tgbotapi.FilePath(b.config().WebhookCert),
+		}})
+	} else {
+		_, err = b.tgBot.MakeRequest("setWebhook", params)
+	}
+	if err != nil {
+		return fmt.Errorf("ошибка setWebhook: %v", err)
+	}
+
+	info, err := b.tgBot.GetWebhookInfo()
+	if err != nil {
+		log.Printf("[Webhook] Не удалось получить информацию о вебхуке: %v", err)
+		return nil
+	}
+	log.Printf("[Webhook] Зарегистрирован %s, ожидает обновлений: %d", info.URL, info.PendingUpdateCount)
+	if info.LastErrorDate != 0 {
+		log.Printf("[Webhook] Последняя ошибка доставки: %s", info.LastErrorMessage)
+	}
+	return nil
+}
+
+// runWebhook запускает HTTP сервер вебхука и работает до отмены ctx
+func (b *Bot) runWebhook(ctx context.Context) error {
+	if b.config().WebhookURL == "" {
+		return fmt.Errorf("для BOT_MODE=webhook нужен WEBHOOK_URL")
+	}
+	if b.config().WebhookSecret == "" {
+		log.Printf("[Webhook] WEBHOOK_SECRET не задан, заголовок %s не проверяется", WEBHOOK_SECRET_HEADER)
+	}
+
+	if err := b.setWebhook(); err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(b.config().WebhookPath, NewWebhookHandler(b.config().WebhookSecret, b.dispatchUpdate, b.topics))
+
+	server := &http.Server{
+		Addr:              b.config().WebhookListen,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       30 * time.Second,
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		var err error
+		if b.config().WebhookCert != "" && b.config().WebhookKey != "" {
+			log.Printf("[Webhook] HTTPS сервер на %s%s", server.Addr, b.config().WebhookPath)
+			err = server.ListenAndServeTLS(b.config().WebhookCert, b.config().WebhookKey)
+		} else {
+			// TLS терминируется на reverse proxy (nginx, caddy)
+			log.Printf("[Webhook] HTTP сервер на %s%s", server.Addr, b.config().WebhookPath)
+			err = server.ListenAndServe()
+		}
+		errCh <- err
+	}()
+
+	select {
+	case err := <-errCh:
+		if errors.Is(err, http.ErrServerClosed) {
+			return nil
+		}
+		return fmt.Errorf("ошибка HTTP сервера вебхука: %w", err)
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			log.Printf("[Webhook] Ошибка остановки HTTP сервера: %v", err)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// RECORDED_UPDATE обновление, записанное с вебхука: сообщение в теме форума
+const RECORDED_UPDATE = `{
+	"update_id": 815231047,
+	"message": {
+		"message_id": 4521,
+		"message_thread_id": 4500,
+		"is_topic_message": true,
+		"from": {"id": 152657363, "is_bot": false, "first_name": "Wolf", "username": "wrwfx", "language_code": "ru"},
+		"chat": {"id": -1002478281670, "title": "АтипичныйЧат", "type": "supergroup", "is_forum": true},
+		"date": 1760000000,
+		"text": "/summary 2h",
+		"entities": [{"offset": 0, "length": 8, "type": "bot_command"}]
+	}
+}`
+
+const TEST_WEBHOOK_SECRET = "s3cr3t-token"
+
+func TestWebhookHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		secret     string
+		body       string
+		status     int
+		dispatched bool
+	}{
+		{name: "верный secret_token", method: http.MethodPost, secret: TEST_WEBHOOK_SECRET, body: RECORDED_UPDATE, status: http.StatusOK, dispatched: true},
+		{name: "неверный secret_token", method: http.MethodPost, secret: "wrong", body: RECORDED_UPDATE, status: http.StatusForbidden},
+		{name: "без secret_token", method: http.MethodPost, body: RECORDED_UPDATE, status: http.StatusForbidden},
+		{name: "не JSON", method: http.MethodPost, secret: TEST_WEBHOOK_SECRET, body: "not json", status: http.StatusBadRequest},
+		{name: "GET", method: http.MethodGet, secret: TEST_WEBHOOK_SECRET, status: http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var dispatched []tgbotapi.Update
+			topics := newMessageTopics()
+			handler := NewWebhookHandler(TEST_WEBHOOK_SECRET, func(update tgbotapi.Update) error {
+				dispatched = append(dispatched, update)
+				return nil
+			}, topics)
+			server := httptest.NewServer(handler)
+			defer server.Close()
+
+			request, err := http.NewRequest(tt.method, server.URL, strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.secret != "" {
+				request.Header.Set(WEBHOOK_SECRET_HEADER, tt.secret)
+			}
+			response, err := server.Client().Do(request)
+			if err != nil {
+				t.Fatal(err)
+			}
+			response.Body.Close()
+
+			if response.StatusCode != tt.status {
+				t.Errorf("статус %d, ожидался %d", response.StatusCode, tt.status)
+			}
+			if !tt.dispatched {
+				if len(dispatched) != 0 {
+					t.Errorf("обновление не должно передаваться в обработку: %+v", dispatched)
+				}
+				return
+			}
+
+			if len(dispatched) != 1 {
+				t.Fatalf("передано обновлений: %d, ожидалось 1", len(dispatched))
+			}
+			update := dispatched[0]
+			if update.UpdateID != 815231047 || update.Message == nil || update.Message.Command() != "summary" {
+				t.Errorf("обновление разобрано неверно: %+v", update)
+			}
+			if thread := topics.Get(-1002478281670, 4521); thread != 4500 {
+				t.Errorf("тема форума %d, ожидалась 4500", thread)
+			}
+		})
+	}
+}